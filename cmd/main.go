@@ -1,91 +1,316 @@
 package main
 
 import (
+	"context"
 	"database/sql"
-	"log"
+	"fmt"
+	"log/slog"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
+	"sync"
 	"syscall"
+	"time"
+	_ "time/tzdata" // Embedded zoneinfo for target schedules; the runtime image has none
 
+	_ "github.com/jackc/pgx/v5/stdlib" // PostgreSQL driver, registered as "pgx"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
 	_ "modernc.org/sqlite" // SQLite driver
 
 	"github.com/you/linkwatch/internal/checker"
 	"github.com/you/linkwatch/internal/config"
 	httpapi "github.com/you/linkwatch/internal/http" // renamed for clarity
+	"github.com/you/linkwatch/internal/metrics"
+	"github.com/you/linkwatch/internal/model"
 	"github.com/you/linkwatch/internal/store"
 )
 
+// Set at build time, e.g. -ldflags "-X main.Version=v1.2.3" (see the Makefile)
+var (
+	Version   = "dev"
+	GitCommit = "unknown"
+	BuildTime = "unknown"
+)
+
 func main() {
 	cfg := loadConfig()
-	db := connectDatabase(cfg.DatabaseURL)
+	logger := slog.Default()
+	postgres := isPostgresURL(cfg.DatabaseURL)
+	db := connectDatabase(cfg.DatabaseURL, postgres, store.SQLitePragmas{
+		JournalMode: cfg.SQLiteJournalMode,
+		Synchronous: cfg.SQLiteSynchronous,
+		CacheSize:   cfg.SQLiteCacheSize,
+	})
 	defer db.Close()
 
-	runMigrations(db)
+	runMigrations(db, postgres, cfg.StrictMigrations)
+
+	sqlStore := store.NewSQLiteStore(db)
+	var st store.Store = sqlStore
+	if postgres {
+		pg := store.NewPostgresStore(db)
+		sqlStore, st = pg.SQLiteStore, pg
+	}
+	sqlStore.SetQueryTimeout(cfg.DBQueryTimeout)
+	sqlStore.SetWriteRetries(cfg.DBWriteRetries)
+	sqlStore.SetSuccessStatuses(cfg.SuccessStatuses)
+	sqlStore.SetFailureThreshold(cfg.FailureThreshold)
+	if cfg.IDStrategy == "ulid" {
+		sqlStore.SetIDGenerator(store.NewULIDGenerator())
+	}
+	broker := checker.NewBroker(0)
+	mtr := newMetrics()
+
+	var notifier *checker.Notifier
+	if cfg.WebhookURL != "" {
+		notifier = checker.NewNotifier(cfg.WebhookURL, checker.NotifierOptions{
+			Timeout:      cfg.WebhookTimeout,
+			QueueSize:    cfg.WebhookQueueSize,
+			Retries:      cfg.WebhookRetries,
+			RetryBackoff: cfg.WebhookRetryBackoff,
+			Metrics:      mtr,
+			Logger:       logger,
+		})
+	}
+	chk := checker.NewChecker(st, checker.Options{
+		CheckInterval:     cfg.CheckInterval,
+		HTTPTimeout:       cfg.HTTPTimeout,
+		ShutdownGrace:     cfg.ShutdownGrace,
+		MaxConcurrency:    cfg.MaxConcurrency,
+		MinCheckInterval:  cfg.MinCheckInterval,
+		FastRetryInterval: cfg.FastRetryInterval,
+		FastRetryAttempts: cfg.FastRetryAttempts,
+		NodeID:            cfg.NodeID,
+		LeaderElection:    cfg.LeaderElection,
+		LeaseTTL:          cfg.LeaderLeaseTTL,
+		Broker:            broker,
+		MaxStaleness:      cfg.MaxStaleness,
+		ResultRetention:   cfg.ResultRetention,
+		PruneInterval:     cfg.PruneInterval,
+		CheckMethod:       cfg.CheckMethod,
+		Retries:           cfg.CheckRetries,
+		RetryBackoff:      cfg.CheckRetryBackoff,
+		MaxRedirects:      &cfg.MaxRedirects,
+		Metrics:           mtr,
+		MaxBodyBytes:      int64(cfg.MaxBodyBytes),
+		Notifier:          notifier,
+
+		PerHostConcurrency: cfg.PerHostConcurrency,
+		HostConcurrency:    cfg.PerHostConcurrencyOverrides,
+
+		CheckJitter: cfg.CheckJitter,
+
+		UserAgent: cfg.UserAgent,
+		Logger:    logger,
+
+		BlockPrivateIPs: cfg.BlockPrivateIPs,
+		ProxyURL:        cfg.HTTPProxyURL,
+		SOCKS5Proxy:     cfg.SOCKS5Proxy,
+		ClaimTargets:    cfg.ClaimTargets,
+
+		SuccessStatuses:  cfg.SuccessStatuses,
+		IdleConnsPerHost: cfg.IdleConnsPerHost,
+		IdleConnTimeout:  cfg.IdleConnTimeout,
+		DedupResults:     cfg.DedupResults,
+		ForceHTTP2:       cfg.ForceHTTP2,
+
+		ResultBatchSize:     cfg.ResultBatchSize,
+		ResultFlushInterval: cfg.ResultFlushInterval,
+	})
 
-	st := store.NewSQLiteStore(db)
-	server := httpapi.NewServer(st)
-	chk := checker.NewChecker(st, cfg.CheckInterval, cfg.HTTPTimeout, cfg.ShutdownGrace, cfg.MaxConcurrency)
+	server := httpapi.NewServer(st, httpapi.Options{
+		MaxResultsWindow:    cfg.MaxResultsWindow,
+		RejectOutsideWindow: cfg.ResultsWindowMode == "reject",
+		Broker:              broker,
+		InFlight:            chk.InFlight,
+		CheckOnce:           chk.CheckOnce,
+		MaxRetention:        cfg.MaxResultRetention,
+		Metrics:             mtr,
+
+		CursorSecret:         []byte(cfg.CursorSecret),
+		AllowUnsignedCursors: cfg.AllowUnsignedCursors,
+
+		IdempotencyTTL: cfg.IdempotencyTTL,
+		APITokens:      cfg.APITokens,
+		RateLimitRPS:   cfg.RateLimitRPS,
+		RateLimitBurst: cfg.RateLimitBurst,
+
+		Build: httpapi.BuildInfo{Version: Version, GitCommit: GitCommit, BuildTime: BuildTime},
+
+		Canonicalize: model.CanonicalizeOptions{StripWWW: cfg.StripWWW, RootPath: cfg.RootPathStyle},
+		MaxURLLength: cfg.MaxURLLength,
+		MaxTargets:   cfg.MaxTargets,
+		Logger:       logger,
+
+		MaxRequestBytes: int64(cfg.MaxRequestBytes),
+		BlockPrivateIPs: cfg.BlockPrivateIPs,
+		SuccessStatuses: cfg.SuccessStatuses,
+		HandlerTimeout:  cfg.HTTPHandlerTimeout,
+		CheckDuration:   cfg.CheckDuration(),
+	})
+
+	if cfg.SeedTargetsFile != "" {
+		created, existing, err := seedTargets(context.Background(), st, cfg.SeedTargetsFile, server.CanonicalTargetURL, logger)
+		if err != nil {
+			fatal("failed to seed targets", err)
+		}
+		logger.Info("seeded targets", "file", cfg.SeedTargetsFile, "created", created, "existing", existing)
+	}
 
 	chk.Start()
-	startHTTPServer(server)
+	srv, err := startHTTPServer(cfg.ListenAddr, server.Router())
+	if err != nil {
+		fatal("HTTP server failed to start", err)
+	}
 
-	waitForShutdown(chk)
+	waitForShutdown(srv, chk, cfg.ShutdownGrace)
 }
 
+// loadConfig reads the config and installs the JSON logger it asks for as
+// the default, which also captures the standard log package.
 func loadConfig() *config.Config {
 	cfg, err := config.Load()
 	if err != nil {
-		log.Fatal("Failed to load config:", err)
+		fatal("failed to load config", err)
 	}
-	log.Printf("Loaded config: %+v", cfg)
+	slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: cfg.LogLevel})))
+	slog.Info("loaded config", "config", cfg.String())
 	return cfg
 }
 
-func connectDatabase(dsn string) *sql.DB {
-	db, err := sql.Open("sqlite", dsn)
+// fatal logs err and exits.
+func fatal(msg string, err error) {
+	slog.Error(msg, "error", err)
+	os.Exit(1)
+}
+
+// newMetrics registers the app's collectors plus Go runtime and process stats
+func newMetrics() *metrics.Metrics {
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(collectors.NewGoCollector(), collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+	return metrics.New(reg)
+}
+
+// isPostgresURL reports whether DATABASE_URL points at PostgreSQL rather
+// than a SQLite file.
+func isPostgresURL(dsn string) bool {
+	return strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://")
+}
+
+// connectDatabase opens the database. SQLite connections get pragmas, which
+// are read back so one SQLite ignored, e.g. WAL on :memory:, is logged.
+func connectDatabase(dsn string, postgres bool, pragmas store.SQLitePragmas) *sql.DB {
+	driver, source := "sqlite", pragmas.DSN(dsn)
+	if postgres {
+		driver, source = "pgx", dsn
+	}
+	db, err := sql.Open(driver, source)
 	if err != nil {
-		log.Fatal("Failed to connect to database:", err)
+		fatal("failed to connect to database", err)
+	}
+	if !postgres {
+		if err := store.VerifyPragmas(context.Background(), db, dsn, pragmas); err != nil {
+			slog.Warn("sqlite pragma not applied", "error", err)
+		}
 	}
-	log.Println("Database connection established")
+	slog.Info("database connection established")
 	return db
 }
 
-func runMigrations(db *sql.DB) {
-	log.Println("Starting migrations...")
+func runMigrations(db *sql.DB, postgres, strict bool) {
+	slog.Info("starting migrations")
 
-	if _, err := os.Stat("migrations"); os.IsNotExist(err) {
-		log.Fatal("migrations directory does not exist")
+	dir, run := "migrations", store.RunMigrations
+	if postgres {
+		dir, run = "migrations/postgres", store.RunPostgresMigrations
+	}
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		fatal("cannot continue without database schema", fmt.Errorf("migrations directory %s does not exist", dir))
 	}
 
-	err := store.RunMigrations(db, "migrations")
+	err := run(db, dir, strict)
 	if err != nil {
-		log.Printf("Migration failed: %v", err)
-		log.Fatal("Cannot continue without database schema")
+		fatal("cannot continue without database schema", err)
 	}
-	log.Println("Database migrations complete")
+	slog.Info("database migrations complete")
 }
 
-func startHTTPServer(server *httpapi.Server) {
-	addr := ":8080"
-	log.Printf("Starting HTTP server on %s", addr)
+// startHTTPServer binds addr and serves handler in the background. Binding
+// happens up front so a taken port is reported to the caller; the returned
+// server's Addr is the address actually bound.
+func startHTTPServer(addr string, handler http.Handler) (*http.Server, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	srv := &http.Server{Addr: ln.Addr().String(), Handler: handler}
+	slog.Info("starting HTTP server", "addr", srv.Addr)
 
 	go func() {
-		if err := http.ListenAndServe(addr, server.Router()); err != nil && err != http.ErrServerClosed {
-			log.Printf("HTTP server failed to start: %v", err)
-			os.Exit(1)
+		if err := srv.Serve(ln); err != nil && err != http.ErrServerClosed {
+			slog.Error("HTTP server stopped", "error", err)
 		}
 	}()
+	return srv, nil
 }
 
-func waitForShutdown(chk *checker.Checker) {
+// waitForShutdown blocks until SIGTERM or SIGINT, then shuts down. SIGHUP
+// reloads the configuration in the meantime.
+func waitForShutdown(srv *http.Server, chk *checker.Checker, grace time.Duration) {
 	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGTERM, syscall.SIGINT)
+	signal.Notify(sigChan, syscall.SIGTERM, syscall.SIGINT, syscall.SIGHUP)
 
-	<-sigChan
-	log.Println("Shutdown signal received...")
+	for sig := range sigChan {
+		if sig != syscall.SIGHUP {
+			break
+		}
+		reloadConfig(chk)
+	}
+	slog.Info("shutdown signal received")
 
+	// Drain HTTP requests and stop the checker side by side, so the whole
+	// shutdown fits in one grace period
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if err := shutdownHTTPServer(srv, grace); err != nil {
+			slog.Warn("HTTP server shutdown incomplete", "error", err)
+		}
+	}()
 	chk.Shutdown()
+	wg.Wait()
+
+	slog.Info("shutdown complete")
+}
+
+// reloadConfig re-reads the environment and applies what the checker can
+// change live (see Checker.Reload). An invalid config keeps the old one.
+func reloadConfig(chk *checker.Checker) {
+	cfg, err := config.Load()
+	if err != nil {
+		slog.Error("config reload failed, keeping current settings", "error", err)
+		return
+	}
+	chk.Reload(checker.Options{
+		CheckInterval:  cfg.CheckInterval,
+		MaxConcurrency: cfg.MaxConcurrency,
+	})
+	slog.Info("config reloaded", "check_interval", cfg.CheckInterval.String(), "max_concurrency", cfg.MaxConcurrency)
+}
+
+// shutdownHTTPServer stops accepting connections and waits up to grace for
+// in-flight requests, closing whatever is still open after that.
+func shutdownHTTPServer(srv *http.Server, grace time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), grace)
+	defer cancel()
 
-	log.Println("Shutdown complete")
+	if err := srv.Shutdown(ctx); err != nil {
+		srv.Close()
+		return err
+	}
+	return nil
 }
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestShutdownDrainsInFlightRequests(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+		io.WriteString(w, "done")
+	})
+
+	srv, err := startHTTPServer("127.0.0.1:0", handler)
+	if err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+
+	type response struct {
+		body string
+		err  error
+	}
+	responses := make(chan response, 1)
+	go func() {
+		resp, err := http.Get("http://" + srv.Addr)
+		if err != nil {
+			responses <- response{err: err}
+			return
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		responses <- response{body: string(body), err: err}
+	}()
+	<-started
+
+	shutdownErr := make(chan error, 1)
+	go func() { shutdownErr <- shutdownHTTPServer(srv, 5*time.Second) }()
+
+	// Shutdown must wait for the request rather than cut it off
+	select {
+	case err := <-shutdownErr:
+		t.Fatalf("Shutdown returned before the in-flight request finished: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	close(release)
+
+	resp := <-responses
+	if resp.err != nil {
+		t.Fatalf("In-flight request failed: %v", resp.err)
+	}
+	if resp.body != "done" {
+		t.Errorf("Expected body %q, got %q", "done", resp.body)
+	}
+	if err := <-shutdownErr; err != nil {
+		t.Errorf("Expected clean shutdown, got %v", err)
+	}
+
+	if _, err := http.Get("http://" + srv.Addr); err == nil {
+		t.Error("Expected new connections to be refused after shutdown")
+	}
+}
+
+func TestStartHTTPServerReportsBindErrors(t *testing.T) {
+	srv, err := startHTTPServer("127.0.0.1:0", http.NotFoundHandler())
+	if err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	defer srv.Close()
+
+	if _, err := startHTTPServer(srv.Addr, http.NotFoundHandler()); err == nil {
+		t.Error("Expected an error binding an address already in use")
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/you/linkwatch/internal/store"
+)
+
+// canonicalizer checks and canonicalizes a target URL, see
+// httpapi.Server.CanonicalTargetURL
+type canonicalizer func(ctx context.Context, raw string) (canonicalURL, host string, err error)
+
+// seedTargets upserts every URL listed in path, checked and canonicalized by
+// canon as the API would, and reports how many targets it created and how
+// many already existed. The file is a JSON array of URLs, or one URL per
+// line with blank lines and # comments ignored. Invalid URLs, including ones
+// the API would refuse, are logged and skipped; only an unreadable file or
+// a failing store is an error.
+func seedTargets(ctx context.Context, st store.Store, path string, canon canonicalizer, logger *slog.Logger) (created, existing int, err error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, 0, fmt.Errorf("read seed file: %w", err)
+	}
+	urls, err := parseSeedFile(data)
+	if err != nil {
+		return 0, 0, fmt.Errorf("parse seed file %s: %w", path, err)
+	}
+
+	for _, raw := range urls {
+		canonicalURL, host, err := canon(ctx, raw)
+		if err != nil {
+			logger.Warn("skipping invalid seed URL", "file", path, "url", raw, "error", err)
+			continue
+		}
+		_, isNew, err := st.UpsertTargetByURL(ctx, canonicalURL, host, store.TargetSettings{})
+		if err != nil {
+			return created, existing, fmt.Errorf("seed %s: %w", canonicalURL, err)
+		}
+		if isNew {
+			created++
+		} else {
+			existing++
+		}
+	}
+	return created, existing, nil
+}
+
+// parseSeedFile reads a JSON array when the file starts with [, and lines otherwise
+func parseSeedFile(data []byte) ([]string, error) {
+	if trimmed := bytes.TrimSpace(data); bytes.HasPrefix(trimmed, []byte("[")) {
+		var urls []string
+		if err := json.Unmarshal(trimmed, &urls); err != nil {
+			return nil, err
+		}
+		return urls, nil
+	}
+
+	var urls []string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		urls = append(urls, line)
+	}
+	return urls, scanner.Err()
+}
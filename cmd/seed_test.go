@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	httpapi "github.com/you/linkwatch/internal/http"
+	"github.com/you/linkwatch/internal/store"
+)
+
+func TestSeedTargets(t *testing.T) {
+	db, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatalf("Failed to create test database: %v", err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+	if err := store.RunMigrations(db, "../migrations", true); err != nil {
+		t.Fatalf("Failed to run migrations: %v", err)
+	}
+	st := store.NewSQLiteStore(db)
+	ctx := context.Background()
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	canon := httpapi.NewServer(st, httpapi.Options{Logger: logger}).CanonicalTargetURL
+
+	dir := t.TempDir()
+	lines := filepath.Join(dir, "targets.txt")
+	content := "# Monitored URLs\n" +
+		"https://example.com\n" +
+		"\n" +
+		"HTTPS://Example.com:443/\n" + // The same target once canonicalized
+		"ftp://example.com/file\n" +
+		"not a url\n" +
+		"  https://example.org/health  \n"
+	if err := os.WriteFile(lines, []byte(content), 0o644); err != nil {
+		t.Fatalf("Failed to write seed file: %v", err)
+	}
+
+	created, existing, err := seedTargets(ctx, st, lines, canon, logger)
+	if err != nil {
+		t.Fatalf("Seeding failed: %v", err)
+	}
+	if created != 2 || existing != 1 {
+		t.Errorf("Expected 2 created and 1 existing, got %d and %d", created, existing)
+	}
+
+	// Seeding again, e.g. on the next restart, creates nothing
+	created, existing, err = seedTargets(ctx, st, lines, canon, logger)
+	if err != nil {
+		t.Fatalf("Reseeding failed: %v", err)
+	}
+	if created != 0 || existing != 3 {
+		t.Errorf("Expected 0 created and 3 existing on reseed, got %d and %d", created, existing)
+	}
+
+	list := filepath.Join(dir, "targets.json")
+	if err := os.WriteFile(list, []byte(`["https://example.net", "mailto:ops@example.net", "https://example.com"]`), 0o644); err != nil {
+		t.Fatalf("Failed to write seed file: %v", err)
+	}
+	created, existing, err = seedTargets(ctx, st, list, canon, logger)
+	if err != nil {
+		t.Fatalf("Seeding from JSON failed: %v", err)
+	}
+	if created != 1 || existing != 1 {
+		t.Errorf("Expected 1 created and 1 existing from JSON, got %d and %d", created, existing)
+	}
+
+	if count, err := st.CountTargets(ctx, store.HostFilter{}); err != nil || count != 3 {
+		t.Errorf("Expected 3 targets in the store, got %d (%v)", count, err)
+	}
+
+	if err := os.WriteFile(list, []byte(`["https://example.net",`), 0o644); err != nil {
+		t.Fatalf("Failed to write seed file: %v", err)
+	}
+	if _, _, err := seedTargets(ctx, st, list, canon, logger); err == nil {
+		t.Error("Expected malformed JSON to be an error")
+	}
+	if _, _, err := seedTargets(ctx, st, filepath.Join(dir, "missing.txt"), canon, logger); err == nil {
+		t.Error("Expected a missing file to be an error")
+	}
+
+	// URLs the API would refuse are skipped like invalid ones
+	guarded := httpapi.NewServer(st, httpapi.Options{Logger: logger, BlockPrivateIPs: true, MaxURLLength: 40}).CanonicalTargetURL
+	if err := os.WriteFile(lines, []byte("http://127.0.0.1/admin\nhttp://93.184.215.14/"+strings.Repeat("a", 40)+"\nhttp://93.184.215.14/\n"), 0o644); err != nil {
+		t.Fatalf("Failed to write seed file: %v", err)
+	}
+	created, existing, err = seedTargets(ctx, st, lines, guarded, logger)
+	if err != nil {
+		t.Fatalf("Seeding with the guard failed: %v", err)
+	}
+	if created != 1 || existing != 0 {
+		t.Errorf("Expected only the short public URL created, got %d created and %d existing", created, existing)
+	}
+}
@@ -0,0 +1,110 @@
+package checker
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/you/linkwatch/internal/store"
+)
+
+// defaultResultFlushInterval applies when Options.ResultFlushInterval is zero
+const defaultResultFlushInterval = time.Second
+
+// resultBatcher buffers scheduled checks' results and stores them with one
+// InsertCheckResults per batch instead of a transaction each. A batch holds
+// at most one result per target, so each result's transition is judged
+// against the state before it: a second result for a target flushes the
+// batch holding the first.
+type resultBatcher struct {
+	c        *Checker
+	size     int
+	interval time.Duration
+
+	mu      sync.Mutex
+	pending []pendingResult
+	queued  map[string]bool // Target IDs with a result in pending
+	timer   *time.Timer     // Flushes pending after interval, nil while it's empty
+
+	flushMutex sync.Mutex // Stores one batch at a time, so batches land in order
+}
+
+// pendingResult is a result waiting in the batch with the target it's for
+type pendingResult struct {
+	target *store.Target
+	result *store.CheckResult
+}
+
+func newResultBatcher(c *Checker, size int, interval time.Duration) *resultBatcher {
+	if interval <= 0 {
+		interval = defaultResultFlushInterval
+	}
+	return &resultBatcher{c: c, size: size, interval: interval, queued: make(map[string]bool)}
+}
+
+// add buffers a result, flushing the batch once it's full.
+func (b *resultBatcher) add(target *store.Target, result *store.CheckResult) {
+	b.mu.Lock()
+	for b.queued[target.ID] {
+		b.mu.Unlock()
+		b.flush()
+		b.mu.Lock()
+	}
+	b.pending = append(b.pending, pendingResult{target: target, result: result})
+	b.queued[target.ID] = true
+	full := len(b.pending) >= b.size
+	if !full && b.timer == nil {
+		b.timer = time.AfterFunc(b.interval, b.flush)
+	}
+	b.mu.Unlock()
+
+	if full {
+		b.flush()
+	}
+}
+
+// flush stores the pending results in the order they were added, then logs,
+// publishes and notifies on each as check does after storing one alone.
+func (b *resultBatcher) flush() {
+	b.flushMutex.Lock()
+	defer b.flushMutex.Unlock()
+
+	b.mu.Lock()
+	batch := b.pending
+	b.pending = nil
+	clear(b.queued)
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+	b.mu.Unlock()
+	if len(batch) == 0 {
+		return
+	}
+
+	results := make([]*store.CheckResult, len(batch))
+	for i, p := range batch {
+		results[i] = p.result
+	}
+
+	// Shutdown flushes after the checker's context is cancelled; the
+	// store's query timeout still bounds it
+	err := b.c.store.InsertCheckResults(context.WithoutCancel(b.c.ctx), results)
+	var batchErr *store.BatchError
+	failedAlone := errors.As(err, &batchErr)
+	for i, p := range batch {
+		resultErr := err
+		if failedAlone {
+			resultErr = batchErr.Errs[i]
+		}
+		b.c.saved(p.target, p.result, resultErr)
+	}
+}
+
+// flushResults stores any buffered results; without batching there are none.
+func (c *Checker) flushResults() {
+	if c.results != nil {
+		c.results.flush()
+	}
+}
@@ -0,0 +1,109 @@
+package checker
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/you/linkwatch/internal/store"
+)
+
+// defaultSubscriberBuffer is how many results a subscriber may lag behind
+// before new results are dropped for it.
+const defaultSubscriberBuffer = 64
+
+// Filter narrows a subscription. Empty fields match everything.
+type Filter struct {
+	TargetID string
+	Host     string
+}
+
+func (f Filter) matches(target *store.Target) bool {
+	if f.TargetID != "" && f.TargetID != target.ID {
+		return false
+	}
+	if f.Host != "" && f.Host != target.Host {
+		return false
+	}
+	return true
+}
+
+// Broker fans check results out to live subscribers.
+// Publishing never blocks: a subscriber whose buffer is full misses results
+// rather than stalling the checker.
+type Broker struct {
+	mu          sync.RWMutex
+	subscribers map[*Subscription]struct{}
+	bufferSize  int
+}
+
+// Subscription receives results matching its filter until closed.
+type Subscription struct {
+	broker  *Broker
+	filter  Filter
+	events  chan *store.CheckResult
+	dropped atomic.Uint64
+	once    sync.Once
+}
+
+// NewBroker creates a broker whose subscribers buffer up to bufferSize results.
+func NewBroker(bufferSize int) *Broker {
+	if bufferSize <= 0 {
+		bufferSize = defaultSubscriberBuffer
+	}
+	return &Broker{
+		subscribers: make(map[*Subscription]struct{}),
+		bufferSize:  bufferSize,
+	}
+}
+
+// Subscribe registers a new subscriber. Callers must Close it when done.
+func (b *Broker) Subscribe(filter Filter) *Subscription {
+	sub := &Subscription{
+		broker: b,
+		filter: filter,
+		events: make(chan *store.CheckResult, b.bufferSize),
+	}
+
+	b.mu.Lock()
+	b.subscribers[sub] = struct{}{}
+	b.mu.Unlock()
+
+	return sub
+}
+
+// Publish delivers a result to every matching subscriber without blocking.
+func (b *Broker) Publish(target *store.Target, result *store.CheckResult) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	for sub := range b.subscribers {
+		if !sub.filter.matches(target) {
+			continue
+		}
+		select {
+		case sub.events <- result:
+		default:
+			sub.dropped.Add(1)
+		}
+	}
+}
+
+// Events returns the channel results are delivered on. It is closed by Close.
+func (s *Subscription) Events() <-chan *store.CheckResult {
+	return s.events
+}
+
+// Dropped reports how many results were skipped because the subscriber fell behind.
+func (s *Subscription) Dropped() uint64 {
+	return s.dropped.Load()
+}
+
+// Close unregisters the subscription. It is safe to call more than once.
+func (s *Subscription) Close() {
+	s.once.Do(func() {
+		s.broker.mu.Lock()
+		delete(s.broker.subscribers, s)
+		s.broker.mu.Unlock()
+		close(s.events)
+	})
+}
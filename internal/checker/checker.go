@@ -2,53 +2,461 @@ package checker
 
 import (
 	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net"
 	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"golang.org/x/net/proxy"
+
+	"github.com/you/linkwatch/internal/metrics"
+	"github.com/you/linkwatch/internal/model"
 	"github.com/you/linkwatch/internal/store"
 )
 
 // Checker manages background URL checking.
 type Checker struct {
-	store           store.Store       // Database store
-	checkInterval   time.Duration     // How often to check all targets
-	maxConcurrency  int               // Max checks running in parallel
-	httpTimeout     time.Duration     // Timeout for each HTTP request
-	shutdownGrace   time.Duration     // How long to wait before forced shutdown
+	store          store.Store   // Database store
+	checkInterval  time.Duration // How often to check all targets, guarded by reloadMutex
+	maxConcurrency int           // Max checks running in parallel, guarded by reloadMutex
+	httpTimeout    time.Duration // Timeout for each HTTP request
+	shutdownGrace  time.Duration // How long to wait before forced shutdown
+	nodeID         string        // Identity recorded on each result
+	broker         *Broker       // Live result subscribers, may be nil
+	maxStaleness   time.Duration // Longest a target may go unchecked (0 disables)
+	checkMethod    string        // GET, HEAD, or AUTO (HEAD with GET fallback)
+	retries        int           // Extra attempts after a transient failure
+	retryBackoff   time.Duration // Delay before the first retry, doubling after each
+	maxRedirects   int           // Redirects followed before the check fails
+	maxBodyBytes   int64         // Body bytes hashed for change detection (0 disables)
+	metrics        *metrics.Metrics
+
+	minCheckInterval time.Duration // Floor checkInterval is clamped to
+
+	resultRetention time.Duration // Default result retention (0 keeps forever)
+	pruneInterval   time.Duration // How often expired results are purged (0 disables)
+
+	fastRetryInterval time.Duration         // Recheck delay after a failure (0 disables)
+	fastRetryAttempts int                   // Max fast rechecks per failure streak
+	fastRetries       map[string]*fastRetry // Fast-retry state per target ID
+	fastRetryMutex    sync.Mutex
+
+	dedupResults    bool               // Fold results identical to the previous one into it
+	results         *resultBatcher     // Buffers scheduled results to store together, nil stores each at once
+	successStatuses model.StatusRanges // Statuses a check succeeds with, unless the target expects one
+
+	leaderElection bool          // Only schedule checks while holding the lease
+	leaseTTL       time.Duration // Scheduler lease lifetime
+	leader         atomic.Bool   // Whether this node currently holds the lease
+
+	transport http.RoundTripper // Routes checks through the proxies; tests swap it
+	notifier  *Notifier         // Receives up/down transitions, may be nil
+
+	notifyCancel context.CancelFunc // Cuts the notifier off once shutdown runs out of time
+	notifyDone   chan struct{}      // Closed when the notifier's worker returns
+
+	heldStates map[string]string // Last notified state per target with a transition held back by maintenance
+	heldMutex  sync.Mutex
+
+	insecureTransport http.RoundTripper // Like transport, skipping certificate verification
+
+	hostSemaphores map[string]*hostSemaphore // Per-host semaphores, only while in use
+	hostMutex      sync.Mutex
+
+	inFlight      map[string]int // Checks running per target ID, see InFlight
+	inFlightMutex sync.Mutex
+
+	perHostConcurrency int            // Parallel checks per host by default
+	hostConcurrency    map[string]int // Per-host overrides of perHostConcurrency
+
+	checkJitter float64 // Fraction of checkInterval each pass is spread over
+
+	userAgent string // User-Agent sent unless the target's headers set one
+
+	logger *slog.Logger
+
+	claimTargets bool // Claim due targets from the store instead of fetching all
+
+	reloadMutex sync.RWMutex
+	reloaded    chan struct{} // Signals the scheduler to pick up a new checkInterval
+
+	jobs        chan *store.Target // Targets waiting for a worker
+	retire      chan struct{}      // Each receive stops one worker after a shrink
+	poolStarted bool               // Whether Start has launched the workers
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// Options configures a Checker.
+type Options struct {
+	CheckInterval  time.Duration // How often to check all targets
+	HTTPTimeout    time.Duration // Timeout for each HTTP request
+	ShutdownGrace  time.Duration // How long to wait before forced shutdown
+	MaxConcurrency int           // Max checks running in parallel
+
+	// MinCheckInterval is the shortest CheckInterval the checker will run at,
+	// on creation or reload; anything below it is raised to it. Zero disables it.
+	MinCheckInterval time.Duration
+
+	// After a failed check, recheck every FastRetryInterval until the target
+	// recovers or FastRetryAttempts rechecks have been made. Zero disables it.
+	FastRetryInterval time.Duration
+	FastRetryAttempts int
+
+	// DedupResults stores a result that matches the target's previous one
+	// (status, error and body hash) by counting it on that row instead.
+	DedupResults bool
+
+	// SuccessStatuses are the statuses a check succeeds with, for retries,
+	// fast rechecks and metrics, unless the target sets expected_status.
+	// Empty means model.DefaultSuccessStatuses. The store needs the same.
+	SuccessStatuses model.StatusRanges
+
+	NodeID string // Recorded on every result this checker produces
+
+	// With LeaderElection, nodes contend for a store-backed lease and only the
+	// holder schedules checks. LeaseTTL bounds how long a dead leader blocks others.
+	LeaderElection bool
+	LeaseTTL       time.Duration
+
+	Broker *Broker // Receives every newly stored result for live streaming (optional)
+
+	// MaxStaleness guarantees every target is checked at least this often,
+	// catching any the regular pass missed. Zero disables the sweep.
+	MaxStaleness time.Duration
+
+	// Every PruneInterval, results older than their target's retention are
+	// deleted; targets without an override use ResultRetention (0 keeps forever).
+	// A zero PruneInterval disables pruning entirely.
+	ResultRetention time.Duration
+	PruneInterval   time.Duration
+
+	// CheckMethod is GET, HEAD, or MethodAuto, which sends HEAD and retries
+	// with GET when the server doesn't support it. Empty means MethodAuto.
+	CheckMethod string
+
+	// A check that times out, fails to connect, or gets a 5xx is retried up
+	// to Retries times, waiting RetryBackoff and then doubling it between
+	// tries. Only the final attempt is stored.
+	Retries      int
+	RetryBackoff time.Duration
+
+	// MaxRedirects fails a check that is redirected more often than this;
+	// zero fails it on any redirect. Nil uses net/http's default of 10.
+	MaxRedirects *int
+
+	Metrics *metrics.Metrics // Check counters and latency (optional)
+
+	// MaxBodyBytes of each GET response body are hashed so content changes
+	// show up in results. Zero disables hashing. It also caps how much of any
+	// body is read, 1MB when zero; longer bodies are flagged as truncated.
+	MaxBodyBytes int64
+
+	Notifier *Notifier // Sent a Transition whenever a target flips up/down (optional)
+
+	// PerHostConcurrency caps parallel checks against one host; zero means 2.
+	// HostConcurrency overrides it for specific hosts, keyed like Target.Host.
+	PerHostConcurrency int
+	HostConcurrency    map[string]int
+
+	// CheckJitter delays each target of a scheduling pass by a random amount
+	// up to this fraction of CheckInterval, smoothing out load. Zero disables it.
+	CheckJitter float64
+
+	// UserAgent is sent with every check; a target's own User-Agent header
+	// takes precedence. Empty means defaultUserAgent.
+	UserAgent string
+
+	// Logger receives the checker's logs; nil means slog.Default().
+	Logger *slog.Logger
+
+	// BlockPrivateIPs refuses connections to loopback, link-local and private
+	// addresses, checked on every dial so DNS rebinding and redirects to
+	// internal hosts are caught too.
+	BlockPrivateIPs bool
+
+	// ResultBatchSize buffers scheduled checks' results and stores that
+	// many at a time, in one transaction, rather than each on its own. A
+	// partial batch is stored ResultFlushInterval after its first result,
+	// before the next pass and at shutdown. Results are published and
+	// notified on once stored. Zero stores each result straight away, as
+	// on-demand checks always are. A zero interval means a second.
+	ResultBatchSize     int
+	ResultFlushInterval time.Duration
+
+	// ProxyURL sends checks through this proxy unless the target sets its
+	// own; nil falls back to the HTTP_PROXY, HTTPS_PROXY and NO_PROXY
+	// environment variables. It is trusted, so BlockPrivateIPs doesn't
+	// apply to it.
+	ProxyURL *url.URL
+
+	// SOCKS5Proxy dials every connection through this SOCKS5 gateway,
+	// including those to a target's own proxy; the environment's proxies are
+	// then ignored. The gateway resolves and connects to hosts itself, out
+	// of reach of BlockPrivateIPs, so config refuses the two together. Set
+	// it or ProxyURL, not both.
+	SOCKS5Proxy *url.URL
+
+	// IdleConnsPerHost is how many keep-alive connections to each host are
+	// kept for reuse between checks, and IdleConnTimeout how long one may
+	// sit unused. Zero means defaultIdleConnsPerHost and
+	// defaultIdleConnTimeout.
+	IdleConnsPerHost int
+	IdleConnTimeout  time.Duration
+
+	// ClaimTargets makes each pass claim only targets that are due, marking
+	// them in the store so other instances sharing it skip them until the
+	// next interval. Unlike LeaderElection, every instance does a share of
+	// the checks.
+	ClaimTargets bool
+
+	// ForceHTTP2 true checks only over HTTP/2, also without TLS for http://
+	// targets, so servers that can't speak it fail; false checks only over
+	// HTTP/1.1. Nil negotiates HTTP/2 over TLS like net/http does.
+	ForceHTTP2 *bool
+}
+
+// targetProxyKey carries a target's own proxy in its request's context.
+type targetProxyKey struct{}
+
+// Keep-alive pool defaults; net/http keeps only 2 idle connections per host
+const (
+	defaultIdleConnsPerHost = 8
+	defaultIdleConnTimeout  = 90 * time.Second
+)
+
+// environmentProxy picks the proxy HTTP_PROXY, HTTPS_PROXY and NO_PROXY
+// name for a request; tests swap it.
+var environmentProxy = http.ProxyFromEnvironment
+
+// checkTransport is the one transport every check shares, so connections to
+// a host are pooled and reused. It sends each request through the proxy its
+// target sets, else through opts.ProxyURL, else through the one named by
+// the environment. With SOCKS5Proxy every connection is dialed through that
+// gateway instead. Otherwise, with BlockPrivateIPs, it refuses to dial
+// private addresses, except the configured proxy's, which the operator chose.
+// The environment's proxies are then ignored: the guard would only see the
+// proxy's address, never the target's.
+func checkTransport(opts Options) *http.Transport {
+	proxy := opts.ProxyURL
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConnsPerHost = opts.IdleConnsPerHost
+	if transport.MaxIdleConnsPerHost <= 0 {
+		transport.MaxIdleConnsPerHost = defaultIdleConnsPerHost
+	}
+	transport.IdleConnTimeout = opts.IdleConnTimeout
+	if transport.IdleConnTimeout <= 0 {
+		transport.IdleConnTimeout = defaultIdleConnTimeout
+	}
+	if opts.ForceHTTP2 != nil {
+		var protocols http.Protocols
+		protocols.SetHTTP1(!*opts.ForceHTTP2)
+		protocols.SetHTTP2(*opts.ForceHTTP2)
+		protocols.SetUnencryptedHTTP2(*opts.ForceHTTP2)
+		transport.Protocols = &protocols
+	}
+	transport.Proxy = func(req *http.Request) (*url.URL, error) {
+		if u, ok := req.Context().Value(targetProxyKey{}).(*url.URL); ok {
+			return u, nil
+		}
+		if proxy != nil {
+			return proxy, nil
+		}
+		if opts.SOCKS5Proxy != nil || opts.BlockPrivateIPs {
+			return nil, nil
+		}
+		return environmentProxy(req)
+	}
+
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}
+	if opts.SOCKS5Proxy != nil {
+		transport.DialContext = socks5Dial(opts.SOCKS5Proxy, dialer)
+		return transport
+	}
+	if !opts.BlockPrivateIPs {
+		return transport
+	}
+
+	public := *dialer
+	public.Control = model.CheckPublicDial
+	transport.DialContext = public.DialContext
+	if proxy != nil {
+		trusted := proxyAddr(proxy)
+		transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			if addr == trusted {
+				return dialer.DialContext(ctx, network, addr)
+			}
+			return public.DialContext(ctx, network, addr)
+		}
+	}
+	return transport
+}
+
+// socks5Dial connects through the SOCKS5 gateway at gateway, reached with
+// forward, passing it the hostname to resolve.
+func socks5Dial(gateway *url.URL, forward *net.Dialer) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	var auth *proxy.Auth
+	if gateway.User != nil {
+		password, _ := gateway.User.Password()
+		auth = &proxy.Auth{User: gateway.User.Username(), Password: password}
+	}
+	// Only building the dialer, SOCKS5 never fails
+	socks, _ := proxy.SOCKS5("tcp", proxyAddr(gateway), auth, forward)
+	return socks.(proxy.ContextDialer).DialContext
+}
+
+// insecureTransport is transport accepting any certificate, for targets
+// setting InsecureSkipVerify. It is a separate transport so connections
+// verified for other targets are never shared with it.
+func insecureTransport(transport *http.Transport) *http.Transport {
+	insecure := transport.Clone()
+	insecure.TLSClientConfig = &tls.Config{InsecureSkipVerify: true}
+	return insecure
+}
+
+// proxyAddr is the host:port the transport dials to reach proxy.
+func proxyAddr(proxy *url.URL) string {
+	if port := proxy.Port(); port != "" {
+		return net.JoinHostPort(proxy.Hostname(), port)
+	}
+	port := map[string]string{"http": "80", "https": "443", "socks5": "1080"}[proxy.Scheme]
+	return net.JoinHostPort(proxy.Hostname(), port)
+}
 
-	workers         chan struct{}     // Semaphore for global concurrency
-	hostSemaphores  map[string]chan struct{} // Per-host semaphores
-	hostMutex       sync.RWMutex
+// defaultUserAgent replaces Go's, which some sites block or throttle.
+const defaultUserAgent = "linkwatch/1.0"
 
-	ctx             context.Context
-	cancel          context.CancelFunc
-	wg              sync.WaitGroup
+// defaultPerHostConcurrency is the per-host cap when none is configured.
+const defaultPerHostConcurrency = 2
+
+// MethodAuto checks with HEAD, falling back to GET on 405 or 501.
+const MethodAuto = "AUTO"
+
+// fastRetry tracks the fast-retry streak of a failing target.
+type fastRetry struct {
+	attempts int  // Fast rechecks made since the target started failing
+	pending  bool // A fast recheck is already scheduled
 }
 
 // NewChecker creates a new URL checker.
-func NewChecker(
-	store store.Store,
-	checkInterval, httpTimeout, shutdownGrace time.Duration,
-	maxConcurrency int,
-) *Checker {
+func NewChecker(store store.Store, opts Options) *Checker {
 	ctx, cancel := context.WithCancel(context.Background())
 
-	return &Checker{
-		store:          store,
-		checkInterval:  checkInterval,
-		maxConcurrency: maxConcurrency,
-		httpTimeout:    httpTimeout,
-		shutdownGrace:  shutdownGrace,
-		workers:        make(chan struct{}, maxConcurrency),
-		hostSemaphores: make(map[string]chan struct{}),
-		ctx:            ctx,
-		cancel:         cancel,
+	userAgent := opts.UserAgent
+	if userAgent == "" {
+		userAgent = defaultUserAgent
+	}
+	maxRedirects := defaultMaxRedirects
+	if opts.MaxRedirects != nil {
+		maxRedirects = *opts.MaxRedirects
+	}
+	logger := opts.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	transport := checkTransport(opts)
+
+	c := &Checker{
+		store:             store,
+		checkInterval:     clampInterval(opts.CheckInterval, opts.MinCheckInterval, logger),
+		minCheckInterval:  opts.MinCheckInterval,
+		maxConcurrency:    opts.MaxConcurrency,
+		httpTimeout:       opts.HTTPTimeout,
+		shutdownGrace:     opts.ShutdownGrace,
+		nodeID:            opts.NodeID,
+		broker:            opts.Broker,
+		maxStaleness:      opts.MaxStaleness,
+		checkMethod:       opts.CheckMethod,
+		retries:           opts.Retries,
+		retryBackoff:      opts.RetryBackoff,
+		maxRedirects:      maxRedirects,
+		metrics:           opts.Metrics,
+		maxBodyBytes:      opts.MaxBodyBytes,
+		notifier:          opts.Notifier,
+		heldStates:        make(map[string]string),
+		resultRetention:   opts.ResultRetention,
+		pruneInterval:     opts.PruneInterval,
+		fastRetryInterval: opts.FastRetryInterval,
+		fastRetryAttempts: opts.FastRetryAttempts,
+		fastRetries:       make(map[string]*fastRetry),
+		dedupResults:      opts.DedupResults,
+		successStatuses:   opts.SuccessStatuses,
+		leaderElection:    opts.LeaderElection,
+		leaseTTL:          opts.LeaseTTL,
+		hostSemaphores:    make(map[string]*hostSemaphore),
+		inFlight:          make(map[string]int),
+		ctx:               ctx,
+		cancel:            cancel,
+
+		perHostConcurrency: opts.PerHostConcurrency,
+		hostConcurrency:    opts.HostConcurrency,
+
+		checkJitter: opts.CheckJitter,
+
+		userAgent: userAgent,
+		logger:    logger,
+		transport: transport,
+
+		insecureTransport: insecureTransport(transport),
+
+		claimTargets: opts.ClaimTargets,
+
+		reloaded: make(chan struct{}, 1),
+
+		jobs:   newJobQueue(opts.MaxConcurrency),
+		retire: make(chan struct{}),
 	}
+	if opts.ResultBatchSize > 0 {
+		c.results = newResultBatcher(c, opts.ResultBatchSize, opts.ResultFlushInterval)
+	}
+	return c
 }
 
-// Start begins the background scheduler.
+// Start begins the background scheduler and its worker pool.
 func (c *Checker) Start() {
+	c.startWorkers()
+
+	if c.leaderElection {
+		c.wg.Add(1)
+		go c.leaseLoop()
+	}
+
+	if c.pruneInterval > 0 {
+		c.wg.Add(1)
+		go c.pruner()
+	}
+
+	// The notifier outlives the checks, so Shutdown can let it deliver
+	// what they queued
+	if c.notifier != nil {
+		var notifyCtx context.Context
+		notifyCtx, c.notifyCancel = context.WithCancel(context.Background())
+		c.notifyDone = make(chan struct{})
+		go func() {
+			defer close(c.notifyDone)
+			c.notifier.run(notifyCtx)
+		}()
+	}
+
 	c.wg.Add(1)
 	go c.scheduler()
 }
@@ -57,114 +465,737 @@ func (c *Checker) Start() {
 func (c *Checker) scheduler() {
 	defer c.wg.Done()
 
-	ticker := time.NewTicker(c.checkInterval)
+	ticker := time.NewTicker(c.interval())
 	defer ticker.Stop()
 
 	for {
 		select {
 		case <-c.ctx.Done():
 			return
+		case <-c.reloaded:
+			ticker.Reset(c.interval())
 		case <-ticker.C:
-			c.scheduleChecks()
+			// The last pass's results are stored before the next begins
+			c.flushResults()
+			if c.isLeader() {
+				c.scheduleChecks()
+			}
 		}
 	}
 }
 
-// scheduleChecks fetches all targets and schedules checks for them.
+// scheduleBatchSize caps how many targets the scheduler fetches at a time.
+const scheduleBatchSize = 1000
+
+// scheduleChecks fetches all targets, or claims the due ones, a batch at a
+// time and schedules checks for them. Only the IDs of scheduled targets are
+// kept across batches.
 func (c *Checker) scheduleChecks() {
-	targets, _, err := c.store.GetTargets(c.ctx, "", time.Time{}, "", 1000)
+	scheduled := make(map[string]bool)
+
+	var cursor *store.Cursor
+	for {
+		targets, next, err := c.passTargets(cursor)
+		if err != nil {
+			c.logger.Error("failed to fetch targets", "error", err)
+			return
+		}
+
+		for _, target := range targets {
+			if !c.dispatchJittered(target) {
+				return
+			}
+			scheduled[target.ID] = true
+		}
+
+		// A short batch is the last one
+		if len(targets) < scheduleBatchSize || c.ctx.Err() != nil {
+			break
+		}
+		cursor = next
+	}
+
+	c.scheduleStaleChecks(scheduled)
+}
+
+// claimTolerance is how much earlier than a full interval a claimed target
+// falls due again, so the next tick finds it due even though the claim was
+// made a moment after this tick fired.
+const claimTolerance = 0.1
+
+// passTargets returns the next batch of targets a scheduling pass should
+// check, starting after cursor (nil for the first batch), and the cursor for
+// the batch after it. Paused targets are left out by the store. Claimed
+// targets stop being due, so claiming needs no cursor: each call picks up
+// where the last left off.
+func (c *Checker) passTargets(cursor *store.Cursor) ([]*store.Target, *store.Cursor, error) {
+	if !c.claimTargets {
+		var afterCreatedAt time.Time
+		var afterID string
+		if cursor != nil {
+			afterCreatedAt, afterID = cursor.CreatedAt, cursor.ID
+		}
+		return c.store.GetEnabledTargets(c.ctx, afterCreatedAt, afterID, scheduleBatchSize)
+	}
+	targets, err := c.store.ClaimDueTargets(c.ctx, time.Now(), c.claimLease(), scheduleBatchSize)
+	return targets, nil, err
+}
+
+// claimLease is how long a claimed target stays claimed.
+func (c *Checker) claimLease() time.Duration {
+	interval := c.interval()
+	return interval - time.Duration(float64(interval)*claimTolerance)
+}
+
+// scheduleStaleChecks checks targets that have gone unchecked for longer than
+// maxStaleness, skipping ones this pass already scheduled. When claiming,
+// stale targets are claimed too, so only one scheduler rechecks each.
+func (c *Checker) scheduleStaleChecks(scheduled map[string]bool) {
+	if c.maxStaleness <= 0 {
+		return
+	}
+
+	now := time.Now()
+	var stale []*store.Target
+	var err error
+	if c.claimTargets {
+		stale, err = c.store.ClaimStaleTargets(c.ctx, now.Add(-c.maxStaleness), now, c.claimLease(), scheduleBatchSize)
+	} else {
+		stale, err = c.store.GetStaleTargets(c.ctx, now.Add(-c.maxStaleness), scheduleBatchSize)
+	}
 	if err != nil {
-		fmt.Println("failed to fetch targets:", err)
+		c.logger.Error("failed to fetch stale targets", "error", err)
 		return
 	}
 
-	for _, target := range targets {
+	for _, target := range stale {
+		if scheduled[target.ID] {
+			continue
+		}
+		if !c.dispatch(target) {
+			return
+		}
+	}
+}
+
+// dispatch queues the target for the worker pool, waiting while the queue
+// is full. Targets outside their active schedule are skipped. It returns
+// false if the checker is shutting down.
+func (c *Checker) dispatch(target *store.Target) bool {
+	if target.Schedule != nil && !target.Schedule.Active(time.Now()) {
+		return true
+	}
+	return c.enqueue(target)
+}
+
+// dispatchJittered dispatches the target after a random delay within the
+// jitter window. Without jitter it is the same as dispatch.
+func (c *Checker) dispatchJittered(target *store.Target) bool {
+	window := time.Duration(float64(c.interval()) * c.checkJitter)
+	if window <= 0 {
+		return c.dispatch(target)
+	}
+
+	select {
+	case <-c.ctx.Done():
+		return false
+	default:
+	}
+
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+
 		select {
 		case <-c.ctx.Done():
 			return
-		case c.workers <- struct{}{}:
-			c.wg.Add(1)
-			go c.checkTarget(target)
+		case <-time.After(rand.N(window)):
 		}
-	}
+		c.dispatch(target)
+	}()
+	return true
 }
 
-// checkTarget performs a single URL check and stores the result.
+// checkTarget performs a single URL check and stores the result, in a
+// batch when batching is on. A failure is rechecked fast if configured.
 func (c *Checker) checkTarget(target *store.Target) {
-	defer c.wg.Done()
-	defer func() { <-c.workers }()
+	result, _ := c.checkAndSave(c.ctx, target, c.results != nil) // Failures are logged
+	if result != nil {
+		c.scheduleFastRetry(target, result)
+	}
+}
+
+// CheckOnce checks a target right away, outside its schedule, and stores
+// and publishes the result as a scheduled check would. It waits for a slot
+// under the host's concurrency limit like any other check; ctx bounds the
+// wait and the check, as does the checker shutting down. The result is
+// returned even if saving it fails. A failure doesn't start fast retries,
+// so checking a paused target by hand doesn't keep checking it.
+func (c *Checker) CheckOnce(ctx context.Context, target *store.Target) (*store.CheckResult, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stop := context.AfterFunc(c.ctx, cancel)
+	defer stop()
+
+	result, err := c.check(ctx, target)
+	if result == nil && err == nil {
+		err = ctx.Err()
+	}
+	return result, err
+}
+
+// check runs a check bound to ctx and saves it, returning nil if ctx ends
+// first and the error if saving fails.
+func (c *Checker) check(ctx context.Context, target *store.Target) (*store.CheckResult, error) {
+	return c.checkAndSave(ctx, target, false)
+}
+
+// checkAndSave is check, handing the result to c.results to save later
+// when batch is set.
+func (c *Checker) checkAndSave(ctx context.Context, target *store.Target, batch bool) (*store.CheckResult, error) {
+	c.trackInFlight(target.ID, 1)
+	defer c.trackInFlight(target.ID, -1) // Deferred so a panic can't leave it listed
 
 	// Limit concurrent checks per host
-	if !c.acquireHostSemaphore(target.Host) {
-		return
+	if !c.acquireHostSemaphore(ctx, target.Host) {
+		return nil, nil
 	}
 	defer c.releaseHostSemaphore(target.Host)
 
 	// Perform HTTP check
-	result := c.performCheck(target)
+	result := c.checkWithRetries(ctx, target)
+	if result == nil {
+		return nil, nil
+	}
 
 	// Save result
-	if err := c.store.InsertCheckResult(c.ctx, result); err != nil {
-		fmt.Println("failed to save check result:", err)
+	result.Dedup = c.dedupResults
+	result.InMaintenance = target.Maintenance != nil && target.Maintenance.Active(result.CheckedAt)
+	if !c.claimTargets {
+		// Claiming records when a target is next due; otherwise it comes
+		// round again one interval after this check
+		next := result.CheckedAt.Add(c.interval())
+		result.NextCheckAt = &next
+	}
+	if batch {
+		c.results.add(target, result)
+		return result, nil
+	}
+	err := c.store.InsertCheckResult(ctx, result)
+	c.saved(target, result, err)
+	return result, err
+}
+
+// saved logs, publishes and notifies on a result once it is stored, or logs
+// why storing it failed.
+func (c *Checker) saved(target *store.Target, result *store.CheckResult, err error) {
+	if err != nil {
+		c.logger.Error("failed to save check result", "target_id", target.ID, "error", err)
+		return
+	}
+	c.logger.Debug("checked target", checkAttrs(target, result)...)
+	// A result folded into the previous row has that row's ID, which the
+	// stream has already sent, so it isn't published again
+	if c.broker != nil && result.Occurrences <= 1 {
+		c.broker.Publish(target, result)
+	}
+	c.notifyTransition(target, result.PreviousState, result.NewState, result.CheckedAt)
+}
+
+// checkAttrs describes a check result for logging.
+func checkAttrs(target *store.Target, result *store.CheckResult) []any {
+	attrs := []any{"target_id", target.ID, "latency_ms", result.LatencyMs, "attempts", result.Attempts}
+	if result.StatusCode != nil {
+		attrs = append(attrs, "status", *result.StatusCode)
+	}
+	if result.Error != nil {
+		attrs = append(attrs, "error", *result.Error)
+	}
+	return attrs
+}
+
+// checkWithRetries performs the check, retrying transient failures with
+// exponential backoff. It returns nil if ctx ends while waiting.
+func (c *Checker) checkWithRetries(ctx context.Context, target *store.Target) *store.CheckResult {
+	backoff := c.retryBackoff
+	for attempt := 1; ; attempt++ {
+		result := c.performCheck(ctx, target)
+		result.Attempts = attempt
+		if attempt > c.retries || result.Succeeded(target.ExpectedStatus, c.successStatuses) || !transientFailure(result) {
+			return result
+		}
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil
+		case <-timer.C:
+		}
+		backoff *= 2
+	}
+}
+
+// transientFailure reports whether a failed check is worth retrying: no
+// response at all (timeouts, refused connections) or a server error.
+func transientFailure(result *store.CheckResult) bool {
+	return result.StatusCode == nil || *result.StatusCode >= 500
+}
+
+// scheduleFastRetry rechecks a failing target sooner than the normal interval
+// so recovery is noticed quickly. The streak resets once the target succeeds,
+// and ends if the target is paused or deleted before its recheck. The recheck
+// uses the target as stored by then, and is skipped while another check of it
+// runs, as that one schedules its own.
+func (c *Checker) scheduleFastRetry(target *store.Target, result *store.CheckResult) {
+	if c.fastRetryInterval <= 0 {
+		return
+	}
+
+	c.fastRetryMutex.Lock()
+	defer c.fastRetryMutex.Unlock()
+
+	if result.Succeeded(target.ExpectedStatus, c.successStatuses) {
+		delete(c.fastRetries, target.ID)
+		return
+	}
+
+	state, exists := c.fastRetries[target.ID]
+	if !exists {
+		state = &fastRetry{}
+		c.fastRetries[target.ID] = state
+	}
+	// Give up on fast retries until recovery; the normal cadence takes over
+	if state.pending || state.attempts >= c.fastRetryAttempts {
+		return
+	}
+	state.attempts++
+	state.pending = true
+
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-time.After(c.fastRetryInterval):
+		}
+
+		c.fastRetryMutex.Lock()
+		state.pending = false
+		c.fastRetryMutex.Unlock()
+
+		current, err := c.store.GetTargetByID(c.ctx, target.ID)
+		if (err == nil && !current.Enabled) || errors.Is(err, store.ErrTargetNotFound) {
+			c.endFastRetry(target.ID, state)
+			return
+		}
+		if err != nil {
+			c.logger.Warn("skipping fast retry", "target_id", target.ID, "error", err)
+			return
+		}
+		if c.checking(target.ID) {
+			return
+		}
+		c.dispatch(current)
+	}()
+}
+
+// endFastRetry forgets a target's fast-retry streak, unless a newer one has
+// replaced it.
+func (c *Checker) endFastRetry(targetID string, state *fastRetry) {
+	c.fastRetryMutex.Lock()
+	defer c.fastRetryMutex.Unlock()
+
+	if c.fastRetries[targetID] == state {
+		delete(c.fastRetries, targetID)
 	}
 }
 
+// hostSemaphore limits the checks running against one host. It's removed
+// from hostSemaphores once no check holds or waits for it, so hosts that
+// come and go don't accumulate.
+type hostSemaphore struct {
+	slots chan struct{}
+	refs  int // Checks holding or waiting for a slot, guarded by hostMutex
+}
+
 // acquireHostSemaphore prevents overwhelming a single host.
-func (c *Checker) acquireHostSemaphore(host string) bool {
+func (c *Checker) acquireHostSemaphore(ctx context.Context, host string) bool {
 	c.hostMutex.Lock()
 	sem, exists := c.hostSemaphores[host]
 	if !exists {
-		// Allow up to 2 checks per host in parallel (tunable)
-		sem = make(chan struct{}, 2)
+		sem = &hostSemaphore{slots: make(chan struct{}, c.hostLimit(host))}
 		c.hostSemaphores[host] = sem
 	}
+	sem.refs++
 	c.hostMutex.Unlock()
 
 	select {
-	case sem <- struct{}{}:
+	case sem.slots <- struct{}{}:
 		return true
-	case <-c.ctx.Done():
+	case <-ctx.Done():
+		c.unrefHostSemaphore(host, sem)
 		return false
 	}
 }
 
+// hostLimit returns how many checks may run against host at once.
+func (c *Checker) hostLimit(host string) int {
+	if n, ok := c.hostConcurrency[host]; ok && n > 0 {
+		return n
+	}
+	if c.perHostConcurrency > 0 {
+		return c.perHostConcurrency
+	}
+	return defaultPerHostConcurrency
+}
+
 // releaseHostSemaphore frees a host "slot".
 func (c *Checker) releaseHostSemaphore(host string) {
-	c.hostMutex.RLock()
+	c.hostMutex.Lock()
 	sem, exists := c.hostSemaphores[host]
-	c.hostMutex.RUnlock()
+	c.hostMutex.Unlock()
 
 	if exists {
-		<-sem
+		<-sem.slots
+		c.unrefHostSemaphore(host, sem)
 	}
 }
 
-// performCheck makes the HTTP GET request and records results.
-func (c *Checker) performCheck(target *store.Target) *store.CheckResult {
-	start := time.Now()
-	client := http.Client{Timeout: c.httpTimeout}
+// unrefHostSemaphore drops a check's reference to sem, removing it once the
+// last holder or waiter is gone.
+func (c *Checker) unrefHostSemaphore(host string, sem *hostSemaphore) {
+	c.hostMutex.Lock()
+	defer c.hostMutex.Unlock()
+
+	sem.refs--
+	if sem.refs == 0 {
+		delete(c.hostSemaphores, host)
+	}
+}
+
+// defaultMaxRedirects matches net/http's default redirect limit.
+const defaultMaxRedirects = 10
+
+// performCheck makes the HTTP request and records results. Ending ctx
+// aborts the request.
+func (c *Checker) performCheck(ctx context.Context, target *store.Target) *store.CheckResult {
+	transport := c.transport
+	if target.InsecureSkipVerify {
+		c.logger.Warn("skipping TLS verification", "target_id", target.ID, "url", target.URL)
+		transport = c.insecureTransport
+	}
+
+	// The client is only a wrapper; connections are pooled in the shared
+	// transport, and each request's timeout comes from its context
+	var redirects []string
+	client := http.Client{
+		Transport: transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) > c.maxRedirects {
+				return fmt.Errorf("stopped after %d redirects", c.maxRedirects)
+			}
+			redirects = append(redirects, req.URL.String())
+			return nil
+		},
+	}
+
+	method := target.Method
+	if method == "" {
+		method = c.checkMethod
+	}
+	if method == "" {
+		method = MethodAuto
+	}
+	if target.MatchPattern != nil && (method == http.MethodHead || method == MethodAuto) {
+		method = http.MethodGet // The body assertion needs a body
+	}
 
-	resp, err := client.Get(target.URL)
-	latency := time.Since(start).Milliseconds()
+	start := time.Now()
+	phases := &phaseTimer{}
+	reqCtx, cancel := c.requestContext(ctx)
+	defer func() { cancel() }() // The GET fallback replaces it
+	var resp *http.Response
+	var err error
+	if method != MethodAuto {
+		resp, err = c.send(reqCtx, &client, method, target, phases)
+	} else {
+		resp, err = c.send(reqCtx, &client, http.MethodHead, target, phases)
+		if err == nil && headUnsupported(resp.StatusCode) {
+			// Only the request that produced the result counts towards latency
+			resp.Body.Close()
+			cancel()
+			redirects = nil
+			start = time.Now()
+			phases = &phaseTimer{}
+			reqCtx, cancel = c.requestContext(ctx)
+			resp, err = c.send(reqCtx, &client, http.MethodGet, target, phases)
+		}
+	}
+	elapsed := time.Since(start)
+	latency := elapsed.Milliseconds()
 
 	result := &store.CheckResult{
-		TargetID:  target.ID,
-		CheckedAt: time.Now(),
-		LatencyMs: int(latency),
+		TargetID:      target.ID,
+		CheckedAt:     time.Now(),
+		LatencyMs:     int(latency),
+		NodeID:        c.nodeID,
+		RedirectCount: len(redirects),
 	}
+	phases.record(result)
 
 	if err != nil {
+		var certErr *tls.CertificateVerificationError
+		if errors.As(err, &certErr) {
+			// Still report when the rejected certificate expires
+			if len(certErr.UnverifiedCertificates) > 0 {
+				setCertExpiry(result, certErr.UnverifiedCertificates[0])
+			}
+			err = fmt.Errorf("TLS certificate verification failed: %w", certErr.Err)
+		}
 		errMsg := err.Error()
 		result.Error = &errMsg
+		c.metrics.ObserveCheck(elapsed, true)
 		return result
 	}
 	defer resp.Body.Close()
 
 	result.StatusCode = &resp.StatusCode
+	finalURL := resp.Request.URL.String()
+	result.FinalURL = &finalURL
+
+	result.Metadata.SetProtocol(resp.Proto)
+	if len(redirects) > 0 {
+		result.Metadata.SetRedirectChain(redirects)
+	}
+	if resp.TLS != nil && len(resp.TLS.PeerCertificates) > 0 {
+		setCertExpiry(result, resp.TLS.PeerCertificates[0])
+	}
+
+	c.consumeBody(target, result, resp)
+	c.metrics.ObserveCheck(elapsed, !result.Succeeded(target.ExpectedStatus, c.successStatuses))
 	return result
 }
 
-// Shutdown gracefully stops the checker and waits for workers to finish.
+// defaultBodyBytes caps body reads when MaxBodyBytes is off.
+const defaultBodyBytes = 1 << 20
+
+// consumeBody reads the response body up to the cap, inspecting it when the
+// target needs that, and drains the rest of the cap so the connection can be
+// reused. A body longer than the cap is never read in full, and is flagged
+// in the result. Without a usable Content-Length, the body's size is what
+// was read, unless it was cut off at the cap.
+func (c *Checker) consumeBody(target *store.Target, result *store.CheckResult, resp *http.Response) {
+	limit := c.maxBodyBytes
+	if limit <= 0 {
+		limit = defaultBodyBytes
+	}
+	if contentType := resp.Header.Get("Content-Type"); contentType != "" {
+		result.ContentType = &contentType
+	}
+	// The transport reports a missing or unparsable header as -1
+	if length := resp.ContentLength; length >= 0 {
+		result.ContentLength = &length
+	}
+
+	var read int64
+	// HEAD responses carry no body to compare
+	if resp.Request.Method != http.MethodHead && (c.maxBodyBytes > 0 || target.MatchPattern != nil) {
+		data, err := io.ReadAll(io.LimitReader(resp.Body, limit))
+		c.inspectBody(target, result, data, err)
+		if err != nil {
+			return
+		}
+		read = int64(len(data))
+	}
+
+	// One byte past the cap tells a truncated body from one that just fits
+	n, err := io.Copy(io.Discard, io.LimitReader(resp.Body, limit-read+1))
+	if err == nil && read+n > limit {
+		result.Metadata.SetBodyTruncated(true)
+		return
+	}
+	if err == nil && result.ContentLength == nil && resp.Request.Method != http.MethodHead {
+		size := read + n
+		result.ContentLength = &size
+	}
+}
+
+// inspectBody hashes the body read for change detection and evaluates the
+// target's body assertion. A failed assertion becomes the result's error, so
+// the check counts as down whatever the status.
+func (c *Checker) inspectBody(target *store.Target, result *store.CheckResult, data []byte, err error) {
+	if err == nil && c.maxBodyBytes > 0 {
+		sum := sha256.Sum256(data)
+		hash := hex.EncodeToString(sum[:])
+		result.BodyHash = &hash
+	}
+
+	if target.MatchPattern == nil {
+		return
+	}
+	var msg string
+	if err != nil {
+		msg = fmt.Sprintf("reading body for match_pattern: %v", err)
+	} else {
+		msg = matchBody(target, data)
+	}
+	if msg != "" {
+		result.Error = &msg
+	}
+}
+
+// matchBody evaluates the target's body assertion, describing the failure
+// or returning "" if it holds.
+func matchBody(target *store.Target, body []byte) string {
+	re, err := regexp.Compile(*target.MatchPattern)
+	if err != nil {
+		return fmt.Sprintf("invalid match_pattern: %v", err)
+	}
+	found := re.Match(body)
+	if target.MatchMode == store.MatchAbsent {
+		if found {
+			return fmt.Sprintf("body matches %q, which must be absent", *target.MatchPattern)
+		}
+		return ""
+	}
+	if !found {
+		return fmt.Sprintf("body does not match %q", *target.MatchPattern)
+	}
+	return ""
+}
+
+// setCertExpiry records when the leaf certificate expires, relative to the check
+func setCertExpiry(result *store.CheckResult, leaf *x509.Certificate) {
+	expires := leaf.NotAfter
+	days := int(expires.Sub(result.CheckedAt).Hours() / 24)
+	result.CertExpiresAt = &expires
+	result.CertDaysRemaining = &days
+}
+
+// requestContext bounds one request by HTTPTimeout, body reads included.
+// It derives from the check's context, so shutdown aborts the request too.
+func (c *Checker) requestContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	if c.httpTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, c.httpTimeout)
+}
+
+// send issues a request with the target's headers and body, bound to ctx.
+// The body is read afresh for every request, so retries and redirects
+// resend it in full. Connection phases are timed into phases.
+func (c *Checker) send(ctx context.Context, client *http.Client, method string, target *store.Target, phases *phaseTimer) (*http.Response, error) {
+	ctx = phases.withTrace(ctx)
+	if target.Proxy != nil {
+		proxy, err := model.ParseProxyURL(*target.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy: %w", err)
+		}
+		ctx = context.WithValue(ctx, targetProxyKey{}, proxy)
+	}
+	var body io.Reader
+	if target.RequestBody != nil {
+		body = strings.NewReader(*target.RequestBody)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target.URL, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", c.userAgent)
+	for name, value := range target.Headers {
+		// net/http ignores a Host entry in Header
+		if http.CanonicalHeaderKey(name) == "Host" {
+			req.Host = value
+			continue
+		}
+		req.Header.Set(name, value)
+	}
+	return client.Do(req)
+}
+
+// headUnsupported reports whether a HEAD response means the server wants GET
+func headUnsupported(status int) bool {
+	return status == http.StatusMethodNotAllowed || status == http.StatusNotImplemented
+}
+
+// Reload applies the hot-reloadable fields of opts to a running checker:
+// CheckInterval, which takes effect from the next tick, and MaxConcurrency,
+// which grows or shrinks the worker pool without touching checks already
+// running. Zero fields are left as they are; all other
+// options need a restart.
+func (c *Checker) Reload(opts Options) {
+	c.reloadMutex.Lock()
+	defer c.reloadMutex.Unlock()
+
+	interval := clampInterval(opts.CheckInterval, c.minCheckInterval, c.logger)
+	if interval > 0 && interval != c.checkInterval {
+		c.checkInterval = interval
+		select {
+		case c.reloaded <- struct{}{}:
+		default: // The scheduler hasn't picked up the last change yet
+		}
+	}
+	if opts.MaxConcurrency > 0 && opts.MaxConcurrency != c.maxConcurrency {
+		c.resizeWorkers(c.maxConcurrency, opts.MaxConcurrency)
+		c.maxConcurrency = opts.MaxConcurrency
+	}
+}
+
+// clampInterval raises a positive interval below floor to floor. Config
+// validation already rejects these, so this only guards other callers.
+func clampInterval(interval, floor time.Duration, logger *slog.Logger) time.Duration {
+	if interval > 0 && interval < floor {
+		logger.Warn("check interval below minimum, clamping", "interval", interval.String(), "min", floor.String())
+		return floor
+	}
+	return interval
+}
+
+// trackInFlight adds delta to the checks running for a target.
+func (c *Checker) trackInFlight(targetID string, delta int) {
+	c.inFlightMutex.Lock()
+	defer c.inFlightMutex.Unlock()
+
+	c.inFlight[targetID] += delta
+	if c.inFlight[targetID] <= 0 {
+		delete(c.inFlight, targetID)
+	}
+}
+
+// checking reports whether a check of the target is running or waiting for
+// its host's concurrency limit.
+func (c *Checker) checking(targetID string) bool {
+	c.inFlightMutex.Lock()
+	defer c.inFlightMutex.Unlock()
+	return c.inFlight[targetID] > 0
+}
+
+// InFlight returns the sorted IDs of targets being checked right now,
+// including checks still waiting for their host's concurrency limit.
+func (c *Checker) InFlight() []string {
+	c.inFlightMutex.Lock()
+	defer c.inFlightMutex.Unlock()
+
+	ids := make([]string, 0, len(c.inFlight))
+	for id := range c.inFlight {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// interval returns the current check interval.
+func (c *Checker) interval() time.Duration {
+	c.reloadMutex.RLock()
+	defer c.reloadMutex.RUnlock()
+	return c.checkInterval
+}
+
+// Shutdown gracefully stops the checker and waits for workers to finish,
+// then for the notifier to deliver the transitions still queued.
 func (c *Checker) Shutdown() {
 	// Tell scheduler + workers to stop
 	c.cancel()
@@ -173,13 +1204,23 @@ func (c *Checker) Shutdown() {
 	done := make(chan struct{})
 	go func() {
 		c.wg.Wait()
+		c.flushResults()
+		if c.notifyDone != nil {
+			c.notifier.finish()
+			<-c.notifyDone
+		}
 		close(done)
 	}()
 
 	select {
 	case <-done:
-		fmt.Println("checker shut down gracefully")
+		c.logger.Info("checker shut down gracefully")
 	case <-time.After(c.shutdownGrace):
-		fmt.Println("shutdown timed out, forcing exit")
+		c.logger.Warn("checker shutdown timed out, forcing exit")
+	}
+	if c.notifyDone != nil {
+		// Dead-letter whatever the notifier didn't get to
+		c.notifyCancel()
+		<-c.notifyDone
 	}
 }
@@ -0,0 +1,2100 @@
+package checker
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	rtmetrics "runtime/metrics"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	_ "modernc.org/sqlite"
+
+	"github.com/you/linkwatch/internal/metrics"
+	"github.com/you/linkwatch/internal/model"
+	"github.com/you/linkwatch/internal/store"
+)
+
+func TestPerformCheckFallsBackToGet(t *testing.T) {
+	var mu sync.Mutex
+	var methods []string
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		methods = append(methods, r.Method)
+		mu.Unlock()
+
+		if r.Method == http.MethodHead {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	c := NewChecker(nil, Options{HTTPTimeout: 5 * time.Second, CheckMethod: MethodAuto})
+	result := c.performCheck(c.ctx, &store.Target{ID: "t_1", URL: srv.URL})
+
+	if result.Error != nil {
+		t.Fatalf("Expected no error, got %s", *result.Error)
+	}
+	if result.StatusCode == nil || *result.StatusCode != http.StatusOK {
+		t.Errorf("Expected status 200 from GET fallback, got %v", result.StatusCode)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(methods) != 2 || methods[0] != http.MethodHead || methods[1] != http.MethodGet {
+		t.Errorf("Expected HEAD then GET, got %v", methods)
+	}
+}
+
+func TestPerformCheckMethods(t *testing.T) {
+	tests := []struct {
+		method string
+		want   []string
+	}{
+		{http.MethodGet, []string{http.MethodGet}},
+		{http.MethodHead, []string{http.MethodHead}},
+		{MethodAuto, []string{http.MethodHead}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.method, func(t *testing.T) {
+			var mu sync.Mutex
+			var methods []string
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				methods = append(methods, r.Method)
+				mu.Unlock()
+				w.WriteHeader(http.StatusNoContent)
+			}))
+			defer srv.Close()
+
+			c := NewChecker(nil, Options{HTTPTimeout: 5 * time.Second, CheckMethod: tt.method})
+			result := c.performCheck(c.ctx, &store.Target{ID: "t_1", URL: srv.URL})
+
+			if result.StatusCode == nil || *result.StatusCode != http.StatusNoContent {
+				t.Errorf("Expected status 204, got %v", result.StatusCode)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if !slices.Equal(methods, tt.want) {
+				t.Errorf("Expected requests %v, got %v", tt.want, methods)
+			}
+		})
+	}
+}
+
+func TestCheckWithRetriesRecoversFromServerError(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) < 3 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	c := NewChecker(nil, Options{HTTPTimeout: 5 * time.Second, CheckMethod: http.MethodGet, Retries: 3, RetryBackoff: time.Millisecond})
+	result := c.checkWithRetries(c.ctx, &store.Target{ID: "t_1", URL: srv.URL})
+
+	if result == nil || result.StatusCode == nil || *result.StatusCode != http.StatusOK {
+		t.Fatalf("Expected final 200 result, got %+v", result)
+	}
+	if result.Attempts != 3 {
+		t.Errorf("Expected 3 attempts, got %d", result.Attempts)
+	}
+}
+
+func TestPerformCheckSendsMethodAndBody(t *testing.T) {
+	const want = `{"ping":true}`
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		// The first attempt fails, so the body must survive a retry
+		if calls.Add(1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		if r.Method != http.MethodPost || string(body) != want {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	c := NewChecker(nil, Options{HTTPTimeout: 5 * time.Second, Retries: 1, RetryBackoff: time.Millisecond})
+	body := want
+	target := &store.Target{ID: "t_1", URL: srv.URL, TargetSettings: store.TargetSettings{Method: http.MethodPost, RequestBody: &body}}
+	result := c.checkWithRetries(c.ctx, target)
+
+	if result.StatusCode == nil || *result.StatusCode != http.StatusOK {
+		t.Fatalf("Expected 200 for the POST with its body, got error %v, status %v", result.Error, result.StatusCode)
+	}
+	if result.Attempts != 2 {
+		t.Errorf("Expected 2 attempts, got %d", result.Attempts)
+	}
+
+	// Without its settings the target is checked with the global method
+	result = c.performCheck(c.ctx, &store.Target{ID: "t_2", URL: srv.URL})
+	if result.StatusCode == nil || *result.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected 400 for a plain check, got error %v, status %v", result.Error, result.StatusCode)
+	}
+}
+
+func TestCheckWithRetriesStopsOnShutdown(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	c := NewChecker(nil, Options{HTTPTimeout: 5 * time.Second, CheckMethod: http.MethodGet, Retries: 5, RetryBackoff: time.Hour})
+
+	done := make(chan *store.CheckResult)
+	go func() { done <- c.checkWithRetries(c.ctx, &store.Target{ID: "t_1", URL: srv.URL}) }()
+
+	time.Sleep(50 * time.Millisecond)
+	c.cancel()
+
+	select {
+	case result := <-done:
+		if result != nil {
+			t.Errorf("Expected no result after shutdown, got %+v", result)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Backoff sleep was not interrupted by shutdown")
+	}
+}
+
+func TestPerformCheckSendsTargetHeaders(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Api-Key") != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	c := NewChecker(nil, Options{HTTPTimeout: 5 * time.Second, CheckMethod: http.MethodGet})
+
+	result := c.performCheck(c.ctx, &store.Target{ID: "t_1", URL: srv.URL})
+	if result.StatusCode == nil || *result.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Expected status 401 without headers, got %v", result.StatusCode)
+	}
+
+	target := &store.Target{ID: "t_1", URL: srv.URL}
+	target.Headers = store.Headers{"X-Api-Key": "secret"}
+	result = c.performCheck(c.ctx, target)
+	if result.StatusCode == nil || *result.StatusCode != http.StatusOK {
+		t.Errorf("Expected status 200 with headers, got %v", result.StatusCode)
+	}
+}
+
+func TestPerformCheckSendsUserAgent(t *testing.T) {
+	agents := make(chan string, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		agents <- r.UserAgent()
+	}))
+	defer srv.Close()
+
+	c := NewChecker(nil, Options{HTTPTimeout: 5 * time.Second, CheckMethod: http.MethodGet})
+	c.performCheck(c.ctx, &store.Target{ID: "t_1", URL: srv.URL})
+	if got := <-agents; got != defaultUserAgent {
+		t.Errorf("Expected default User-Agent %q, got %q", defaultUserAgent, got)
+	}
+
+	c = NewChecker(nil, Options{HTTPTimeout: 5 * time.Second, CheckMethod: http.MethodGet, UserAgent: "acme-monitor/2.0"})
+	c.performCheck(c.ctx, &store.Target{ID: "t_1", URL: srv.URL})
+	if got := <-agents; got != "acme-monitor/2.0" {
+		t.Errorf("Expected configured User-Agent, got %q", got)
+	}
+
+	// A target's own header wins
+	target := &store.Target{ID: "t_1", URL: srv.URL}
+	target.Headers = store.Headers{"user-agent": "Mozilla/5.0"}
+	c.performCheck(c.ctx, target)
+	if got := <-agents; got != "Mozilla/5.0" {
+		t.Errorf("Expected target User-Agent, got %q", got)
+	}
+}
+
+func TestPerformCheckRecordsRedirects(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/start", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/middle", http.StatusMovedPermanently)
+	})
+	mux.HandleFunc("/middle", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/end", http.StatusFound)
+	})
+	mux.HandleFunc("/end", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	mux.HandleFunc("/loop", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/loop", http.StatusFound)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	maxRedirects := 3
+	c := NewChecker(nil, Options{HTTPTimeout: 5 * time.Second, CheckMethod: http.MethodGet, MaxRedirects: &maxRedirects})
+
+	result := c.performCheck(c.ctx, &store.Target{ID: "t_1", URL: srv.URL + "/start"})
+	if result.Error != nil {
+		t.Fatalf("Expected no error, got %s", *result.Error)
+	}
+	if result.RedirectCount != 2 {
+		t.Errorf("Expected 2 redirects, got %d", result.RedirectCount)
+	}
+	if result.FinalURL == nil || *result.FinalURL != srv.URL+"/end" {
+		t.Errorf("Expected final URL %s/end, got %v", srv.URL, result.FinalURL)
+	}
+
+	looped := c.performCheck(c.ctx, &store.Target{ID: "t_2", URL: srv.URL + "/loop"})
+	if looped.Error == nil || !strings.Contains(*looped.Error, "stopped after 3 redirects") {
+		t.Errorf("Expected redirect limit error, got %v", looped.Error)
+	}
+	if looped.RedirectCount != 3 || looped.FinalURL != nil {
+		t.Errorf("Expected 3 redirects and no final URL, got %d and %v", looped.RedirectCount, looped.FinalURL)
+	}
+
+	// Zero follows none
+	maxRedirects = 0
+	strict := NewChecker(nil, Options{HTTPTimeout: 5 * time.Second, CheckMethod: http.MethodGet, MaxRedirects: &maxRedirects})
+	result = strict.performCheck(strict.ctx, &store.Target{ID: "t_3", URL: srv.URL + "/start"})
+	if result.Error == nil || !strings.Contains(*result.Error, "stopped after 0 redirects") || result.RedirectCount != 0 {
+		t.Errorf("Expected the first redirect to fail the check, got error %v after %d redirects", result.Error, result.RedirectCount)
+	}
+}
+
+func TestPerformCheckRecordsMetrics(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/down" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	m := metrics.New(prometheus.NewRegistry())
+	c := NewChecker(nil, Options{HTTPTimeout: 5 * time.Second, CheckMethod: http.MethodGet, Metrics: m})
+
+	c.performCheck(c.ctx, &store.Target{ID: "t_1", URL: srv.URL + "/up"})
+	c.performCheck(c.ctx, &store.Target{ID: "t_2", URL: srv.URL + "/down"})
+	c.performCheck(c.ctx, &store.Target{ID: "t_3", URL: "http://127.0.0.1:1"})
+
+	if got := testutil.ToFloat64(m.ChecksTotal); got != 3 {
+		t.Errorf("Expected 3 checks, got %v", got)
+	}
+	if got := testutil.ToFloat64(m.ChecksFailed); got != 2 {
+		t.Errorf("Expected 2 failed checks, got %v", got)
+	}
+	if got := testutil.CollectAndCount(m.CheckDuration); got != 1 {
+		t.Errorf("Expected latency histogram to be collected, got %d series", got)
+	}
+}
+
+func TestPerformCheckHashesBody(t *testing.T) {
+	body := "hello world"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(body))
+	}))
+	defer srv.Close()
+
+	c := NewChecker(nil, Options{HTTPTimeout: 5 * time.Second, CheckMethod: http.MethodGet, MaxBodyBytes: 5})
+	result := c.performCheck(c.ctx, &store.Target{ID: "t_1", URL: srv.URL})
+
+	// Only the first 5 bytes, "hello", are hashed
+	want := "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"
+	if result.BodyHash == nil || *result.BodyHash != want {
+		t.Errorf("Expected body hash %s, got %v", want, result.BodyHash)
+	}
+
+	head := NewChecker(nil, Options{HTTPTimeout: 5 * time.Second, CheckMethod: http.MethodHead, MaxBodyBytes: 5})
+	if result := head.performCheck(head.ctx, &store.Target{ID: "t_1", URL: srv.URL}); result.BodyHash != nil {
+		t.Errorf("Expected no body hash for HEAD, got %s", *result.BodyHash)
+	}
+}
+
+func TestPerformCheckAbortsOnShutdown(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("partial"))
+		w.(http.Flusher).Flush()
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	c := NewChecker(nil, Options{HTTPTimeout: time.Minute, CheckMethod: http.MethodGet, MaxBodyBytes: 1 << 20})
+	read := make(chan struct{})
+	c.transport = firstReadTransport{base: c.transport, read: read}
+
+	done := make(chan *store.CheckResult)
+	go func() { done <- c.performCheck(c.ctx, &store.Target{ID: "t_1", URL: srv.URL}) }()
+
+	// Shut down once the check is part way through the body
+	select {
+	case <-read:
+	case <-time.After(time.Second):
+		t.Fatal("The check never read the body")
+	}
+	c.cancel()
+
+	select {
+	case result := <-done:
+		if result.BodyHash != nil {
+			t.Errorf("Expected no hash for an interrupted body, got %s", *result.BodyHash)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Body read was not interrupted by shutdown")
+	}
+}
+
+// firstReadTransport closes read once the first bytes of a response body
+// reach the check.
+type firstReadTransport struct {
+	base http.RoundTripper
+	read chan struct{}
+}
+
+func (t firstReadTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(r)
+	if err == nil {
+		resp.Body = &firstReadBody{ReadCloser: resp.Body, read: t.read}
+	}
+	return resp, err
+}
+
+type firstReadBody struct {
+	io.ReadCloser
+	once sync.Once
+	read chan struct{}
+}
+
+func (b *firstReadBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 {
+		b.once.Do(func() { close(b.read) })
+	}
+	return n, err
+}
+
+func TestPerformCheckRecordsCertExpiry(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	leaf := srv.Certificate()
+
+	c := NewChecker(nil, Options{HTTPTimeout: 5 * time.Second, CheckMethod: http.MethodGet})
+	c.transport = srv.Client().Transport
+
+	result := c.performCheck(c.ctx, &store.Target{ID: "t_1", URL: srv.URL})
+	if result.Error != nil {
+		t.Fatalf("Expected no error, got %s", *result.Error)
+	}
+	if result.CertExpiresAt == nil || !result.CertExpiresAt.Equal(leaf.NotAfter) {
+		t.Errorf("Expected cert expiry %v, got %v", leaf.NotAfter, result.CertExpiresAt)
+	}
+	// Counted from the check, not from whenever the test gets here
+	wantDays := int(leaf.NotAfter.Sub(result.CheckedAt).Hours() / 24)
+	if result.CertDaysRemaining == nil || *result.CertDaysRemaining != wantDays {
+		t.Errorf("Expected %d days remaining, got %v", wantDays, result.CertDaysRemaining)
+	}
+}
+
+func TestSetCertExpiry(t *testing.T) {
+	checked := time.Date(2030, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		notAfter time.Time
+		days     int
+	}{
+		{checked.Add(30*24*time.Hour + 12*time.Hour), 30}, // Partial days round down
+		{checked.Add(23 * time.Hour), 0},
+		{checked.Add(-25 * time.Hour), -1}, // Already expired
+	}
+	for _, tt := range tests {
+		result := &store.CheckResult{CheckedAt: checked}
+		setCertExpiry(result, &x509.Certificate{NotAfter: tt.notAfter})
+		if !result.CertExpiresAt.Equal(tt.notAfter) || *result.CertDaysRemaining != tt.days {
+			t.Errorf("NotAfter %v: expected %d days, got %d (expiry %v)", tt.notAfter, tt.days, *result.CertDaysRemaining, result.CertExpiresAt)
+		}
+	}
+}
+
+func TestPerformCheckHTTP2(t *testing.T) {
+	h2 := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	h2.EnableHTTP2 = true
+	h2.StartTLS()
+	defer h2.Close()
+
+	h1 := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer h1.Close()
+
+	// Speaks HTTP/2 without TLS, which only a forced client tries
+	h2c := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	h2c.Config.Protocols = new(http.Protocols)
+	h2c.Config.Protocols.SetHTTP1(true)
+	h2c.Config.Protocols.SetUnencryptedHTTP2(true)
+	h2c.Start()
+	defer h2c.Close()
+
+	force, disable := true, false
+	tests := []struct {
+		name  string
+		force *bool
+		url   string
+		proto string // Empty when the check should fail
+	}{
+		{"negotiated", nil, h2.URL, "HTTP/2.0"},
+		{"forced", &force, h2.URL, "HTTP/2.0"},
+		{"disabled", &disable, h2.URL, "HTTP/1.1"},
+		{"forced without server support", &force, h1.URL, ""},
+		{"negotiated without TLS", nil, h2c.URL, "HTTP/1.1"},
+		{"forced without TLS", &force, h2c.URL, "HTTP/2.0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewChecker(nil, Options{HTTPTimeout: 5 * time.Second, CheckMethod: http.MethodGet, ForceHTTP2: tt.force,
+				Logger: slog.New(slog.DiscardHandler)})
+			// The test servers' certificates aren't trusted
+			result := c.performCheck(c.ctx, &store.Target{ID: "t_1", URL: tt.url,
+				TargetSettings: store.TargetSettings{InsecureSkipVerify: true}})
+
+			if tt.proto == "" {
+				if result.Error == nil {
+					t.Fatalf("Expected the check to fail, got protocol %q", result.Metadata.Protocol())
+				}
+				return
+			}
+			if result.Error != nil {
+				t.Fatalf("Expected no error, got %s", *result.Error)
+			}
+			if got := result.Metadata.Protocol(); got != tt.proto {
+				t.Errorf("Expected protocol %q, got %q", tt.proto, got)
+			}
+		})
+	}
+}
+
+func TestPerformCheckReportsUntrustedCert(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	// The default transport doesn't trust the test server's certificate
+	c := NewChecker(nil, Options{HTTPTimeout: 5 * time.Second, CheckMethod: http.MethodGet})
+	result := c.performCheck(c.ctx, &store.Target{ID: "t_1", URL: srv.URL})
+
+	if result.Error == nil || !strings.Contains(*result.Error, "TLS certificate verification failed") {
+		t.Fatalf("Expected TLS verification error, got %v", result.Error)
+	}
+	if result.CertExpiresAt == nil || !result.CertExpiresAt.Equal(srv.Certificate().NotAfter) {
+		t.Errorf("Expected expiry of the rejected certificate, got %v", result.CertExpiresAt)
+	}
+}
+
+func TestPerformCheckInsecureSkipVerify(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, nil))
+	c := NewChecker(nil, Options{HTTPTimeout: 5 * time.Second, CheckMethod: http.MethodGet, Logger: logger})
+
+	insecure := &store.Target{ID: "t_insecure", URL: srv.URL, TargetSettings: store.TargetSettings{InsecureSkipVerify: true}}
+	result := c.performCheck(c.ctx, insecure)
+	if result.Error != nil || result.StatusCode == nil || *result.StatusCode != http.StatusOK {
+		t.Fatalf("Expected the insecure target to succeed, got error %v, status %v", result.Error, result.StatusCode)
+	}
+	if !strings.Contains(buf.String(), `"msg":"skipping TLS verification"`) || !strings.Contains(buf.String(), "t_insecure") {
+		t.Errorf("Expected a warning naming the target, got %s", buf.String())
+	}
+
+	// Checked after the insecure one, a strict target on the same host
+	// still verifies
+	buf.Reset()
+	result = c.performCheck(c.ctx, &store.Target{ID: "t_strict", URL: srv.URL})
+	if result.Error == nil || !strings.Contains(*result.Error, "TLS certificate verification failed") {
+		t.Errorf("Expected the strict target to fail verification, got %v", result.Error)
+	}
+	if buf.Len() != 0 {
+		t.Errorf("Expected no warning for the strict target, got %s", buf.String())
+	}
+}
+
+func TestHostSemaphoreCapacity(t *testing.T) {
+	c := NewChecker(nil, Options{
+		PerHostConcurrency: 4,
+		HostConcurrency:    map[string]int{"slow.example.com": 1, "fast.example.com": 16},
+	})
+
+	tests := map[string]int{
+		"example.com":      4,
+		"slow.example.com": 1,
+		"fast.example.com": 16,
+	}
+	for host, want := range tests {
+		if !c.acquireHostSemaphore(c.ctx, host) {
+			t.Fatalf("Failed to acquire semaphore for %s", host)
+		}
+		if got := cap(c.hostSemaphores[host].slots); got != want {
+			t.Errorf("Semaphore for %s has capacity %d, want %d", host, got, want)
+		}
+		c.releaseHostSemaphore(host)
+	}
+
+	if got := NewChecker(nil, Options{}).hostLimit("example.com"); got != defaultPerHostConcurrency {
+		t.Errorf("Expected default per-host limit %d, got %d", defaultPerHostConcurrency, got)
+	}
+}
+
+// recordingStore serves a fixed target list and records inserted results.
+// Methods the scheduler doesn't use panic via the nil embedded Store.
+type recordingStore struct {
+	store.Store
+
+	targets []*store.Target
+	mu      sync.Mutex
+	results []*store.CheckResult
+}
+
+func (s *recordingStore) GetEnabledTargets(ctx context.Context, afterCreatedAt time.Time, afterID string, limit int) ([]*store.Target, *store.Cursor, error) {
+	return s.targets, nil, nil
+}
+
+func (s *recordingStore) InsertCheckResult(ctx context.Context, result *store.CheckResult) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.results = append(s.results, result)
+	return nil
+}
+
+func (s *recordingStore) checkedAt() []time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	times := make([]time.Time, len(s.results))
+	for i, r := range s.results {
+		times[i] = r.CheckedAt
+	}
+	return times
+}
+
+func TestScheduleChecksSpreadsWithJitter(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	st := &recordingStore{}
+	for i := 0; i < 20; i++ {
+		st.targets = append(st.targets, &store.Target{ID: fmt.Sprintf("t_%d", i), URL: srv.URL, Host: "local"})
+	}
+
+	const interval = 400 * time.Millisecond
+	c := NewChecker(st, Options{
+		CheckInterval:      interval,
+		HTTPTimeout:        time.Second,
+		MaxConcurrency:     20,
+		PerHostConcurrency: 20,
+		CheckJitter:        0.5,
+	})
+	defer c.cancel()
+	c.startWorkers()
+
+	start := time.Now()
+	c.scheduleChecks()
+	if elapsed := time.Since(start); elapsed > 50*time.Millisecond {
+		t.Errorf("Scheduling pass blocked for %v", elapsed)
+	}
+
+	deadline := time.Now().Add(interval)
+	for len(st.checkedAt()) < len(st.targets) && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	times := st.checkedAt()
+	if len(times) != len(st.targets) {
+		t.Fatalf("Expected %d checks within one interval, got %d", len(st.targets), len(times))
+	}
+
+	first, last := times[0], times[0]
+	for _, ts := range times {
+		if ts.Before(first) {
+			first = ts
+		}
+		if ts.After(last) {
+			last = ts
+		}
+	}
+	if spread := last.Sub(first); spread < 20*time.Millisecond {
+		t.Errorf("Expected checks to be staggered, all ran within %v", spread)
+	}
+}
+
+func TestReloadCheckInterval(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	st := &recordingStore{targets: []*store.Target{{ID: "t_1", URL: srv.URL, Host: "local"}}}
+	c := NewChecker(st, Options{
+		CheckInterval:  time.Hour,
+		HTTPTimeout:    time.Second,
+		MaxConcurrency: 1,
+		ShutdownGrace:  time.Second,
+	})
+	c.Start()
+	defer c.Shutdown()
+
+	c.Reload(Options{CheckInterval: 20 * time.Millisecond})
+
+	deadline := time.Now().Add(2 * time.Second)
+	for len(st.checkedAt()) < 3 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected checks at the reloaded interval, got %d", len(st.checkedAt()))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := c.interval(); got != 20*time.Millisecond {
+		t.Errorf("Expected interval 20ms after reload, got %v", got)
+	}
+}
+
+func TestMinCheckIntervalClamps(t *testing.T) {
+	c := NewChecker(&recordingStore{}, Options{
+		CheckInterval:    time.Second,
+		MinCheckInterval: 5 * time.Second,
+		HTTPTimeout:      time.Second,
+		MaxConcurrency:   1,
+		ShutdownGrace:    time.Second,
+	})
+	if got := c.interval(); got != 5*time.Second {
+		t.Errorf("Expected interval clamped to 5s, got %v", got)
+	}
+
+	c.Reload(Options{CheckInterval: 10 * time.Millisecond})
+	if got := c.interval(); got != 5*time.Second {
+		t.Errorf("Expected reloaded interval clamped to 5s, got %v", got)
+	}
+
+	c.Reload(Options{CheckInterval: time.Minute})
+	if got := c.interval(); got != time.Minute {
+		t.Errorf("Expected interval above the floor to apply, got %v", got)
+	}
+}
+
+func TestFastRetryStopsAfterAttemptsAndResetsOnSuccess(t *testing.T) {
+	st := openTestStore(t)
+	target, _, err := st.UpsertTargetByURL(context.Background(), "http://flaky.invalid/", "flaky.invalid", store.TargetSettings{})
+	if err != nil {
+		t.Fatalf("Failed to create target: %v", err)
+	}
+
+	// With no workers running, each fast recheck shows up on c.jobs and the
+	// test runs it in their place
+	c := NewChecker(st, Options{HTTPTimeout: time.Second, CheckInterval: time.Hour, CheckMethod: http.MethodGet,
+		FastRetryInterval: time.Millisecond, FastRetryAttempts: 2})
+	defer c.cancel()
+	statuses := &statusTransport{}
+	c.transport = statuses
+
+	check := func(status int) {
+		statuses.status = status
+		c.checkTarget(target)
+	}
+	rechecked := func() bool {
+		select {
+		case <-c.jobs:
+			return true
+		case <-time.After(time.Second):
+			return false
+		}
+	}
+	streak := func() (attempts int, pending, failing bool) {
+		c.fastRetryMutex.Lock()
+		defer c.fastRetryMutex.Unlock()
+		state, failing := c.fastRetries[target.ID]
+		if !failing {
+			return 0, false, false
+		}
+		return state.attempts, state.pending, true
+	}
+
+	check(503)
+	for i := 1; i <= 2; i++ {
+		if !rechecked() {
+			t.Fatalf("Expected fast recheck %d to be dispatched", i)
+		}
+		check(503)
+	}
+	// The second recheck failing too schedules nothing more
+	if attempts, pending, _ := streak(); attempts != 2 || pending {
+		t.Errorf("Expected the streak to stop at 2 rechecks with none pending, got %d (pending %t)", attempts, pending)
+	}
+
+	check(200)
+	if _, _, failing := streak(); failing {
+		t.Error("Expected a success to reset the streak")
+	}
+
+	check(503)
+	if !rechecked() {
+		t.Fatal("Expected a new failure to be rechecked fast again")
+	}
+	if attempts, _, _ := streak(); attempts != 1 {
+		t.Errorf("Expected the new streak to start over, got %d rechecks", attempts)
+	}
+}
+
+func TestFastRetryRereadsTarget(t *testing.T) {
+	ctx := context.Background()
+	st := openTestStore(t)
+	c := NewChecker(st, Options{HTTPTimeout: time.Second, CheckInterval: time.Hour, CheckMethod: http.MethodGet,
+		MaxConcurrency: 1, FastRetryInterval: time.Millisecond, FastRetryAttempts: 3})
+	defer c.cancel()
+	c.transport = &statusTransport{status: http.StatusServiceUnavailable}
+
+	create := func(url string) *store.Target {
+		t.Helper()
+		target, _, err := st.UpsertTargetByURL(ctx, url, "example.com", store.TargetSettings{})
+		if err != nil {
+			t.Fatalf("Failed to create target: %v", err)
+		}
+		return target
+	}
+	// With no workers running, a dispatched recheck waits in the one slot of
+	// c.jobs, and c.wg covers the wait before it
+	recheck := func(target *store.Target) *store.Target {
+		t.Helper()
+		c.checkTarget(target)
+		c.wg.Wait()
+		select {
+		case job := <-c.jobs:
+			return job
+		default:
+			return nil
+		}
+	}
+	streaking := func(target *store.Target) bool {
+		c.fastRetryMutex.Lock()
+		defer c.fastRetryMutex.Unlock()
+		_, ok := c.fastRetries[target.ID]
+		return ok
+	}
+
+	// Settings changed since the failure are used for the recheck
+	patched := create("https://example.com/patched")
+	want := 503
+	if _, err := st.UpdateTarget(ctx, patched.ID, store.TargetUpdate{
+		Settings: store.TargetSettings{ExpectedStatus: &want}, Fields: []string{"expected_status"},
+	}); err != nil {
+		t.Fatalf("Failed to update target: %v", err)
+	}
+	if job := recheck(patched); job == nil || job.ExpectedStatus == nil || *job.ExpectedStatus != want {
+		t.Errorf("Expected the recheck to use the updated target, got %+v", job)
+	}
+
+	// A target paused or deleted before its recheck isn't checked again
+	paused := create("https://example.com/paused")
+	disabled := false
+	if _, err := st.UpdateTarget(ctx, paused.ID, store.TargetUpdate{Enabled: &disabled}); err != nil {
+		t.Fatalf("Failed to pause target: %v", err)
+	}
+	deleted := create("https://example.com/deleted")
+	if err := st.DeleteTarget(ctx, deleted.ID); err != nil {
+		t.Fatalf("Failed to delete target: %v", err)
+	}
+	for _, target := range []*store.Target{paused, deleted} {
+		if job := recheck(target); job != nil {
+			t.Errorf("Expected no recheck of %s, got %+v", target.URL, job)
+		}
+		if streaking(target) {
+			t.Errorf("Expected the streak of %s to end", target.URL)
+		}
+	}
+
+	// Nor is one that another check is already running for
+	busy := create("https://example.com/busy")
+	c.trackInFlight(busy.ID, 1)
+	if job := recheck(busy); job != nil {
+		t.Errorf("Expected no recheck while a check is in flight, got %+v", job)
+	}
+	c.trackInFlight(busy.ID, -1)
+
+	// An on-demand check doesn't start a streak
+	manual := create("https://example.com/manual")
+	if _, err := c.CheckOnce(ctx, manual); err != nil {
+		t.Fatalf("CheckOnce failed: %v", err)
+	}
+	c.wg.Wait()
+	if streaking(manual) || len(c.jobs) != 0 {
+		t.Error("Expected CheckOnce not to schedule a fast retry")
+	}
+}
+
+// gatedServer holds every request until released, reporting each arrival.
+func gatedServer(t *testing.T) (srv *httptest.Server, arrived <-chan struct{}, release chan<- struct{}) {
+	arrivals := make(chan struct{}, 100)
+	releases := make(chan struct{})
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		arrivals <- struct{}{}
+		<-releases
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(srv.Close)
+	t.Cleanup(func() { close(releases) })
+	return srv, arrivals, releases
+}
+
+// expectArrivals waits for n requests, then checks no more follow shortly.
+func expectArrivals(t *testing.T, arrived <-chan struct{}, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		select {
+		case <-arrived:
+		case <-time.After(2 * time.Second):
+			t.Fatalf("Expected %d concurrent checks, got %d", n, i)
+		}
+	}
+	select {
+	case <-arrived:
+		t.Fatalf("Expected only %d concurrent checks", n)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestWorkerPoolBoundsConcurrency(t *testing.T) {
+	srv, arrived, release := gatedServer(t)
+
+	var targets []*store.Target
+	for i := 0; i < 10; i++ {
+		targets = append(targets, &store.Target{ID: fmt.Sprintf("t_%d", i), URL: srv.URL, Host: "local"})
+	}
+	st := &recordingStore{targets: targets}
+	c := NewChecker(st, Options{HTTPTimeout: 5 * time.Second, MaxConcurrency: 3, PerHostConcurrency: 10, CheckMethod: http.MethodGet})
+	defer c.cancel()
+	c.startWorkers()
+
+	go c.scheduleChecks()
+
+	// Each finished check frees exactly one worker for the next queued target
+	running := 0
+	for done := 0; done < len(targets); done++ {
+		want := min(3, len(targets)-done)
+		expectArrivals(t, arrived, want-running)
+		running = want
+		release <- struct{}{}
+		running--
+	}
+}
+
+func TestReloadMaxConcurrency(t *testing.T) {
+	srv, arrived, release := gatedServer(t)
+
+	c := NewChecker(&recordingStore{}, Options{HTTPTimeout: 5 * time.Second, MaxConcurrency: 1, PerHostConcurrency: 10, CheckMethod: http.MethodGet})
+	defer c.cancel()
+	c.startWorkers()
+
+	go func() {
+		for i := 0; i < 5; i++ {
+			c.dispatch(&store.Target{ID: fmt.Sprintf("t_%d", i), URL: srv.URL, Host: "local"})
+		}
+	}()
+	expectArrivals(t, arrived, 1)
+
+	// Growing starts a worker for a queued check while the first keeps running
+	c.Reload(Options{MaxConcurrency: 2})
+	expectArrivals(t, arrived, 1)
+
+	// Shrinking retires a worker once its check is done instead of reusing it
+	c.Reload(Options{MaxConcurrency: 1})
+	time.Sleep(20 * time.Millisecond) // Let the retirement be offered
+	release <- struct{}{}
+	expectArrivals(t, arrived, 0)
+
+	// The remaining worker carries on one check at a time
+	release <- struct{}{}
+	expectArrivals(t, arrived, 1)
+}
+
+// failingStore rejects every inserted result.
+type failingStore struct {
+	store.Store
+}
+
+func (failingStore) InsertCheckResult(ctx context.Context, result *store.CheckResult) error {
+	return errors.New("disk full")
+}
+
+func TestCheckTargetLogsSaveFailure(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, nil))
+	c := NewChecker(failingStore{}, Options{HTTPTimeout: time.Second, MaxConcurrency: 1, CheckMethod: http.MethodGet, Logger: logger})
+
+	c.checkTarget(&store.Target{ID: "t_1", URL: srv.URL, Host: "local"})
+
+	var entry map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("Expected one JSON log line, got %q: %v", buf.String(), err)
+	}
+	if entry["level"] != "ERROR" || entry["target_id"] != "t_1" || entry["error"] != "disk full" {
+		t.Errorf("Unexpected log entry %v", entry)
+	}
+}
+
+func TestCheckTargetRecordsNodeID(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	st := openTestStore(t)
+	target, _, err := st.UpsertTargetByURL(context.Background(), srv.URL, "local", store.TargetSettings{})
+	if err != nil {
+		t.Fatalf("Failed to create target: %v", err)
+	}
+	c := NewChecker(st, Options{HTTPTimeout: time.Second, CheckMethod: http.MethodGet, NodeID: "probe-eu-1"})
+	c.checkTarget(target)
+
+	results, _, err := st.GetResults(context.Background(), target.ID, time.Time{}, "probe-eu-1", nil, 10)
+	if err != nil {
+		t.Fatalf("GetResults failed: %v", err)
+	}
+	if len(results) != 1 || results[0].NodeID != "probe-eu-1" {
+		t.Errorf("Expected one result checked by probe-eu-1, got %+v", results)
+	}
+}
+
+// panickingStore panics when a result is saved
+type panickingStore struct {
+	store.Store
+}
+
+func (panickingStore) InsertCheckResult(ctx context.Context, result *store.CheckResult) error {
+	panic("store exploded")
+}
+
+func TestHostSemaphoresArePruned(t *testing.T) {
+	srv, arrived, release := gatedServer(t)
+
+	c := NewChecker(&recordingStore{}, Options{HTTPTimeout: 5 * time.Second, CheckMethod: http.MethodGet})
+	const hosts = 50
+	var wg sync.WaitGroup
+	for i := 0; i < hosts; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.checkTarget(&store.Target{ID: fmt.Sprintf("t_%d", i), URL: srv.URL, Host: fmt.Sprintf("host%d.example.com", i)})
+		}()
+	}
+	expectArrivals(t, arrived, hosts)
+
+	// Every held semaphore stays put
+	c.hostMutex.Lock()
+	held := len(c.hostSemaphores)
+	c.hostMutex.Unlock()
+	if held != hosts {
+		t.Errorf("Expected %d semaphores while checks run, got %d", hosts, held)
+	}
+
+	for i := 0; i < hosts; i++ {
+		release <- struct{}{}
+	}
+	wg.Wait()
+	if n := len(c.hostSemaphores); n != 0 {
+		t.Errorf("Expected every semaphore removed once checks finished, got %d", n)
+	}
+
+	// A waiter giving up leaves the semaphore to its holder
+	c = NewChecker(nil, Options{PerHostConcurrency: 1})
+	if !c.acquireHostSemaphore(c.ctx, "example.com") {
+		t.Fatal("Failed to acquire semaphore")
+	}
+	ctx, cancel := context.WithTimeout(c.ctx, 10*time.Millisecond)
+	defer cancel()
+	if c.acquireHostSemaphore(ctx, "example.com") {
+		t.Fatal("Expected the second acquire to wait until cancelled")
+	}
+	if sem := c.hostSemaphores["example.com"]; sem == nil || sem.refs != 1 {
+		t.Fatalf("Expected the held semaphore kept with one reference, got %+v", sem)
+	}
+	c.releaseHostSemaphore("example.com")
+	if n := len(c.hostSemaphores); n != 0 {
+		t.Errorf("Expected the semaphore removed after release, got %d", n)
+	}
+}
+
+func TestInFlight(t *testing.T) {
+	srv, arrived, release := gatedServer(t)
+
+	c := NewChecker(&recordingStore{}, Options{HTTPTimeout: 5 * time.Second, CheckMethod: http.MethodGet})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		c.checkTarget(&store.Target{ID: "t_slow", URL: srv.URL, Host: "local"})
+	}()
+
+	select {
+	case <-arrived:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected the slow check to reach the server")
+	}
+	if got := c.InFlight(); !slices.Equal(got, []string{"t_slow"}) {
+		t.Errorf("Expected t_slow in flight, got %v", got)
+	}
+
+	release <- struct{}{}
+	<-done
+	if got := c.InFlight(); len(got) != 0 {
+		t.Errorf("Expected nothing in flight once the check finished, got %v", got)
+	}
+
+	// A panicking check is no longer listed either
+	ok := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ok.Close()
+	c = NewChecker(panickingStore{}, Options{HTTPTimeout: time.Second, CheckMethod: http.MethodGet})
+	func() {
+		defer func() { recover() }()
+		c.checkTarget(&store.Target{ID: "t_panic", URL: ok.URL, Host: "local"})
+	}()
+	if got := c.InFlight(); len(got) != 0 {
+		t.Errorf("Expected a panicked check to be removed, got %v", got)
+	}
+}
+
+func TestPerformCheckBlocksPrivateIPs(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+
+	c := NewChecker(nil, Options{HTTPTimeout: time.Second, CheckMethod: http.MethodGet, BlockPrivateIPs: true})
+	result := c.performCheck(c.ctx, &store.Target{ID: "t_1", URL: srv.URL})
+	if result.Error == nil || !strings.Contains(*result.Error, "refusing to connect to private address 127.0.0.1") {
+		t.Errorf("Expected the loopback test server to be refused, got error %v, status %v", result.Error, result.StatusCode)
+	}
+
+	// A target that redirects inward is refused at the redirect. The
+	// redirector stands in for a public host, so the guard lets it through.
+	redirector := httptest.NewServer(http.RedirectHandler("http://169.254.169.254/latest/meta-data", http.StatusFound))
+	defer redirector.Close()
+	c.transport.(*http.Transport).DialContext = (&net.Dialer{Control: func(network, address string, conn syscall.RawConn) error {
+		if address == redirector.Listener.Addr().String() {
+			return nil
+		}
+		return model.CheckPublicDial(network, address, conn)
+	}}).DialContext
+	result = c.performCheck(c.ctx, &store.Target{ID: "t_2", URL: redirector.URL})
+	if result.Error == nil || !strings.Contains(*result.Error, "private address 169.254.169.254") {
+		t.Errorf("Expected the redirect to the metadata address to be refused, got error %v, status %v", result.Error, result.StatusCode)
+	}
+}
+
+// stubProxy is a forward proxy answering every request itself, recording
+// the absolute URLs it was asked for.
+type stubProxy struct {
+	*httptest.Server
+
+	mu   sync.Mutex
+	urls []string
+}
+
+func newStubProxy(t *testing.T) *stubProxy {
+	p := &stubProxy{}
+	p.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p.mu.Lock()
+		p.urls = append(p.urls, r.URL.String())
+		p.mu.Unlock()
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(p.Close)
+	return p
+}
+
+func (p *stubProxy) traversed() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]string(nil), p.urls...)
+}
+
+func TestPerformCheckUsesProxy(t *testing.T) {
+	global := newStubProxy(t)
+	regional := newStubProxy(t)
+	globalURL, _ := url.Parse(global.URL)
+
+	// The target's host doesn't resolve, so only a proxy can reach it
+	c := NewChecker(nil, Options{HTTPTimeout: time.Second, CheckMethod: http.MethodGet, ProxyURL: globalURL})
+	result := c.performCheck(c.ctx, &store.Target{ID: "t_1", URL: "http://origin.invalid/health"})
+	if result.StatusCode == nil || *result.StatusCode != http.StatusOK {
+		t.Fatalf("Expected 200 through the proxy, got error %v, status %v", result.Error, result.StatusCode)
+	}
+	if got := global.traversed(); len(got) != 1 || got[0] != "http://origin.invalid/health" {
+		t.Errorf("Expected the check to traverse HTTP_PROXY_URL, got %v", got)
+	}
+
+	override := regional.URL
+	c.performCheck(c.ctx, &store.Target{ID: "t_2", URL: "http://eu.origin.invalid/", TargetSettings: store.TargetSettings{Proxy: &override}})
+	if got := regional.traversed(); len(got) != 1 || got[0] != "http://eu.origin.invalid/" {
+		t.Errorf("Expected the check to traverse the target's proxy, got %v", got)
+	}
+	if got := global.traversed(); len(got) != 1 {
+		t.Errorf("Expected the target's proxy to replace HTTP_PROXY_URL, got %v", got)
+	}
+
+	// The configured proxy is trusted on loopback; a target's isn't
+	c = NewChecker(nil, Options{HTTPTimeout: time.Second, CheckMethod: http.MethodGet, ProxyURL: globalURL, BlockPrivateIPs: true})
+	result = c.performCheck(c.ctx, &store.Target{ID: "t_1", URL: "http://origin.invalid/health"})
+	if result.StatusCode == nil || *result.StatusCode != http.StatusOK {
+		t.Errorf("Expected HTTP_PROXY_URL exempt from BlockPrivateIPs, got error %v, status %v", result.Error, result.StatusCode)
+	}
+	result = c.performCheck(c.ctx, &store.Target{ID: "t_2", URL: "http://eu.origin.invalid/", TargetSettings: store.TargetSettings{Proxy: &override}})
+	if result.Error == nil || !strings.Contains(*result.Error, "private address 127.0.0.1") {
+		t.Errorf("Expected the target's loopback proxy to be refused, got error %v, status %v", result.Error, result.StatusCode)
+	}
+}
+
+func TestBlockPrivateIPsIgnoresEnvironmentProxy(t *testing.T) {
+	envProxy := newStubProxy(t)
+	envProxyURL, _ := url.Parse(envProxy.URL)
+	defer func(prev func(*http.Request) (*url.URL, error)) { environmentProxy = prev }(environmentProxy)
+	environmentProxy = func(*http.Request) (*url.URL, error) { return envProxyURL, nil }
+
+	// Without the guard, checks go through the environment's proxy
+	c := NewChecker(nil, Options{HTTPTimeout: time.Second, CheckMethod: http.MethodGet})
+	result := c.performCheck(c.ctx, &store.Target{ID: "t_1", URL: "http://origin.invalid/health"})
+	if result.StatusCode == nil || *result.StatusCode != http.StatusOK {
+		t.Fatalf("Expected 200 through the environment's proxy, got error %v, status %v", result.Error, result.StatusCode)
+	}
+
+	// With it, the proxy can't fetch a private target on the checker's behalf
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer srv.Close()
+	c = NewChecker(nil, Options{HTTPTimeout: time.Second, CheckMethod: http.MethodGet, BlockPrivateIPs: true})
+	result = c.performCheck(c.ctx, &store.Target{ID: "t_2", URL: srv.URL})
+	if result.Error == nil || !strings.Contains(*result.Error, "private address 127.0.0.1") {
+		t.Errorf("Expected the private target to be refused, got error %v, status %v", result.Error, result.StatusCode)
+	}
+	if got := envProxy.traversed(); len(got) != 1 {
+		t.Errorf("Expected the environment's proxy to be skipped, got %v", got)
+	}
+
+	// Nor does a private one, which the guard would refuse, fail every
+	// check: public targets are dialed directly
+	req := httptest.NewRequest(http.MethodGet, "http://93.184.215.14/", nil)
+	if proxy, err := c.transport.(*http.Transport).Proxy(req); proxy != nil || err != nil {
+		t.Errorf("Expected no proxy for a public target, got %v (%v)", proxy, err)
+	}
+
+	// HTTP_PROXY_URL is how to proxy checks with the guard on
+	c = NewChecker(nil, Options{HTTPTimeout: time.Second, CheckMethod: http.MethodGet, BlockPrivateIPs: true, ProxyURL: envProxyURL})
+	if proxy, err := c.transport.(*http.Transport).Proxy(req); proxy != envProxyURL || err != nil {
+		t.Errorf("Expected HTTP_PROXY_URL for a public target, got %v (%v)", proxy, err)
+	}
+}
+
+// stubSOCKS5 is a SOCKS5 gateway without authentication that relays every
+// CONNECT to upstream, whatever address was asked for, recording each one.
+type stubSOCKS5 struct {
+	net.Listener
+	upstream string
+
+	mu    sync.Mutex
+	addrs []string
+}
+
+func newStubSOCKS5(t *testing.T, upstream string) *stubSOCKS5 {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	s := &stubSOCKS5{Listener: ln, upstream: upstream}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *stubSOCKS5) serve(conn net.Conn) {
+	defer conn.Close()
+
+	// Greeting: version 5 and the methods offered; choose no authentication
+	var greeting [2]byte
+	if _, err := io.ReadFull(conn, greeting[:]); err != nil || greeting[0] != 5 {
+		return
+	}
+	if _, err := io.ReadFull(conn, make([]byte, greeting[1])); err != nil {
+		return
+	}
+	conn.Write([]byte{5, 0})
+
+	// Request: version, command, reserved, address type, address, port
+	var req [4]byte
+	if _, err := io.ReadFull(conn, req[:]); err != nil || req[1] != 1 {
+		return
+	}
+	var addr []byte
+	switch req[3] {
+	case 1:
+		addr = make([]byte, net.IPv4len)
+	case 4:
+		addr = make([]byte, net.IPv6len)
+	case 3:
+		var n [1]byte
+		if _, err := io.ReadFull(conn, n[:]); err != nil {
+			return
+		}
+		addr = make([]byte, n[0])
+	default:
+		return
+	}
+	var port [2]byte
+	if _, err := io.ReadFull(conn, addr); err != nil {
+		return
+	}
+	if _, err := io.ReadFull(conn, port[:]); err != nil {
+		return
+	}
+	host := string(addr)
+	if req[3] != 3 {
+		host = net.IP(addr).String()
+	}
+	s.mu.Lock()
+	s.addrs = append(s.addrs, net.JoinHostPort(host, fmt.Sprint(int(port[0])<<8|int(port[1]))))
+	s.mu.Unlock()
+
+	upstream, err := net.Dial("tcp", s.upstream)
+	if err != nil {
+		conn.Write([]byte{5, 5, 0, 1, 0, 0, 0, 0, 0, 0})
+		return
+	}
+	defer upstream.Close()
+	conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+	go io.Copy(upstream, conn)
+	io.Copy(conn, upstream)
+}
+
+func (s *stubSOCKS5) connects() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.addrs...)
+}
+
+func TestPerformCheckUsesSOCKS5Proxy(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer origin.Close()
+	gateway := newStubSOCKS5(t, origin.Listener.Addr().String())
+	gatewayURL, err := model.ParseSOCKS5Proxy(gateway.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to parse gateway address: %v", err)
+	}
+
+	// The target's host doesn't resolve, so only the gateway can reach it
+	c := NewChecker(nil, Options{HTTPTimeout: time.Second, CheckMethod: http.MethodGet, SOCKS5Proxy: gatewayURL})
+	result := c.performCheck(c.ctx, &store.Target{ID: "t_1", URL: "http://origin.invalid/health"})
+	if result.StatusCode == nil || *result.StatusCode != http.StatusOK {
+		t.Fatalf("Expected 200 through the gateway, got error %v, status %v", result.Error, result.StatusCode)
+	}
+	if got := gateway.connects(); len(got) != 1 || got[0] != "origin.invalid:80" {
+		t.Errorf("Expected the check to traverse SOCKS5_PROXY by hostname, got %v", got)
+	}
+
+	// A target's own proxy is reached through the gateway too
+	override := "http://eu-proxy.invalid:3128"
+	result = c.performCheck(c.ctx, &store.Target{ID: "t_2", URL: "http://eu.origin.invalid/", TargetSettings: store.TargetSettings{Proxy: &override}})
+	if result.StatusCode == nil || *result.StatusCode != http.StatusOK {
+		t.Fatalf("Expected 200 through the target's proxy, got error %v, status %v", result.Error, result.StatusCode)
+	}
+	if got := gateway.connects(); len(got) != 2 || got[1] != "eu-proxy.invalid:3128" {
+		t.Errorf("Expected the target's proxy to be dialed through the gateway, got %v", got)
+	}
+}
+
+// claimingStore records claims; fetching all targets fails the test.
+type claimingStore struct {
+	store.Store
+
+	t            *testing.T
+	targets      []*store.Target
+	leases       []time.Duration
+	staleCutoffs []time.Duration // How far before now each stale claim's cutoff was
+}
+
+func (s *claimingStore) GetEnabledTargets(ctx context.Context, afterCreatedAt time.Time, afterID string, limit int) ([]*store.Target, *store.Cursor, error) {
+	s.t.Error("GetEnabledTargets called with ClaimTargets set")
+	return nil, nil, nil
+}
+
+func (s *claimingStore) ClaimDueTargets(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]*store.Target, error) {
+	s.leases = append(s.leases, lease)
+	return s.targets, nil
+}
+
+func (s *claimingStore) GetStaleTargets(ctx context.Context, checkedBefore time.Time, limit int) ([]*store.Target, error) {
+	s.t.Error("GetStaleTargets called with ClaimTargets set")
+	return nil, nil
+}
+
+func (s *claimingStore) ClaimStaleTargets(ctx context.Context, checkedBefore, now time.Time, lease time.Duration, limit int) ([]*store.Target, error) {
+	s.leases = append(s.leases, lease)
+	s.staleCutoffs = append(s.staleCutoffs, now.Sub(checkedBefore))
+	return nil, nil
+}
+
+func TestPassTargetsClaims(t *testing.T) {
+	st := &claimingStore{t: t, targets: []*store.Target{{ID: "t_1"}}}
+	c := NewChecker(st, Options{CheckInterval: time.Minute, ClaimTargets: true})
+
+	targets, _, err := c.passTargets(nil)
+	if err != nil || len(targets) != 1 {
+		t.Fatalf("Expected the claimed target, got %v (err %v)", targets, err)
+	}
+	// Due again slightly before the next tick
+	if len(st.leases) != 1 || st.leases[0] != 54*time.Second {
+		t.Errorf("Expected one claim leased for 54s, got %v", st.leases)
+	}
+}
+
+func TestScheduleStaleChecksClaims(t *testing.T) {
+	st := &claimingStore{t: t}
+	c := NewChecker(st, Options{CheckInterval: time.Minute, MaxStaleness: 5 * time.Minute, ClaimTargets: true})
+
+	c.scheduleStaleChecks(map[string]bool{})
+	if len(st.staleCutoffs) != 1 || st.staleCutoffs[0] != 5*time.Minute {
+		t.Errorf("Expected one stale claim with a 5m cutoff, got %v", st.staleCutoffs)
+	}
+	if len(st.leases) != 1 || st.leases[0] != 54*time.Second {
+		t.Errorf("Expected the stale claim leased like a due one, got %v", st.leases)
+	}
+}
+
+// pagingStore pages through its targets by ID like the real store does by
+// (created_at, id), and counts each stored result per target.
+type pagingStore struct {
+	store.Store
+
+	targets  []*store.Target // Sorted by ID, all with a zero CreatedAt
+	onPage   func(page int)  // Called before each page is served (optional)
+	mu       sync.Mutex
+	pages    int
+	checked  map[string]int
+	finished chan struct{} // Receives once per stored result
+}
+
+func (s *pagingStore) GetEnabledTargets(ctx context.Context, afterCreatedAt time.Time, afterID string, limit int) ([]*store.Target, *store.Cursor, error) {
+	s.mu.Lock()
+	s.pages++
+	page := s.pages
+	s.mu.Unlock()
+	if s.onPage != nil {
+		s.onPage(page)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	i := sort.Search(len(s.targets), func(i int) bool { return s.targets[i].ID > afterID })
+	batch := s.targets[i:min(i+limit, len(s.targets))]
+	if len(batch) == 0 {
+		return nil, nil, nil
+	}
+	last := batch[len(batch)-1]
+	return batch, &store.Cursor{CreatedAt: last.CreatedAt, ID: last.ID}, nil
+}
+
+func (s *pagingStore) InsertCheckResult(ctx context.Context, result *store.CheckResult) error {
+	s.mu.Lock()
+	s.checked[result.TargetID]++
+	s.mu.Unlock()
+	s.finished <- struct{}{}
+	return nil
+}
+
+func newPagingStore(n int) *pagingStore {
+	st := &pagingStore{checked: make(map[string]int), finished: make(chan struct{}, n)}
+	for i := 0; i < n; i++ {
+		st.targets = append(st.targets, &store.Target{ID: fmt.Sprintf("t_%05d", i), URL: "http://paging.invalid/", Host: "paging.invalid"})
+	}
+	return st
+}
+
+func TestScheduleChecksPagesThroughAllTargets(t *testing.T) {
+	const n = 2*scheduleBatchSize + 500
+	st := newPagingStore(n)
+	c := NewChecker(st, Options{HTTPTimeout: time.Second, MaxConcurrency: 50, PerHostConcurrency: 50, CheckMethod: http.MethodGet})
+	c.transport = stubTransport{}
+	defer c.cancel()
+	c.startWorkers()
+
+	c.scheduleChecks()
+	for i := 0; i < n; i++ {
+		select {
+		case <-st.finished:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Expected %d checks in one pass, got %d", n, i)
+		}
+	}
+
+	if st.pages != 3 {
+		t.Errorf("Expected 3 pages of targets, fetched %d", st.pages)
+	}
+	for _, target := range st.targets {
+		if st.checked[target.ID] != 1 {
+			t.Fatalf("Expected %s checked once, got %d", target.ID, st.checked[target.ID])
+		}
+	}
+}
+
+func TestScheduleChecksStopsPagingOnShutdown(t *testing.T) {
+	st := newPagingStore(3 * scheduleBatchSize)
+	c := NewChecker(st, Options{HTTPTimeout: time.Second, MaxConcurrency: 50, PerHostConcurrency: 50, CheckMethod: http.MethodGet})
+	c.transport = stubTransport{}
+	st.onPage = func(page int) {
+		if page == 2 {
+			c.cancel()
+		}
+	}
+	c.startWorkers()
+
+	done := make(chan struct{})
+	go func() {
+		c.scheduleChecks()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the pass to stop once the checker shut down")
+	}
+
+	if st.pages != 2 {
+		t.Errorf("Expected paging to stop at the cancelled page, fetched %d", st.pages)
+	}
+	c.wg.Wait()
+	if got := len(st.finished); got > scheduleBatchSize {
+		t.Errorf("Expected at most the first page checked, got %d", got)
+	}
+}
+
+// openTestStore returns a migrated in-memory SQLite store
+func openTestStore(t *testing.T) *store.SQLiteStore {
+	t.Helper()
+	db, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	db.SetMaxOpenConns(1)
+	if err := store.RunMigrations(db, "../../migrations", true); err != nil {
+		t.Fatalf("Failed to run migrations: %v", err)
+	}
+	return store.NewSQLiteStore(db)
+}
+
+func TestScheduleChecksSkipsPausedTargets(t *testing.T) {
+	st := openTestStore(t)
+	ctx := context.Background()
+
+	target, _, err := st.UpsertTargetByURL(ctx, "http://paused.invalid/", "paused.invalid", store.TargetSettings{})
+	if err != nil {
+		t.Fatalf("Failed to create target: %v", err)
+	}
+
+	c := NewChecker(st, Options{HTTPTimeout: time.Second, MaxConcurrency: 1, CheckMethod: http.MethodGet})
+	c.transport = stubTransport{}
+	defer c.cancel()
+	c.startWorkers()
+
+	// pass runs a scheduling pass and waits up to a second for its result
+	pass := func() int {
+		t.Helper()
+		c.scheduleChecks()
+		deadline := time.Now().Add(time.Second)
+		for {
+			results, _, err := st.GetResults(ctx, target.ID, time.Time{}, "", nil, 10)
+			if err != nil {
+				t.Fatalf("Failed to get results: %v", err)
+			}
+			if len(results) > 0 || time.Now().After(deadline) {
+				return len(results)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	off, on := false, true
+	if _, err := st.UpdateTarget(ctx, target.ID, store.TargetUpdate{Enabled: &off}); err != nil {
+		t.Fatalf("Failed to pause target: %v", err)
+	}
+	if n := pass(); n != 0 {
+		t.Fatalf("Expected a paused target not to be checked, got %d results", n)
+	}
+
+	if _, err := st.UpdateTarget(ctx, target.ID, store.TargetUpdate{Enabled: &on}); err != nil {
+		t.Fatalf("Failed to resume target: %v", err)
+	}
+	if n := pass(); n != 1 {
+		t.Errorf("Expected checks to resume once re-enabled, got %d results", n)
+	}
+}
+
+func TestPerformCheckMatchPattern(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "<h1>Down for maintenance</h1>")
+	}))
+	defer srv.Close()
+
+	// HEAD would return no body, so a pattern forces GET
+	c := NewChecker(nil, Options{HTTPTimeout: time.Second, CheckMethod: http.MethodHead, MaxBodyBytes: 1024})
+
+	tests := []struct {
+		pattern string
+		mode    string
+		failure string // Expected error, "" for success
+	}{
+		{"(?i)maintenance", store.MatchContains, ""},
+		{"Welcome", store.MatchContains, `body does not match "Welcome"`},
+		{"Welcome", store.MatchAbsent, ""},
+		{"maintenance", store.MatchAbsent, `body matches "maintenance", which must be absent`},
+	}
+	for _, tt := range tests {
+		target := &store.Target{ID: "t_1", URL: srv.URL}
+		target.MatchPattern = &tt.pattern
+		target.MatchMode = tt.mode
+
+		result := c.performCheck(c.ctx, target)
+		if result.StatusCode == nil || *result.StatusCode != http.StatusOK {
+			t.Fatalf("%s %q: expected status 200, got %v", tt.mode, tt.pattern, result.StatusCode)
+		}
+		if result.BodyHash == nil {
+			t.Errorf("%s %q: expected the body to be hashed too", tt.mode, tt.pattern)
+		}
+		switch {
+		case tt.failure == "" && result.Error != nil:
+			t.Errorf("%s %q: expected success, got %q", tt.mode, tt.pattern, *result.Error)
+		case tt.failure != "" && (result.Error == nil || *result.Error != tt.failure):
+			t.Errorf("%s %q: expected error %q, got %v", tt.mode, tt.pattern, tt.failure, result.Error)
+		}
+		if got, want := result.Succeeded(nil, nil), tt.failure == ""; got != want {
+			t.Errorf("%s %q: Succeeded = %v, want %v", tt.mode, tt.pattern, got, want)
+		}
+	}
+}
+
+func TestPerformCheckMatchRespectsBodyCap(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, strings.Repeat("x", 100)+"needle")
+	}))
+	defer srv.Close()
+
+	c := NewChecker(nil, Options{HTTPTimeout: time.Second, MaxBodyBytes: 100})
+	pattern := "needle"
+	target := &store.Target{ID: "t_1", URL: srv.URL}
+	target.MatchPattern = &pattern
+
+	result := c.performCheck(c.ctx, target)
+	if result.Error == nil {
+		t.Error("Expected a pattern past MaxBodyBytes not to be found")
+	}
+}
+
+// endlessBody is a response body that never ends, counting what is read from it.
+type endlessBody struct {
+	read int64
+}
+
+func (b *endlessBody) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 'x'
+	}
+	b.read += int64(len(p))
+	return len(p), nil
+}
+
+func (b *endlessBody) Close() error { return nil }
+
+// bodyTransport answers every request with the given body.
+type bodyTransport struct {
+	body io.ReadCloser
+}
+
+func (t bodyTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	return &http.Response{StatusCode: http.StatusOK, Body: t.body, Request: r}, nil
+}
+
+func TestPerformCheckCapsBodyRead(t *testing.T) {
+	for _, method := range []string{http.MethodGet, http.MethodHead} {
+		body := &endlessBody{}
+		c := NewChecker(nil, Options{HTTPTimeout: time.Second, CheckMethod: method, MaxBodyBytes: 1000})
+		c.transport = bodyTransport{body: body}
+
+		result := c.performCheck(c.ctx, &store.Target{ID: "t_1", URL: "http://big.invalid/"})
+		if body.read > 1001 {
+			t.Errorf("%s: expected the read capped near 1000 bytes, read %d", method, body.read)
+		}
+		if !result.Metadata.BodyTruncated() {
+			t.Errorf("%s: expected an endless body to be flagged truncated", method)
+		}
+	}
+}
+
+func TestPerformCheckDrainsBodyForReuse(t *testing.T) {
+	var conns atomic.Int32
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, strings.Repeat("x", 2000))
+	}))
+	srv.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			conns.Add(1)
+		}
+	}
+	srv.Start()
+	defer srv.Close()
+
+	// Hashing is off, so nothing inspects the body, yet it is still drained
+	c := NewChecker(nil, Options{HTTPTimeout: time.Second, CheckMethod: http.MethodGet})
+	c.transport = srv.Client().Transport
+	for i := 0; i < 3; i++ {
+		result := c.performCheck(c.ctx, &store.Target{ID: "t_1", URL: srv.URL})
+		if result.Metadata.BodyTruncated() {
+			t.Error("Expected a body under the cap not to be flagged truncated")
+		}
+	}
+	if got := conns.Load(); got != 1 {
+		t.Errorf("Expected one connection reused across checks, got %d", got)
+	}
+}
+
+func TestPerformCheckContentTypeAndLength(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path == "/chunked" {
+			// Flushing before the end sends the body without a Content-Length
+			io.WriteString(w, `{"status":`)
+			w.(http.Flusher).Flush()
+			io.WriteString(w, `"ok"}`)
+			return
+		}
+		w.Header().Set("Content-Length", "15")
+		io.WriteString(w, `{"status":"ok"}`)
+	}))
+	defer srv.Close()
+
+	c := NewChecker(nil, Options{HTTPTimeout: 5 * time.Second, CheckMethod: http.MethodGet})
+	for _, path := range []string{"/sized", "/chunked"} {
+		result := c.performCheck(c.ctx, &store.Target{ID: "t_1", URL: srv.URL + path})
+		if result.Error != nil {
+			t.Fatalf("%s: check failed: %s", path, *result.Error)
+		}
+		if result.ContentType == nil || *result.ContentType != "application/json" {
+			t.Errorf("%s: expected content type application/json, got %v", path, result.ContentType)
+		}
+		if result.ContentLength == nil || *result.ContentLength != 15 {
+			t.Errorf("%s: expected content length 15, got %v", path, result.ContentLength)
+		}
+	}
+
+	// A HEAD reports the header's length and has no body to count otherwise
+	c = NewChecker(nil, Options{HTTPTimeout: 5 * time.Second, CheckMethod: http.MethodHead})
+	if result := c.performCheck(c.ctx, &store.Target{ID: "t_1", URL: srv.URL + "/sized"}); result.ContentLength == nil || *result.ContentLength != 15 {
+		t.Errorf("Expected HEAD content length 15, got %v", result.ContentLength)
+	}
+	if result := c.performCheck(c.ctx, &store.Target{ID: "t_1", URL: srv.URL + "/chunked"}); result.ContentLength != nil {
+		t.Errorf("Expected no content length for a HEAD without the header, got %d", *result.ContentLength)
+	}
+}
+
+func TestPerformCheckTimingBreakdown(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(50 * time.Millisecond)
+		io.WriteString(w, "ok")
+	}))
+	defer srv.Close()
+
+	// Reading the body lets the connection be reused by the second check
+	c := NewChecker(nil, Options{HTTPTimeout: 5 * time.Second, CheckMethod: http.MethodGet, MaxBodyBytes: 1024})
+	c.transport = srv.Client().Transport
+
+	first := c.performCheck(c.ctx, &store.Target{ID: "t_1", URL: srv.URL})
+	if first.Error != nil {
+		t.Fatalf("Check failed: %s", *first.Error)
+	}
+	if first.DNSMs == nil || first.ConnectMs == nil || first.TLSMs == nil || first.TTFBMs == nil {
+		t.Fatalf("Expected every phase to be recorded, got %+v", first)
+	}
+	if *first.TTFBMs < 50 {
+		t.Errorf("Expected ttfb_ms to include the 50ms handler delay, got %d", *first.TTFBMs)
+	}
+
+	// The phases don't overlap and all fall within the measured latency
+	sum := *first.DNSMs + *first.ConnectMs + *first.TLSMs + *first.TTFBMs
+	if sum > first.LatencyMs {
+		t.Errorf("Phases sum to %dms, more than latency_ms %d", sum, first.LatencyMs)
+	}
+
+	second := c.performCheck(c.ctx, &store.Target{ID: "t_1", URL: srv.URL})
+	if second.Error != nil {
+		t.Fatalf("Check failed: %s", *second.Error)
+	}
+	if *second.DNSMs != 0 || *second.ConnectMs != 0 || *second.TLSMs != 0 {
+		t.Errorf("Expected a reused connection to skip DNS, connect and TLS, got %d/%d/%d",
+			*second.DNSMs, *second.ConnectMs, *second.TLSMs)
+	}
+	if *second.TTFBMs < 50 || *second.TTFBMs > second.LatencyMs {
+		t.Errorf("Expected ttfb_ms between 50 and latency_ms %d on the reused connection, got %d",
+			second.LatencyMs, *second.TTFBMs)
+	}
+}
+
+// stubTransport answers every request with an empty 200.
+type stubTransport struct{}
+
+func (stubTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody, Request: r}, nil
+}
+
+// statusTransport answers every request with its current status.
+type statusTransport struct {
+	status int
+}
+
+func (s *statusTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	return &http.Response{StatusCode: s.status, Body: http.NoBody, Request: r}, nil
+}
+
+func TestCheckRecordsNextCheckAt(t *testing.T) {
+	for _, claim := range []bool{false, true} {
+		st := openTestStore(t)
+		ctx := context.Background()
+		target, _, err := st.UpsertTargetByURL(ctx, "http://example.invalid/", "example.invalid", store.TargetSettings{})
+		if err != nil {
+			t.Fatalf("Failed to create target: %v", err)
+		}
+
+		c := NewChecker(st, Options{HTTPTimeout: time.Second, CheckInterval: time.Minute, CheckMethod: http.MethodGet, ClaimTargets: claim})
+		c.transport = &statusTransport{status: 200}
+		result, err := c.check(ctx, target)
+		if err != nil || result == nil {
+			t.Fatalf("Expected a stored result, got %v", err)
+		}
+
+		stored, err := st.GetTargetByID(ctx, target.ID)
+		if err != nil {
+			t.Fatalf("Failed to get target: %v", err)
+		}
+		if claim {
+			// Claiming owns the next check; a check leaves it alone
+			if stored.NextCheckAt != nil {
+				t.Errorf("Expected no next check without a claim, got %v", stored.NextCheckAt)
+			}
+			continue
+		}
+		want := result.CheckedAt.Add(time.Minute).Truncate(time.Second)
+		if stored.NextCheckAt == nil || !stored.NextCheckAt.Equal(want) {
+			t.Errorf("Expected the next check one interval after %v, got %v", result.CheckedAt, stored.NextCheckAt)
+		}
+	}
+}
+
+func TestBatchedResults(t *testing.T) {
+	st := openTestStore(t)
+	ctx := context.Background()
+	var targets []*store.Target
+	for _, host := range []string{"a.invalid", "b.invalid", "c.invalid"} {
+		target, _, err := st.UpsertTargetByURL(ctx, "http://"+host+"/", host, store.TargetSettings{})
+		if err != nil {
+			t.Fatalf("Failed to create target: %v", err)
+		}
+		targets = append(targets, target)
+	}
+	a, b, cc := targets[0], targets[1], targets[2]
+
+	c := NewChecker(st, Options{HTTPTimeout: time.Second, CheckInterval: time.Minute, CheckMethod: http.MethodGet,
+		ShutdownGrace: 5 * time.Second, ResultBatchSize: 3, ResultFlushInterval: time.Hour})
+	c.transport = &statusTransport{status: 200}
+
+	// stored lists the stored results' targets in ID order
+	stored := func() []string {
+		t.Helper()
+		results, err := st.GetResultsAfterID(ctx, 0, "", "", 100)
+		if err != nil {
+			t.Fatalf("Failed to get results: %v", err)
+		}
+		var ids []string
+		for _, r := range results {
+			ids = append(ids, r.TargetID)
+		}
+		return ids
+	}
+
+	c.checkTarget(a)
+	c.checkTarget(b)
+	if got := stored(); len(got) != 0 {
+		t.Fatalf("Expected results to wait for a full batch, got %v", got)
+	}
+
+	// A second result for a target stores the batch holding its first
+	c.checkTarget(a)
+	if got := stored(); !slices.Equal(got, []string{a.ID, b.ID}) {
+		t.Fatalf("Expected a's first result to be flushed with b's, got %v", got)
+	}
+
+	// A full batch is stored at once, in the order checks finished
+	c.checkTarget(b)
+	c.checkTarget(cc)
+	if got := stored(); !slices.Equal(got, []string{a.ID, b.ID, a.ID, b.ID, cc.ID}) {
+		t.Fatalf("Expected the full batch stored in order, got %v", got)
+	}
+
+	// Shutdown stores what's left
+	c.checkTarget(cc)
+	c.Shutdown()
+	if got := stored(); len(got) != 6 || got[5] != cc.ID {
+		t.Fatalf("Expected shutdown to flush c's last result, got %v", got)
+	}
+
+	// A partial batch is stored once the flush interval passes
+	c = NewChecker(st, Options{HTTPTimeout: time.Second, CheckInterval: time.Minute, CheckMethod: http.MethodGet,
+		ResultBatchSize: 100, ResultFlushInterval: 10 * time.Millisecond})
+	c.transport = &statusTransport{status: 200}
+	c.checkTarget(a)
+	deadline := time.Now().Add(2 * time.Second)
+	for len(stored()) < 7 {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the partial batch to be flushed after its interval")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestDedupedResultsAreNotStreamed(t *testing.T) {
+	st := openTestStore(t)
+	ctx := context.Background()
+	target, _, err := st.UpsertTargetByURL(ctx, "http://example.invalid/", "example.invalid", store.TargetSettings{})
+	if err != nil {
+		t.Fatalf("Failed to create target: %v", err)
+	}
+	broker := NewBroker(10)
+	sub := broker.Subscribe(Filter{})
+	defer sub.Close()
+
+	c := NewChecker(st, Options{HTTPTimeout: time.Second, CheckInterval: time.Minute, CheckMethod: http.MethodGet,
+		DedupResults: true, Broker: broker})
+	transport := &statusTransport{status: 200}
+	c.transport = transport
+	for _, status := range []int{200, 200, 500} {
+		transport.status = status
+		if _, err := c.check(ctx, target); err != nil {
+			t.Fatalf("Check failed: %v", err)
+		}
+	}
+
+	var ids []int64
+	for len(sub.Events()) > 0 {
+		ids = append(ids, (<-sub.Events()).ID)
+	}
+	// The second check folds into the first row and isn't sent again
+	if len(ids) != 2 || ids[0] >= ids[1] {
+		t.Errorf("Expected two events with increasing IDs, got %v", ids)
+	}
+}
+
+// countingStore signals done once per stored result.
+type countingStore struct {
+	store.Store
+	done *sync.WaitGroup
+}
+
+func (s countingStore) InsertCheckResult(ctx context.Context, result *store.CheckResult) error {
+	s.done.Done()
+	return nil
+}
+
+// goroutinesCreated reads the runtime's goroutine creation counter, or -1 on
+// runtimes that don't export it.
+func goroutinesCreated() int64 {
+	sample := []rtmetrics.Sample{{Name: "/sched/goroutines-created:goroutines"}}
+	rtmetrics.Read(sample)
+	if sample[0].Value.Kind() != rtmetrics.KindUint64 {
+		return -1
+	}
+	return int64(sample[0].Value.Uint64())
+}
+
+// BenchmarkDispatch compares a pass over 1000 targets on the worker pool with
+// starting a goroutine per check behind a semaphore, as dispatch used to.
+func BenchmarkDispatch(b *testing.B) {
+	const passSize, concurrency = 1000, 50
+
+	targets := make([]*store.Target, passSize)
+	for i := range targets {
+		targets[i] = &store.Target{ID: fmt.Sprintf("t_%d", i), URL: "http://bench.invalid/", Host: "bench.invalid"}
+	}
+	newChecker := func(done *sync.WaitGroup) *Checker {
+		c := NewChecker(countingStore{done: done}, Options{
+			HTTPTimeout:        time.Second,
+			MaxConcurrency:     concurrency,
+			PerHostConcurrency: passSize,
+			CheckMethod:        http.MethodGet,
+		})
+		c.transport = stubTransport{}
+		return c
+	}
+	report := func(b *testing.B, start int64) {
+		if end := goroutinesCreated(); start >= 0 {
+			b.ReportMetric(float64(end-start)/float64(b.N), "goroutines/op")
+		}
+	}
+
+	b.Run("goroutine-per-check", func(b *testing.B) {
+		var done sync.WaitGroup
+		c := newChecker(&done)
+		defer c.cancel()
+		sem := make(chan struct{}, concurrency)
+
+		b.ReportAllocs()
+		start := goroutinesCreated()
+		for b.Loop() {
+			done.Add(passSize)
+			for _, target := range targets {
+				sem <- struct{}{}
+				go func() {
+					defer func() { <-sem }()
+					c.checkTarget(target)
+				}()
+			}
+			done.Wait()
+		}
+		report(b, start)
+	})
+
+	b.Run("worker-pool", func(b *testing.B) {
+		var done sync.WaitGroup
+		c := newChecker(&done)
+		defer c.cancel()
+		c.startWorkers()
+
+		b.ReportAllocs()
+		start := goroutinesCreated()
+		for b.Loop() {
+			done.Add(passSize)
+			for _, target := range targets {
+				c.dispatch(target)
+			}
+			done.Wait()
+		}
+		report(b, start)
+	})
+}
+
+func TestCheckTransportIdleConns(t *testing.T) {
+	transport := checkTransport(Options{})
+	if transport.MaxIdleConnsPerHost != defaultIdleConnsPerHost || transport.IdleConnTimeout != defaultIdleConnTimeout {
+		t.Errorf("Expected the defaults for zero options, got %d and %v", transport.MaxIdleConnsPerHost, transport.IdleConnTimeout)
+	}
+
+	transport = checkTransport(Options{IdleConnsPerHost: 16, IdleConnTimeout: 30 * time.Second})
+	if transport.MaxIdleConnsPerHost != 16 || transport.IdleConnTimeout != 30*time.Second {
+		t.Errorf("Expected 16 connections kept 30s, got %d and %v", transport.MaxIdleConnsPerHost, transport.IdleConnTimeout)
+	}
+}
+
+// BenchmarkRepeatedChecks checks one host over and over, comparing a fresh
+// transport per check with the shared one, and reports the connections
+// the server accepted per check.
+func BenchmarkRepeatedChecks(b *testing.B) {
+	var conns atomic.Int64
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok")
+	}))
+	srv.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			conns.Add(1)
+		}
+	}
+	srv.Start()
+	defer srv.Close()
+
+	target := &store.Target{ID: "t_1", URL: srv.URL, Host: "127.0.0.1"}
+	opts := Options{HTTPTimeout: time.Second, CheckMethod: http.MethodGet}
+	run := func(b *testing.B, c *Checker, fresh bool) {
+		defer c.cancel()
+		start := conns.Load()
+		for b.Loop() {
+			var transport *http.Transport
+			if fresh {
+				transport = checkTransport(opts)
+				c.transport = transport
+			}
+			if result := c.performCheck(c.ctx, target); result.Error != nil {
+				b.Fatalf("Check failed: %s", *result.Error)
+			}
+			if fresh {
+				transport.CloseIdleConnections()
+			}
+		}
+		b.ReportMetric(float64(conns.Load()-start)/float64(b.N), "conns/op")
+	}
+
+	b.Run("transport-per-check", func(b *testing.B) {
+		run(b, NewChecker(nil, opts), true)
+	})
+	b.Run("shared-transport", func(b *testing.B) {
+		run(b, NewChecker(nil, opts), false)
+	})
+}
@@ -0,0 +1,67 @@
+package checker
+
+import (
+	"context"
+	"time"
+)
+
+// schedulerLease is the lease name contended for by nodes that want to schedule checks.
+const schedulerLease = "scheduler"
+
+// leaseLoop keeps trying to acquire or renew the scheduler lease until shutdown.
+// Renewal happens well within the TTL so a healthy leader never lapses.
+func (c *Checker) leaseLoop() {
+	defer c.wg.Done()
+
+	ticker := time.NewTicker(c.leaseTTL / 3)
+	defer ticker.Stop()
+
+	for {
+		c.renewLease()
+
+		select {
+		case <-c.ctx.Done():
+			c.releaseLease()
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// renewLease tries to hold the lease and logs leadership changes. An error counts
+// as losing the lease, since another node may take over once it expires.
+func (c *Checker) renewLease() {
+	acquired, err := c.store.AcquireLease(c.ctx, schedulerLease, c.nodeID, c.leaseTTL)
+	if err != nil {
+		c.logger.Error("failed to renew scheduler lease", "error", err)
+		acquired = false
+	}
+
+	wasLeader := c.leader.Swap(acquired)
+	switch {
+	case acquired && !wasLeader:
+		c.logger.Info("became scheduler leader", "node_id", c.nodeID)
+	case !acquired && wasLeader:
+		c.logger.Info("lost scheduler leadership, stepping down", "node_id", c.nodeID)
+	}
+}
+
+// releaseLease hands the lease back on shutdown so another node can take over
+// without waiting for it to expire.
+func (c *Checker) releaseLease() {
+	if !c.leader.Swap(false) {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.httpTimeout)
+	defer cancel()
+
+	if err := c.store.ReleaseLease(ctx, schedulerLease, c.nodeID); err != nil {
+		c.logger.Error("failed to release scheduler lease", "error", err)
+	}
+}
+
+// isLeader reports whether this node should schedule checks.
+func (c *Checker) isLeader() bool {
+	return !c.leaderElection || c.leader.Load()
+}
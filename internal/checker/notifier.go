@@ -0,0 +1,281 @@
+package checker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/you/linkwatch/internal/metrics"
+	"github.com/you/linkwatch/internal/store"
+)
+
+// defaultNotifyQueue is how many transitions may wait for delivery before
+// new ones are dropped.
+const defaultNotifyQueue = 100
+
+// defaultNotifyBackoff is the delay before the first redelivery when
+// NotifierOptions.RetryBackoff is zero.
+const defaultNotifyBackoff = time.Second
+
+// Transition is the webhook payload sent when a target changes state.
+type Transition struct {
+	TargetID  string    `json:"target_id"`
+	URL       string    `json:"url"`
+	OldState  string    `json:"old_state"`
+	NewState  string    `json:"new_state"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// NotifierOptions configures a Notifier.
+type NotifierOptions struct {
+	Timeout   time.Duration // Bounds each delivery attempt
+	QueueSize int           // Transitions buffered for delivery; zero means 100
+
+	// A failed delivery is retried up to Retries times, waiting RetryBackoff
+	// (zero means 1s) and doubling it after each attempt. Only errors, 5xx
+	// and 429 responses are retried.
+	Retries      int
+	RetryBackoff time.Duration
+
+	Metrics *metrics.Metrics // Counts dropped transitions, may be nil
+	Logger  *slog.Logger     // nil means slog.Default()
+}
+
+// Notifier POSTs transitions to a webhook from a single background worker.
+// Notify never blocks: when the queue is full the transition is dropped
+// rather than stalling the checker on a slow endpoint. Dropped transitions,
+// ones whose retries run out, and ones still undelivered when shutdown cuts
+// the worker off are logged with their payload so they can be replayed by
+// hand.
+type Notifier struct {
+	url          string
+	client       *http.Client
+	queue        chan Transition
+	finishing    chan struct{} // Closed to have run return once the queue is empty
+	finishOnce   sync.Once
+	retries      int
+	retryBackoff time.Duration
+	dropped      atomic.Uint64
+	metrics      *metrics.Metrics
+	logger       *slog.Logger
+}
+
+// NewNotifier creates a notifier for webhookURL.
+func NewNotifier(webhookURL string, opts NotifierOptions) *Notifier {
+	queueSize := opts.QueueSize
+	if queueSize <= 0 {
+		queueSize = defaultNotifyQueue
+	}
+	backoff := opts.RetryBackoff
+	if backoff <= 0 {
+		backoff = defaultNotifyBackoff
+	}
+	logger := opts.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return &Notifier{
+		url:          webhookURL,
+		client:       &http.Client{Timeout: opts.Timeout},
+		queue:        make(chan Transition, queueSize),
+		finishing:    make(chan struct{}),
+		retries:      opts.Retries,
+		retryBackoff: backoff,
+		metrics:      opts.Metrics,
+		logger:       logger,
+	}
+}
+
+// Notify queues a transition for delivery.
+func (n *Notifier) Notify(t Transition) {
+	select {
+	case n.queue <- t:
+	default:
+		n.drop(t, "queue_full", errors.New("webhook queue full"))
+	}
+}
+
+// Dropped reports how many transitions were never delivered, because the
+// queue was full or every delivery attempt failed.
+func (n *Notifier) Dropped() uint64 {
+	return n.dropped.Load()
+}
+
+// drop counts an undelivered transition and dead-letters it to the log.
+func (n *Notifier) drop(t Transition, reason string, err error) {
+	n.dropped.Add(1)
+	n.metrics.ObserveNotificationDropped(reason)
+
+	payload, _ := json.Marshal(t) // A Transition always marshals
+	n.logger.Error("webhook transition dropped", "reason", reason, "target_id", t.TargetID,
+		"new_state", t.NewState, "payload", string(payload), "error", err)
+}
+
+// finish has run deliver what is queued and then return, rather than wait
+// for more. Transitions notified after that stay queued.
+func (n *Notifier) finish() {
+	n.finishOnce.Do(func() { close(n.finishing) })
+}
+
+// run delivers queued transitions until ctx is cancelled, or until the
+// queue is empty once finish is called. Whatever is left undelivered when
+// ctx is cancelled is dropped.
+func (n *Notifier) run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			n.dropQueued()
+			return
+		case t := <-n.queue:
+			n.deliverWithRetries(ctx, t)
+		case <-n.finishing:
+			for {
+				select {
+				case t := <-n.queue:
+					n.deliverWithRetries(ctx, t)
+					if ctx.Err() != nil {
+						n.dropQueued()
+						return
+					}
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// dropQueued dead-letters every queued transition after shutdown cut
+// delivery off.
+func (n *Notifier) dropQueued() {
+	for {
+		select {
+		case t := <-n.queue:
+			n.drop(t, "shutdown", errors.New("shut down before delivery"))
+		default:
+			return
+		}
+	}
+}
+
+// deliverWithRetries delivers a transition, retrying failures with
+// exponential backoff, and drops it once the retries run out or ctx is
+// cancelled.
+func (n *Notifier) deliverWithRetries(ctx context.Context, t Transition) {
+	backoff := n.retryBackoff
+	for attempt := 1; ; attempt++ {
+		err := n.deliver(ctx, t)
+		if err == nil {
+			return
+		}
+		if ctx.Err() != nil {
+			n.drop(t, "shutdown", err)
+			return
+		}
+		var status *webhookStatusError
+		if attempt > n.retries || (errors.As(err, &status) && !status.retryable()) {
+			n.drop(t, "delivery_failed", fmt.Errorf("after %d attempts: %w", attempt, err))
+			return
+		}
+		n.logger.Warn("webhook delivery failed, retrying", "target_id", t.TargetID, "attempt", attempt, "error", err)
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			n.drop(t, "shutdown", fmt.Errorf("after %d attempts: %w", attempt, err))
+			return
+		case <-timer.C:
+		}
+		backoff *= 2
+	}
+}
+
+// webhookStatusError is a delivery the webhook answered with a failure status.
+type webhookStatusError struct {
+	status string
+	code   int
+}
+
+func (e *webhookStatusError) Error() string {
+	return "webhook returned " + e.status
+}
+
+// retryable reports whether the webhook might accept the transition later:
+// a server error, or throttling.
+func (e *webhookStatusError) retryable() bool {
+	return e.code >= 500 || e.code == http.StatusTooManyRequests
+}
+
+func (n *Notifier) deliver(ctx context.Context, t Transition) error {
+	body, err := json.Marshal(t)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return &webhookStatusError{status: resp.Status, code: resp.StatusCode}
+	}
+	return nil
+}
+
+// notifyTransition reports a change between the target's state before and
+// after a result was stored. A target's first known state has nothing to
+// compare against, and failures below its threshold leave the state alone. Changes
+// inside the target's maintenance window are expected and held back: the
+// first check after the window reports the change from the state last
+// notified, if the target didn't return to it.
+func (c *Checker) notifyTransition(target *store.Target, previous, current string, at time.Time) {
+	if c.notifier == nil || previous == "" || previous == store.StateUnknown || current == "" {
+		return
+	}
+
+	c.heldMutex.Lock()
+	notified, held := c.heldStates[target.ID]
+	if target.Maintenance != nil && target.Maintenance.Active(at) {
+		if previous != current {
+			if !held {
+				c.heldStates[target.ID] = previous
+			}
+			c.logger.Info("suppressed transition during maintenance", "target_id", target.ID,
+				"old_state", previous, "new_state", current)
+		}
+		c.heldMutex.Unlock()
+		return
+	}
+	delete(c.heldStates, target.ID)
+	c.heldMutex.Unlock()
+
+	if !held {
+		notified = previous
+	}
+	if notified == current {
+		return
+	}
+	c.notifier.Notify(Transition{
+		TargetID:  target.ID,
+		URL:       target.URL,
+		OldState:  notified,
+		NewState:  current,
+		Timestamp: at,
+	})
+}
@@ -0,0 +1,415 @@
+package checker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/you/linkwatch/internal/metrics"
+	"github.com/you/linkwatch/internal/model"
+	"github.com/you/linkwatch/internal/store"
+)
+
+func TestNotifierDeliversTransitions(t *testing.T) {
+	received := make(chan Transition, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var tr Transition
+		if err := json.NewDecoder(r.Body).Decode(&tr); err != nil {
+			t.Errorf("Failed to decode webhook payload: %v", err)
+		}
+		received <- tr
+	}))
+	defer srv.Close()
+
+	n := NewNotifier(srv.URL, NotifierOptions{Timeout: time.Second})
+	c := NewChecker(nil, Options{Notifier: n})
+	go n.run(c.ctx)
+	defer c.cancel()
+
+	target := &store.Target{ID: "t_1", URL: "https://example.com"}
+	up, down := store.StateUp, store.StateDown
+	checkedAt := time.Now()
+
+	c.notifyTransition(target, "", up, checkedAt)   // First check, nothing to compare
+	c.notifyTransition(target, up, up, checkedAt)   // Still up
+	c.notifyTransition(target, up, down, checkedAt) // Went down
+
+	select {
+	case tr := <-received:
+		if tr.TargetID != "t_1" || tr.URL != target.URL || tr.OldState != store.StateUp || tr.NewState != store.StateDown {
+			t.Errorf("Unexpected transition %+v", tr)
+		}
+		if !tr.Timestamp.Equal(checkedAt.Truncate(0)) {
+			t.Errorf("Expected timestamp %v, got %v", checkedAt, tr.Timestamp)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Webhook was not called")
+	}
+
+	select {
+	case tr := <-received:
+		t.Errorf("Expected a single webhook call, also got %+v", tr)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestMaintenanceTagsResultsWithoutNotifying(t *testing.T) {
+	st := openTestStore(t)
+	ctx := context.Background()
+
+	received := make(chan Transition, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var tr Transition
+		if err := json.NewDecoder(r.Body).Decode(&tr); err != nil {
+			t.Errorf("Failed to decode webhook payload: %v", err)
+		}
+		received <- tr
+	}))
+	defer srv.Close()
+
+	// A window spanning the whole day is always in effect
+	window := &model.Schedule{Start: "00:00", End: "24:00"}
+	target, _, err := st.UpsertTargetByURL(ctx, "http://deploying.invalid/", "deploying.invalid",
+		store.TargetSettings{Maintenance: window})
+	if err != nil {
+		t.Fatalf("Failed to create target: %v", err)
+	}
+
+	n := NewNotifier(srv.URL, NotifierOptions{Timeout: time.Second})
+	c := NewChecker(st, Options{HTTPTimeout: time.Second, CheckMethod: http.MethodGet, Notifier: n})
+	go n.run(c.ctx)
+	defer c.cancel()
+	statuses := &statusTransport{}
+	c.transport = statuses
+
+	for _, status := range []int{200, 503, 200} {
+		statuses.status = status
+		c.checkTarget(target)
+	}
+
+	results, _, err := st.GetResults(ctx, target.ID, time.Time{}, "", nil, 10)
+	if err != nil || len(results) != 3 {
+		t.Fatalf("Expected 3 results, got %d: %v", len(results), err)
+	}
+	for _, r := range results {
+		if !r.InMaintenance {
+			t.Errorf("Expected result %d to be tagged in_maintenance", r.ID)
+		}
+	}
+
+	select {
+	case tr := <-received:
+		t.Errorf("Expected no webhook during maintenance, got %+v", tr)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// Once the window is gone, the same change notifies
+	target.Maintenance = nil
+	statuses.status = 503
+	c.checkTarget(target)
+	select {
+	case tr := <-received:
+		if tr.NewState != store.StateDown {
+			t.Errorf("Expected a transition to down, got %+v", tr)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Webhook was not called after maintenance")
+	}
+}
+
+func TestMaintenanceReportsOutageOutlastingWindow(t *testing.T) {
+	n := NewNotifier("http://unused.invalid", NotifierOptions{Timeout: time.Second, QueueSize: 10})
+	c := NewChecker(nil, Options{Notifier: n})
+	defer c.cancel()
+
+	window := &model.Schedule{Start: "02:00", End: "03:00"}
+	target := &store.Target{ID: "t_1", URL: "https://example.com"}
+	target.Maintenance = window
+	up, down := store.StateUp, store.StateDown
+	day := time.Date(2026, 1, 5, 0, 0, 0, 0, time.UTC)
+	queued := func() []Transition {
+		var trs []Transition
+		for len(n.queue) > 0 {
+			trs = append(trs, <-n.queue)
+		}
+		return trs
+	}
+
+	// Goes down during the window and is still down after it
+	c.notifyTransition(target, up, down, day.Add(2*time.Hour+10*time.Minute))
+	c.notifyTransition(target, down, down, day.Add(2*time.Hour+50*time.Minute))
+	if trs := queued(); len(trs) != 0 {
+		t.Fatalf("Expected nothing sent during the window, got %+v", trs)
+	}
+	c.notifyTransition(target, down, down, day.Add(3*time.Hour+time.Minute))
+	if trs := queued(); len(trs) != 1 || trs[0].OldState != store.StateUp || trs[0].NewState != store.StateDown {
+		t.Fatalf("Expected the held up -> down sent after the window, got %+v", trs)
+	}
+	c.notifyTransition(target, down, up, day.Add(4*time.Hour))
+	if trs := queued(); len(trs) != 1 || trs[0].OldState != store.StateDown || trs[0].NewState != store.StateUp {
+		t.Fatalf("Expected the recovery to follow the down, got %+v", trs)
+	}
+
+	// Goes down and recovers inside the window: nothing is outstanding
+	day = day.AddDate(0, 0, 1)
+	c.notifyTransition(target, up, down, day.Add(2*time.Hour+10*time.Minute))
+	c.notifyTransition(target, down, up, day.Add(2*time.Hour+20*time.Minute))
+	c.notifyTransition(target, up, up, day.Add(3*time.Hour+time.Minute))
+	if trs := queued(); len(trs) != 0 {
+		t.Errorf("Expected no transition for an outage over within the window, got %+v", trs)
+	}
+}
+
+func TestCheckTargetNotifiesAfterFailureThreshold(t *testing.T) {
+	st := openTestStore(t)
+	ctx := context.Background()
+
+	threshold := 3
+	expected := 401
+	target, _, err := st.UpsertTargetByURL(ctx, "http://flaky.invalid/", "flaky.invalid",
+		store.TargetSettings{FailureThreshold: &threshold, ExpectedStatus: &expected})
+	if err != nil {
+		t.Fatalf("Failed to create target: %v", err)
+	}
+
+	n := NewNotifier("http://unused.invalid", NotifierOptions{Timeout: time.Second, QueueSize: 10})
+	c := NewChecker(st, Options{HTTPTimeout: time.Second, CheckMethod: http.MethodGet, Notifier: n})
+	statuses := &statusTransport{}
+	c.transport = statuses
+
+	// check runs one check answered with status and returns the state after it
+	check := func(status int) string {
+		t.Helper()
+		statuses.status = status
+		c.checkTarget(target)
+		state, err := st.GetState(ctx, target.ID)
+		if err != nil {
+			t.Fatalf("GetState failed: %v", err)
+		}
+		return state.State
+	}
+	queued := func() []Transition {
+		var trs []Transition
+		for {
+			select {
+			case tr := <-n.queue:
+				trs = append(trs, tr)
+			default:
+				return trs
+			}
+		}
+	}
+
+	// 200 isn't what this target expects, so it counts as a failure
+	for i, status := range []int{401, 503, 200} {
+		if got := check(status); got != store.StateUp {
+			t.Fatalf("Expected up after check %d (%d), got %s", i+1, status, got)
+		}
+	}
+	if trs := queued(); len(trs) != 0 {
+		t.Errorf("Expected no transition below the threshold, got %+v", trs)
+	}
+
+	if got := check(503); got != store.StateDown {
+		t.Fatalf("Expected down on the 3rd consecutive failure, got %s", got)
+	}
+	if trs := queued(); len(trs) != 1 || trs[0].OldState != store.StateUp || trs[0].NewState != store.StateDown {
+		t.Errorf("Expected one up -> down transition, got %+v", trs)
+	}
+
+	// The first success resets the count
+	if got := check(401); got != store.StateUp {
+		t.Fatalf("Expected up after a success, got %s", got)
+	}
+	if trs := queued(); len(trs) != 1 || trs[0].NewState != store.StateUp {
+		t.Errorf("Expected one down -> up transition, got %+v", trs)
+	}
+	if got := check(503); got != store.StateUp {
+		t.Errorf("Expected a single failure after recovery to stay up, got %s", got)
+	}
+}
+
+func TestNotifierDoesNotBlockOnSlowWebhook(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer srv.Close()
+	defer close(release)
+
+	n := NewNotifier(srv.URL, NotifierOptions{Timeout: time.Minute, QueueSize: 2, Logger: slog.New(slog.DiscardHandler)})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go n.run(ctx)
+
+	start := time.Now()
+	for i := 0; i < 10; i++ {
+		n.Notify(Transition{TargetID: "t_1", NewState: store.StateDown})
+	}
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Errorf("Notify blocked for %v", elapsed)
+	}
+
+	// One in flight and two queued at most; the rest are dropped
+	if dropped := n.Dropped(); dropped < 7 {
+		t.Errorf("Expected at least 7 dropped transitions, got %d", dropped)
+	}
+}
+
+func TestNotifierRetriesFailedDelivery(t *testing.T) {
+	var calls atomic.Int32
+	delivered := make(chan Transition, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) <= 2 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var tr Transition
+		json.NewDecoder(r.Body).Decode(&tr)
+		delivered <- tr
+	}))
+	defer srv.Close()
+
+	n := NewNotifier(srv.URL, NotifierOptions{Timeout: time.Second, Retries: 3, RetryBackoff: time.Millisecond,
+		Logger: slog.New(slog.DiscardHandler)})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go n.run(ctx)
+
+	n.Notify(Transition{TargetID: "t_1", NewState: store.StateDown})
+	select {
+	case tr := <-delivered:
+		if tr.TargetID != "t_1" {
+			t.Errorf("Unexpected transition %+v", tr)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Transition was not redelivered")
+	}
+	if got := calls.Load(); got != 3 {
+		t.Errorf("Expected delivery on the third attempt, got %d calls", got)
+	}
+	if dropped := n.Dropped(); dropped != 0 {
+		t.Errorf("Expected nothing dropped, got %d", dropped)
+	}
+}
+
+func TestNotifierCountsDroppedOnSustainedFailure(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer srv.Close()
+
+	var logs bytes.Buffer
+	mtr := metrics.New(prometheus.NewRegistry())
+	n := NewNotifier(srv.URL, NotifierOptions{Timeout: time.Second, QueueSize: 1, Retries: 2, RetryBackoff: time.Millisecond,
+		Metrics: mtr, Logger: slog.New(slog.NewJSONHandler(&logs, nil))})
+
+	// With no worker running yet, only the first transition fits the queue
+	for i := 0; i < 3; i++ {
+		n.Notify(Transition{TargetID: "t_1", NewState: store.StateDown})
+	}
+	if full := testutil.ToFloat64(mtr.NotificationsDropped.WithLabelValues("queue_full")); full != 2 {
+		t.Errorf("Expected 2 transitions dropped on a full queue, got %v", full)
+	}
+	if out := logs.String(); !strings.Contains(out, `"payload":"{\"target_id\":\"t_1\"`) {
+		t.Errorf("Expected dead-letter lines with the payload, got %s", out)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go n.run(ctx)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for testutil.ToFloat64(mtr.NotificationsDropped.WithLabelValues("delivery_failed")) < 1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the queued transition to fail for good, got %d calls", calls.Load())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if got := calls.Load(); got != 3 {
+		t.Errorf("Expected 3 delivery attempts, got %d", got)
+	}
+	if dropped := n.Dropped(); dropped != 3 {
+		t.Errorf("Expected all 3 transitions dropped, got %d", dropped)
+	}
+}
+
+func TestShutdownDeliversQueuedTransitions(t *testing.T) {
+	arrived := make(chan struct{}, 10)
+	release := make(chan struct{})
+	var delivered atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		arrived <- struct{}{}
+		<-release
+		delivered.Add(1)
+	}))
+	defer srv.Close()
+
+	n := NewNotifier(srv.URL, NotifierOptions{Timeout: time.Minute, Logger: slog.New(slog.DiscardHandler)})
+	c := NewChecker(&recordingStore{}, Options{CheckInterval: time.Hour, HTTPTimeout: time.Second,
+		MaxConcurrency: 1, ShutdownGrace: 5 * time.Second, Notifier: n})
+	c.Start()
+
+	for i := 0; i < 3; i++ {
+		n.Notify(Transition{TargetID: "t_1", NewState: store.StateDown})
+	}
+	<-arrived // One in flight, two queued
+
+	shutDown := make(chan struct{})
+	go func() {
+		c.Shutdown()
+		close(shutDown)
+	}()
+	close(release)
+
+	select {
+	case <-shutDown:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Shutdown did not return")
+	}
+	if got := delivered.Load(); got != 3 {
+		t.Errorf("Expected all 3 queued transitions delivered before shutdown returned, got %d", got)
+	}
+	if dropped := n.Dropped(); dropped != 0 {
+		t.Errorf("Expected nothing dropped, got %d", dropped)
+	}
+}
+
+func TestShutdownDropsTransitionsPastGrace(t *testing.T) {
+	hang := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-hang:
+		case <-r.Context().Done():
+		}
+	}))
+	defer srv.Close()
+	defer close(hang)
+
+	mtr := metrics.New(prometheus.NewRegistry())
+	n := NewNotifier(srv.URL, NotifierOptions{Timeout: time.Minute, Metrics: mtr, Logger: slog.New(slog.DiscardHandler)})
+	c := NewChecker(&recordingStore{}, Options{CheckInterval: time.Hour, HTTPTimeout: time.Second,
+		MaxConcurrency: 1, ShutdownGrace: 50 * time.Millisecond, Notifier: n})
+	c.Start()
+
+	n.Notify(Transition{TargetID: "t_1", NewState: store.StateDown})
+	n.Notify(Transition{TargetID: "t_2", NewState: store.StateDown})
+	c.Shutdown()
+
+	// The one in flight is cut off and the queued one dead-lettered
+	if got := testutil.ToFloat64(mtr.NotificationsDropped.WithLabelValues("shutdown")); got != 2 {
+		t.Errorf("Expected 2 transitions dropped at shutdown, got %v", got)
+	}
+}
@@ -0,0 +1,92 @@
+package checker
+
+import "github.com/you/linkwatch/internal/store"
+
+// The worker pool runs checks on maxConcurrency long-lived goroutines fed
+// from c.jobs, so a pass over many targets reuses the same goroutines instead
+// of starting one per check. Reload resizes it: growing starts workers at
+// once, shrinking retires workers as they go idle without interrupting
+// checks already running.
+
+// newJobQueue buffers one target per worker, enough to keep every worker busy
+// while the scheduler blocks on the rest of the pass.
+func newJobQueue(size int) chan *store.Target {
+	return make(chan *store.Target, size)
+}
+
+// startWorkers starts the pool at its configured size.
+func (c *Checker) startWorkers() {
+	c.reloadMutex.Lock()
+	defer c.reloadMutex.Unlock()
+
+	c.poolStarted = true
+	c.addWorkers(c.maxConcurrency)
+}
+
+// resizeWorkers moves the pool from size from to size to; c.reloadMutex must be held.
+func (c *Checker) resizeWorkers(from, to int) {
+	if !c.poolStarted {
+		return
+	}
+	if to > from {
+		c.addWorkers(to - from)
+		return
+	}
+
+	// Busy workers take their retirement once their check is done
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		for i := 0; i < from-to; i++ {
+			select {
+			case c.retire <- struct{}{}:
+			case <-c.ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+func (c *Checker) addWorkers(n int) {
+	c.wg.Add(n)
+	for i := 0; i < n; i++ {
+		go c.worker()
+	}
+}
+
+// worker checks queued targets until it is retired or the checker stops.
+func (c *Checker) worker() {
+	defer c.wg.Done()
+
+	for {
+		// A pending retirement wins over queued work so a shrink holds
+		select {
+		case <-c.retire:
+			return
+		default:
+		}
+
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-c.retire:
+			return
+		case target := <-c.jobs:
+			if c.ctx.Err() != nil {
+				return
+			}
+			c.checkTarget(target)
+		}
+	}
+}
+
+// enqueue hands the target to the pool, waiting while the queue is full.
+// It returns false if the checker is shutting down.
+func (c *Checker) enqueue(target *store.Target) bool {
+	select {
+	case c.jobs <- target:
+		return true
+	case <-c.ctx.Done():
+		return false
+	}
+}
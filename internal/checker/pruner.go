@@ -0,0 +1,37 @@
+package checker
+
+import (
+	"time"
+)
+
+// pruner periodically deletes results past their retention.
+func (c *Checker) pruner() {
+	defer c.wg.Done()
+
+	ticker := time.NewTicker(c.pruneInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-ticker.C:
+			// Purging is cluster-wide work, so only the scheduler leader does it
+			if c.isLeader() {
+				c.pruneResults()
+			}
+		}
+	}
+}
+
+// pruneResults applies per-target retention overrides and the global default.
+func (c *Checker) pruneResults() {
+	deleted, err := c.store.DeleteExpiredResults(c.ctx, time.Now(), c.resultRetention)
+	if err != nil {
+		c.logger.Error("failed to prune results", "error", err)
+		return
+	}
+	if deleted > 0 {
+		c.logger.Info("pruned expired results", "deleted", deleted)
+	}
+}
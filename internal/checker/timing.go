@@ -0,0 +1,101 @@
+package checker
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http/httptrace"
+	"sync"
+	"time"
+
+	"github.com/you/linkwatch/internal/store"
+)
+
+// phaseTimer adds up how long a request spent in each connection phase,
+// across every hop of a redirect chain. Hooks for a phase only fire when it
+// happens, so a reused connection leaves DNS, connect and TLS at zero.
+type phaseTimer struct {
+	mu      sync.Mutex
+	dns     time.Duration
+	connect time.Duration
+	tls     time.Duration
+	ttfb    time.Duration
+
+	dnsStart     time.Time
+	connectStart map[string]time.Time // By address; dual-stack dials race
+	tlsStart     time.Time
+	wroteRequest time.Time
+}
+
+// withTrace returns ctx with hooks recording into t.
+func (t *phaseTimer) withTrace(ctx context.Context) context.Context {
+	return httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			t.dnsStart = time.Now()
+		},
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			t.dns += since(t.dnsStart)
+		},
+		ConnectStart: func(network, addr string) {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			if t.connectStart == nil {
+				t.connectStart = make(map[string]time.Time)
+			}
+			t.connectStart[network+" "+addr] = time.Now()
+		},
+		ConnectDone: func(network, addr string, err error) {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			// Only the dial that won counts
+			if err == nil {
+				t.connect += since(t.connectStart[network+" "+addr])
+			}
+		},
+		TLSHandshakeStart: func() {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			t.tlsStart = time.Now()
+		},
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			t.tls += since(t.tlsStart)
+		},
+		WroteRequest: func(httptrace.WroteRequestInfo) {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			t.wroteRequest = time.Now()
+		},
+		GotFirstResponseByte: func() {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			t.ttfb += since(t.wroteRequest)
+		},
+	})
+}
+
+// since is time.Since, but zero for a phase whose start wasn't seen.
+func since(start time.Time) time.Duration {
+	if start.IsZero() {
+		return 0
+	}
+	return time.Since(start)
+}
+
+// record sets the result's phase durations in milliseconds.
+func (t *phaseTimer) record(result *store.CheckResult) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	ms := func(d time.Duration) *int {
+		v := int(d.Milliseconds())
+		return &v
+	}
+	result.DNSMs = ms(t.dns)
+	result.ConnectMs = ms(t.connect)
+	result.TLSMs = ms(t.tls)
+	result.TTFBMs = ms(t.ttfb)
+}
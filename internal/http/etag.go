@@ -0,0 +1,53 @@
+package http
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/you/linkwatch/internal/store"
+)
+
+// listingETag is a weak validator for a targets listing: it changes when a
+// target matching the filter is added or removed, and the body hash catches
+// in-place edits such as re-canonicalized URLs.
+func listingETag(version *store.TargetsVersion, body []byte) string {
+	sum := sha256.Sum256(body)
+	return fmt.Sprintf(`W/"%d-%d-%s"`, version.Count, version.LastModified.Unix(), hex.EncodeToString(sum[:8]))
+}
+
+// etagMatches reports whether an If-None-Match header names etag, using the
+// weak comparison RFC 9110 prescribes for GET
+func etagMatches(ifNoneMatch, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+// writeJSONWithETag is writeJSON for a 200 response that carries etag,
+// answering 304 with no body when the client already has it
+func writeJSONWithETag(w http.ResponseWriter, r *http.Request, version *store.TargetsVersion, data interface{}) {
+	var body bytes.Buffer
+	if err := newJSONEncoder(&body).Encode(data); err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to encode response: "+err.Error())
+		return
+	}
+
+	etag := listingETag(version, body.Bytes())
+	w.Header().Set("ETag", etag)
+	if match := r.Header.Get("If-None-Match"); match != "" && etagMatches(match, etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	w.Write(body.Bytes())
+}
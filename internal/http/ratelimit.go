@@ -0,0 +1,112 @@
+package http
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Idle clients are forgotten after limiterIdleTTL, checked at most every
+// limiterSweepInterval as requests come in.
+const (
+	limiterIdleTTL       = 3 * time.Minute
+	limiterSweepInterval = time.Minute
+)
+
+// rateLimiter keeps a token bucket per client.
+type rateLimiter struct {
+	rps   rate.Limit
+	burst int
+
+	mu        sync.Mutex
+	clients   map[string]*limitedClient
+	lastSweep time.Time
+}
+
+type limitedClient struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+func newRateLimiter(rps float64, burst int) *rateLimiter {
+	if burst < 1 {
+		burst = int(math.Max(1, math.Ceil(rps)))
+	}
+	return &rateLimiter{
+		rps:     rate.Limit(rps),
+		burst:   burst,
+		clients: make(map[string]*limitedClient),
+	}
+}
+
+// allow takes a token from key's bucket, or reports how long until one is free.
+func (l *rateLimiter) allow(key string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.lastSweep) >= limiterSweepInterval {
+		l.sweep(now)
+	}
+
+	c, ok := l.clients[key]
+	if !ok {
+		c = &limitedClient{limiter: rate.NewLimiter(l.rps, l.burst)}
+		l.clients[key] = c
+	}
+	c.lastSeen = now
+
+	r := c.limiter.ReserveN(now, 1)
+	if delay := r.DelayFrom(now); delay > 0 {
+		// Don't hold the token; the client is told to come back instead
+		r.CancelAt(now)
+		return false, delay
+	}
+	return true, 0
+}
+
+// sweep drops clients idle for longer than limiterIdleTTL. Their buckets
+// would have refilled by then, so a fresh one is equivalent.
+func (l *rateLimiter) sweep(now time.Time) {
+	for key, c := range l.clients {
+		if now.Sub(c.lastSeen) > limiterIdleTTL {
+			delete(l.clients, key)
+		}
+	}
+	l.lastSweep = now
+}
+
+// rateLimit answers 429 with Retry-After once a client exceeds its rate.
+func (s *Server) rateLimit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ok, delay := s.limiter.allow(clientKey(r), time.Now())
+		if !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(delay.Seconds()))))
+			writeError(w, http.StatusTooManyRequests, "rate limit exceeded")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// clientKey identifies the caller by the first X-Forwarded-For address, as
+// set by a reverse proxy, falling back to the connection's remote IP. The
+// header is client-controlled, so only trust it behind a proxy that sets it.
+func clientKey(r *http.Request) string {
+	if fwd := r.Header.Get("X-Forwarded-For"); fwd != "" {
+		first, _, _ := strings.Cut(fwd, ",")
+		if ip := strings.TrimSpace(first); ip != "" {
+			return ip
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
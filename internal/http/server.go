@@ -3,16 +3,25 @@
 import (
 	"context"
 	"crypto/sha256"
+	"crypto/subtle"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"log/slog"
 	"net/http"
+	"net/url"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
+	"github.com/you/linkwatch/internal/checker"
+	"github.com/you/linkwatch/internal/metrics"
 	"github.com/you/linkwatch/internal/model"
 	"github.com/you/linkwatch/internal/store"
 )
@@ -21,11 +30,137 @@ import (
 type Server struct {
 	store  store.Store
 	router *chi.Mux
+	opts   Options
+
+	limiter *rateLimiter // Per-client request rate on /v1, nil when disabled
+	logger  *slog.Logger
+
+	targetLimitMutex sync.Mutex // Serializes creates counted against MaxTargets
+}
+
+// Options configures a Server. The zero value keeps every limit disabled.
+type Options struct {
+	// MaxResultsWindow bounds how far back the results endpoint may look.
+	// Older since values are clamped to the window, or rejected with 400
+	// when RejectOutsideWindow is set. Zero means unbounded.
+	MaxResultsWindow    time.Duration
+	RejectOutsideWindow bool
+
+	// Broker feeds the live results stream; without it the stream is unavailable.
+	Broker *checker.Broker
+
+	// InFlight lists the targets being checked, for /v1/debug/inflight;
+	// without it that endpoint is unavailable.
+	InFlight func() []string
+
+	// CheckOnce checks and stores a target on demand, for
+	// POST /v1/targets/{targetID}/check; without it that endpoint is unavailable.
+	CheckOnce func(ctx context.Context, target *store.Target) (*store.CheckResult, error)
+
+	// MaxRetention caps per-target retention overrides. Zero means no cap.
+	MaxRetention time.Duration
+
+	// Metrics records request counts and durations and is served on /metrics.
+	// Without it neither happens.
+	Metrics *metrics.Metrics
+
+	// CursorSecret signs page tokens with HMAC-SHA256 so clients can't forge
+	// positions; altered tokens are rejected with 400. AllowUnsignedCursors
+	// still accepts unsigned tokens issued before a secret was configured.
+	// Without a secret, tokens are unsigned.
+	CursorSecret         []byte
+	AllowUnsignedCursors bool
+
+	// IdempotencyTTL is how long a cached create response is replayed for
+	// its Idempotency-Key. Zero means a day.
+	IdempotencyTTL time.Duration
+
+	// APITokens, when non-empty, are the bearer tokens accepted on /v1 routes;
+	// requests without one of them get 401. Empty leaves the API open.
+	APITokens []string
+
+	// RateLimitRPS limits each client to this many /v1 requests per second,
+	// with bursts of up to RateLimitBurst; excess requests get 429. Clients
+	// are told apart by X-Forwarded-For or remote IP. Zero disables it.
+	RateLimitRPS   float64
+	RateLimitBurst int
+
+	// Build is reported at /version. Empty fields read "dev"/"unknown".
+	Build BuildInfo
+
+	// Canonicalize holds opt-in URL rules, used both for new targets and
+	// by /v1/admin/recanonicalize.
+	Canonicalize model.CanonicalizeOptions
+
+	// MaxURLLength caps the length in bytes of a new target's URL as sent,
+	// before canonicalization. Zero means 2048.
+	MaxURLLength int
+
+	// MaxTargets caps how many targets may exist; creating one more gets 403,
+	// while posting an existing URL still returns it. Zero means no cap.
+	MaxTargets int
+
+	// SuccessStatuses are the statuses a latest result shows as up with in
+	// /v1/status, unless its target expects one. Empty means
+	// model.DefaultSuccessStatuses; the store needs the same.
+	SuccessStatuses model.StatusRanges
+
+	// HandlerTimeout bounds each /v1 request except the stream and
+	// recanonicalize; past it the request context is cancelled and the
+	// client gets 503. It should exceed the store's query timeout, so a slow
+	// query fails on its own first. Zero leaves requests unbounded.
+	HandlerTimeout time.Duration
+
+	// CheckDuration is the longest an on-demand check can take, which
+	// POST /v1/targets/{id}/check gets on top of HandlerTimeout.
+	CheckDuration time.Duration
+
+	// Logger receives request and error logs; nil means slog.Default().
+	Logger *slog.Logger
+
+	// MaxRequestBytes caps JSON request bodies; larger ones get 413.
+	// Zero means 64KB.
+	MaxRequestBytes int64
+
+	// BlockPrivateIPs rejects targets whose host resolves to a loopback,
+	// link-local or private address.
+	BlockPrivateIPs bool
+}
+
+// BuildInfo identifies the running binary, normally set via -ldflags.
+type BuildInfo struct {
+	Version   string `json:"version"`
+	GitCommit string `json:"git_commit"`
+	BuildTime string `json:"build_time"`
 }
 
+// defaultIdempotencyTTL applies when Options.IdempotencyTTL is zero
+const defaultIdempotencyTTL = 24 * time.Hour
+
+// defaultMaxURLLength applies when Options.MaxURLLength is zero
+const defaultMaxURLLength = 2048
+
+// defaultMaxRequestBytes applies when Options.MaxRequestBytes is zero
+const defaultMaxRequestBytes = 64 << 10
+
 // NewServer creates HTTP server with routes
-func NewServer(store store.Store) *Server {
-	s := &Server{store: store}
+func NewServer(store store.Store, opts Options) *Server {
+	s := &Server{store: store, opts: opts, logger: opts.Logger}
+	if s.logger == nil {
+		s.logger = slog.Default()
+	}
+	if s.opts.Build.Version == "" {
+		s.opts.Build.Version = "dev"
+	}
+	if s.opts.Build.GitCommit == "" {
+		s.opts.Build.GitCommit = "unknown"
+	}
+	if s.opts.Build.BuildTime == "" {
+		s.opts.Build.BuildTime = "unknown"
+	}
+	if opts.RateLimitRPS > 0 {
+		s.limiter = newRateLimiter(opts.RateLimitRPS, opts.RateLimitBurst)
+	}
 	s.setupRoutes()
 	return s
 }
@@ -35,52 +170,271 @@ func (s *Server) setupRoutes() {
 	s.router = chi.NewRouter()
 
 	s.router.Use(middleware.RequestID)
-	s.router.Use(middleware.Logger)
+	s.router.Use(s.logRequests)
 	s.router.Use(middleware.Recoverer)
+	if s.opts.Metrics != nil {
+		s.router.Use(s.instrument)
+	}
 
 	s.router.Route("/v1", func(r chi.Router) {
+		// Limit before auth so token guessing is throttled too
+		if s.limiter != nil {
+			r.Use(s.rateLimit)
+		}
+		if len(s.opts.APITokens) > 0 {
+			r.Use(s.authenticate)
+		}
+
+		// The stream stays open by design, and recanonicalize rewrites every
+		// target with the store's query timeout on each step, so neither is
+		// bounded as a whole
+		r.Get("/stream", s.streamResults)
+		r.Post("/admin/recanonicalize", s.recanonicalizeTargets)
+
+		// The bound wraps each route's own handler, once chi has routed the
+		// request, rather than the /targets mount
+		bounded := func(r chi.Router, extra time.Duration) {
+			if s.opts.HandlerTimeout > 0 {
+				r.Use(s.timeout(s.opts.HandlerTimeout + extra))
+			}
+		}
+
 		r.Route("/targets", func(r chi.Router) {
-			r.Post("/", s.createTarget)
-			r.Get("/", s.listTargets)
-			r.Get("/{targetID}/results", s.getResults)
+			r.Group(func(r chi.Router) {
+				bounded(r, 0)
+				r.Post("/", s.createTarget)
+				r.Get("/", s.listTargets)
+				r.Get("/{targetID}", s.getTarget)
+				r.Patch("/{targetID}", s.updateTarget)
+				r.Delete("/{targetID}", s.deleteTarget)
+				r.Get("/{targetID}/results", s.getResults)
+				r.Get("/{targetID}/stats", s.getStats)
+				r.Get("/{targetID}/latency", s.getLatency)
+				r.Get("/{targetID}/summary", s.getSummary)
+				r.Get("/{targetID}/state", s.getState)
+				r.Post("/{targetID}/ack", s.acknowledgeFailures)
+			})
+			// Running the check is on top of looking up and saving its target
+			r.Group(func(r chi.Router) {
+				bounded(r, s.opts.CheckDuration)
+				r.Post("/{targetID}/check", s.checkTargetNow)
+			})
+		})
+
+		r.Group(func(r chi.Router) {
+			bounded(r, 0)
+			r.Get("/hosts", s.listHosts)
+			r.Get("/status", s.getStatus)
+			r.Get("/debug/inflight", s.listInFlight)
 		})
 	})
 
 	s.router.Get("/healthz", s.healthCheck)
+	s.router.Get("/readyz", s.readinessCheck)
+	s.router.Get("/version", s.version)
+	if s.opts.Metrics != nil {
+		s.router.Method(http.MethodGet, "/metrics", s.opts.Metrics.Handler())
+	}
+}
+
+// instrument records each request's status and duration by route pattern.
+func (s *Server) instrument(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		next.ServeHTTP(ww, r)
+
+		route := chi.RouteContext(r.Context()).RoutePattern()
+		if route == "" {
+			route = "unmatched"
+		}
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		s.opts.Metrics.ObserveRequest(r.Method, route, status, time.Since(start))
+	})
+}
+
+// logRequests logs each request once it completes.
+func (s *Server) logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+		defer func() {
+			status := ww.Status()
+			if status == 0 {
+				status = http.StatusOK
+			}
+			s.requestLogger(r).Info("request",
+				"method", r.Method,
+				"path", r.URL.Path,
+				"status", status,
+				"latency_ms", time.Since(start).Milliseconds(),
+				"bytes", ww.BytesWritten(),
+				"remote_addr", r.RemoteAddr,
+			)
+		}()
+		next.ServeHTTP(ww, r)
+	})
+}
+
+// requestLogger returns the server's logger tagged with r's request ID.
+func (s *Server) requestLogger(r *http.Request) *slog.Logger {
+	return s.logger.With("request_id", middleware.GetReqID(r.Context()))
+}
+
+// authenticate rejects requests without a valid "Authorization: Bearer" token.
+func (s *Server) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The scheme is case-insensitive (RFC 9110)
+		scheme, token, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+		if !strings.EqualFold(scheme, "Bearer") || !s.validToken(token) {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="linkwatch"`)
+			writeError(w, http.StatusUnauthorized, "missing or invalid bearer token")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// validToken compares against every configured token in constant time, so
+// response timing doesn't reveal how much of a guess was right.
+func (s *Server) validToken(token string) bool {
+	given := sha256.Sum256([]byte(token))
+	valid := 0
+	for _, t := range s.opts.APITokens {
+		want := sha256.Sum256([]byte(t))
+		valid |= subtle.ConstantTimeCompare(given[:], want[:])
+	}
+	return valid == 1
 }
 
 func (s *Server) Router() *chi.Mux {
 	return s.router
 }
 
+// writeJSON encodes data as the response body. The encoder's trailing newline
+// is kept deliberately so every response is line-terminated, and HTML escaping
+// is off so stored URLs containing & or < round-trip byte for byte.
 func writeJSON(w http.ResponseWriter, status int, data interface{}) {
-	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
 	w.WriteHeader(status)
-	json.NewEncoder(w).Encode(data)
+	newJSONEncoder(w).Encode(data)
+}
+
+func newJSONEncoder(w io.Writer) *json.Encoder {
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	return enc
 }
 
 func writeError(w http.ResponseWriter, status int, msg string) {
 	writeJSON(w, status, map[string]string{"error": msg})
 }
 
-// createTarget handles POST /v1/targets
+// fieldErrors maps request body fields, by JSON name, to what's wrong with them
+type fieldErrors map[string]string
+
+// add records err against field, keeping the first problem found with it.
+// A *store.FieldError names its own field instead.
+func (f fieldErrors) add(field string, err error) {
+	var fieldErr *store.FieldError
+	if errors.As(err, &fieldErr) {
+		field = fieldErr.Field
+	}
+	if _, exists := f[field]; !exists {
+		f[field] = err.Error()
+	}
+}
+
+// writeFieldErrors writes a 400 listing each invalid field under "fields",
+// with "error" joining their messages in field order.
+func writeFieldErrors(w http.ResponseWriter, fields fieldErrors) {
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	msgs := make([]string, len(names))
+	for i, name := range names {
+		msgs[i] = fields[name]
+	}
+	writeJSON(w, http.StatusBadRequest, map[string]any{"error": strings.Join(msgs, "; "), "fields": fields})
+}
+
+// decodeJSON reads a single JSON object from the request body into v,
+// rejecting unknown fields and bodies over MaxRequestBytes. On failure it
+// writes the error response and returns false.
+func (s *Server) decodeJSON(w http.ResponseWriter, r *http.Request, v any) bool {
+	return s.decodeBody(w, r, v, false)
+}
+
+// decodeOptionalJSON is decodeJSON for requests whose fields all have
+// defaults: an empty body leaves v as it is.
+func (s *Server) decodeOptionalJSON(w http.ResponseWriter, r *http.Request, v any) bool {
+	return s.decodeBody(w, r, v, true)
+}
+
+func (s *Server) decodeBody(w http.ResponseWriter, r *http.Request, v any, optional bool) bool {
+	limit := s.opts.MaxRequestBytes
+	if limit <= 0 {
+		limit = defaultMaxRequestBytes
+	}
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, limit))
+	dec.DisallowUnknownFields()
+
+	err := dec.Decode(v)
+	if err == nil && dec.Decode(&struct{}{}) != io.EOF {
+		err = errors.New("body must contain a single JSON object")
+	}
+
+	var tooLarge *http.MaxBytesError
+	switch {
+	case err == nil, optional && err == io.EOF:
+		return true
+	case errors.As(err, &tooLarge):
+		writeError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("request body must not exceed %d bytes", limit))
+	case errors.Is(err, io.EOF):
+		writeError(w, http.StatusBadRequest, "request body is empty, expected a JSON object")
+	default:
+		writeError(w, http.StatusBadRequest, "invalid JSON body: "+strings.TrimPrefix(err.Error(), "json: "))
+	}
+	return false
+}
+
+// createTargetRequest is the body of POST /v1/targets
+type createTargetRequest struct {
+	URL string `json:"url"`
+	store.TargetSettings
+}
+
+// createTarget handles POST /v1/targets. Invalid fields are all reported at
+// once, see writeFieldErrors.
 func (s *Server) createTarget(w http.ResponseWriter, r *http.Request) {
-	var req struct {
-		URL string `json:"url"`
+	var req createTargetRequest
+	if !s.decodeJSON(w, r, &req) {
+		return
 	}
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		writeError(w, http.StatusBadRequest, "invalid JSON body")
+
+	if fields := s.validateCreateTarget(&req); len(fields) > 0 {
+		writeFieldErrors(w, fields)
 		return
 	}
 
-	canonicalURL, host, err := model.Canonicalize(req.URL)
+	canonicalURL, host, err := s.CanonicalTargetURL(r.Context(), req.URL)
 	if err != nil {
-		writeError(w, http.StatusBadRequest, "invalid URL: "+err.Error())
+		writeFieldErrors(w, fieldErrors{"url": "invalid URL: " + err.Error()})
 		return
 	}
 	idempotencyKey := r.Header.Get("Idempotency-Key")
+	requestHash := createRequestHash(canonicalURL, req.TargetSettings)
 	if idempotencyKey != "" {
-		if cachedResponse, found, err := s.checkIdempotencyKey(r.Context(), idempotencyKey, req.URL, canonicalURL); err != nil {
+		if cachedResponse, found, err := s.checkIdempotencyKey(r.Context(), idempotencyKey, requestHash); errors.Is(err, errIdempotencyMismatch) {
+			writeError(w, http.StatusUnprocessableEntity, err.Error())
+			return
+		} else if err != nil {
 			writeError(w, http.StatusInternalServerError, "idempotency check failed: "+err.Error())
 			return
 		} else if found {
@@ -88,7 +442,11 @@ func (s *Server) createTarget(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 	}
-	target, created, err := s.store.UpsertTargetByURL(r.Context(), canonicalURL, host)
+	target, created, err := s.upsertTarget(r.Context(), canonicalURL, host, req.TargetSettings)
+	if errors.Is(err, errTargetLimit) {
+		writeError(w, http.StatusForbidden, fmt.Sprintf("target limit reached: at most %d targets may exist", s.opts.MaxTargets))
+		return
+	}
 	if err != nil {
 		writeError(w, http.StatusInternalServerError, "database error: "+err.Error())
 		return
@@ -100,48 +458,488 @@ func (s *Server) createTarget(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if idempotencyKey != "" {
-		if err := s.storeIdempotencyResult(r.Context(), idempotencyKey, req.URL, target.ID, status, target); err != nil {
-			fmt.Printf("Failed to store idempotency result: %v\n", err)
+		if err := s.storeIdempotencyResult(r.Context(), idempotencyKey, requestHash, target.ID, status, target); err != nil {
+			s.requestLogger(r).Error("failed to store idempotency result", "target_id", target.ID, "error", err)
 		}
 	}
 
 	writeJSON(w, status, target)
 }
 
-// listTargets handles GET /v1/targets
+// errTargetLimit rolls back a create that would exceed Options.MaxTargets
+var errTargetLimit = errors.New("target limit reached")
+
+// upsertTarget returns the target for canonicalURL, creating it if needed.
+// Under MaxTargets the count and the insert share a transaction, and creates
+// on this node take turns, so concurrent requests can't overshoot the cap;
+// a new target over it is rolled back with errTargetLimit.
+func (s *Server) upsertTarget(ctx context.Context, canonicalURL, host string, settings store.TargetSettings) (*store.Target, bool, error) {
+	if s.opts.MaxTargets <= 0 {
+		return s.store.UpsertTargetByURL(ctx, canonicalURL, host, settings)
+	}
+
+	s.targetLimitMutex.Lock()
+	defer s.targetLimitMutex.Unlock()
+
+	var target *store.Target
+	var created bool
+	err := s.store.WithTx(ctx, func(tx store.Store) error {
+		count, err := tx.CountTargets(ctx, store.HostFilter{})
+		if err != nil {
+			return fmt.Errorf("count targets: %w", err)
+		}
+		if target, created, err = tx.UpsertTargetByURL(ctx, canonicalURL, host, settings); err != nil {
+			return err
+		}
+		if created && count >= s.opts.MaxTargets {
+			return errTargetLimit
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	return target, created, nil
+}
+
+// validateCreateTarget checks a create request's URL, which is required, and
+// settings, returning what's wrong with each invalid field
+func (s *Server) validateCreateTarget(req *createTargetRequest) fieldErrors {
+	fields := s.validateSettings(&req.TargetSettings)
+
+	switch {
+	case strings.TrimSpace(req.URL) == "":
+		fields.add("url", errors.New("url is required"))
+	case len(req.URL) > s.maxURLLength():
+		fields.add("url", fmt.Errorf("url must not exceed %d bytes", s.maxURLLength()))
+	}
+	return fields
+}
+
+// maxURLLength is Options.MaxURLLength, or its default when that is zero
+func (s *Server) maxURLLength() int {
+	if s.opts.MaxURLLength <= 0 {
+		return defaultMaxURLLength
+	}
+	return s.opts.MaxURLLength
+}
+
+// CanonicalTargetURL checks and canonicalizes a target URL as creating it
+// through the API does: its length, its form, and with BlockPrivateIPs the
+// addresses its host resolves to. Startup seeding uses it too, so that
+// targets added from a file obey the same limits.
+func (s *Server) CanonicalTargetURL(ctx context.Context, raw string) (canonicalURL, host string, err error) {
+	if len(raw) > s.maxURLLength() {
+		return "", "", fmt.Errorf("url must not exceed %d bytes", s.maxURLLength())
+	}
+	if canonicalURL, host, err = s.opts.Canonicalize.Canonicalize(raw); err != nil {
+		return "", "", err
+	}
+	if s.opts.BlockPrivateIPs {
+		if err := s.checkPublicURL(ctx, canonicalURL); err != nil {
+			return "", "", err
+		}
+	}
+	return canonicalURL, host, nil
+}
+
+// validateSettings checks per-target settings from a request body, filling
+// in the default match_mode, and returns what's wrong with each invalid one
+func (s *Server) validateSettings(settings *store.TargetSettings) fieldErrors {
+	fields := fieldErrors{}
+
+	if settings.Retention != nil {
+		retention := time.Duration(*settings.Retention)
+		if retention < time.Second {
+			// Stored as whole seconds, where 0 would purge every result
+			fields.add("retention", errors.New("retention must be at least 1s"))
+		} else if s.opts.MaxRetention > 0 && retention > s.opts.MaxRetention {
+			fields.add("retention", fmt.Errorf("retention must not exceed %s", s.opts.MaxRetention))
+		}
+	}
+
+	if settings.Schedule != nil {
+		if err := settings.Schedule.Validate(); err != nil {
+			fields.add("schedule", err)
+		}
+	}
+
+	if settings.Maintenance != nil {
+		if err := settings.Maintenance.Validate(); err != nil {
+			fields.add("maintenance", fmt.Errorf("maintenance: %w", err))
+		}
+	}
+
+	if err := settings.Headers.Validate(); err != nil {
+		fields.add("headers", err)
+	}
+
+	if settings.ExpectedStatus != nil && (*settings.ExpectedStatus < 100 || *settings.ExpectedStatus > 599) {
+		fields.add("expected_status", errors.New("expected_status must be between 100 and 599"))
+	}
+
+	if settings.FailureThreshold != nil && *settings.FailureThreshold < 1 {
+		fields.add("failure_threshold", errors.New("failure_threshold must be at least 1"))
+	}
+
+	if settings.Proxy != nil {
+		if _, err := model.ParseProxyURL(*settings.Proxy); err != nil {
+			fields.add("proxy", fmt.Errorf("invalid proxy: %w", err))
+		}
+	}
+
+	if err := settings.ValidateRequest(); err != nil {
+		fields.add("method", err)
+	}
+
+	if err := settings.ValidateMatch(); err != nil {
+		fields.add("match_pattern", err)
+	}
+	return fields
+}
+
+// updateTarget handles PATCH /v1/targets/{targetID}. Only the settings
+// present in the body change, and null clears one; the URL is immutable.
+// "enabled" pauses or resumes checking the target.
+func (s *Server) updateTarget(w http.ResponseWriter, r *http.Request) {
+	targetID := chi.URLParam(r, "targetID")
+
+	var fields map[string]json.RawMessage
+	if !s.decodeJSON(w, r, &fields) {
+		return
+	}
+
+	var enabled *bool
+	if raw, ok := fields["enabled"]; ok {
+		if err := json.Unmarshal(raw, &enabled); err != nil || enabled == nil {
+			writeError(w, http.StatusBadRequest, "enabled must be true or false")
+			return
+		}
+		delete(fields, "enabled")
+	}
+
+	named := make([]string, 0, len(fields))
+	for name := range fields {
+		if name == "url" {
+			writeError(w, http.StatusBadRequest, "url cannot be changed; create a new target instead")
+			return
+		}
+		if !store.IsTargetSetting(name) {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid JSON body: unknown field %q", name))
+			return
+		}
+		named = append(named, name)
+	}
+
+	// Decoded into an empty struct, so settings absent from the body stay zero
+	var patch store.TargetSettings
+	body, err := json.Marshal(fields)
+	if err == nil {
+		err = json.Unmarshal(body, &patch)
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body: "+err.Error())
+		return
+	}
+
+	existing, err := s.store.GetTargetByID(r.Context(), targetID)
+	if errors.Is(err, store.ErrTargetNotFound) {
+		writeError(w, http.StatusNotFound, "target not found")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to fetch target: "+err.Error())
+		return
+	}
+
+	// The match pattern and mode are validated, and written, as a pair
+	_, patternNamed := fields["match_pattern"]
+	_, modeNamed := fields["match_mode"]
+	if patternNamed || modeNamed {
+		if !patternNamed {
+			patch.MatchPattern = existing.MatchPattern
+		}
+		if !modeNamed && patch.MatchPattern != nil {
+			patch.MatchMode = existing.MatchMode
+		}
+		if !patternNamed {
+			named = append(named, "match_pattern")
+		}
+		if !modeNamed {
+			named = append(named, "match_mode")
+		}
+	}
+
+	// So are the method and request body
+	_, methodNamed := fields["method"]
+	_, bodyNamed := fields["request_body"]
+	if methodNamed && !bodyNamed {
+		patch.RequestBody = existing.RequestBody
+		named = append(named, "request_body")
+	}
+	if bodyNamed && !methodNamed {
+		patch.Method = existing.Method
+		named = append(named, "method")
+	}
+
+	if fields := s.validateSettings(&patch); len(fields) > 0 {
+		writeFieldErrors(w, fields)
+		return
+	}
+
+	target, err := s.store.UpdateTarget(r.Context(), targetID, store.TargetUpdate{Settings: patch, Fields: named, Enabled: enabled})
+	if errors.Is(err, store.ErrTargetNotFound) {
+		writeError(w, http.StatusNotFound, "target not found")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to update target: "+err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, target)
+}
+
+// listTargets handles GET /v1/targets, honoring If-None-Match against the
+// listing's ETag. The total_count across pages costs an extra query, so it
+// is only included with include_total=true.
 func (s *Server) listTargets(w http.ResponseWriter, r *http.Request) {
-	host := r.URL.Query().Get("host")
-	limitParam := r.URL.Query().Get("limit")
-	pageToken := r.URL.Query().Get("page_token")
+	hosts := parseHostFilter(r)
 
-	limit := 20
-	if limitParam != "" {
-		if parsed, err := parseInt(limitParam, 1, 100); err == nil {
-			limit = parsed
+	limit, order, after, err := s.parseTargetPage(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	includeTotal := false
+	if param := r.URL.Query().Get("include_total"); param != "" {
+		if includeTotal, err = strconv.ParseBool(param); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid include_total: must be true or false")
+			return
 		}
 	}
 
-	afterTime, afterID := parseCursorToken(pageToken)
+	targets, cursor, err := s.store.GetTargets(r.Context(), hosts, order, after, limit)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to fetch targets: "+err.Error())
+		return
+	}
 
-	targets, cursor, err := s.store.GetTargets(r.Context(), host, afterTime, afterID, limit)
+	version, err := s.store.GetTargetsVersion(r.Context(), hosts)
 	if err != nil {
 		writeError(w, http.StatusInternalServerError, "failed to fetch targets: "+err.Error())
 		return
 	}
 
+	token := ""
+	if cursor != nil {
+		token = s.buildCursorToken(targetCursor(cursor))
+	}
 	response := map[string]interface{}{
-		"items": targets,
+		"items":           targets,
+		"next_page_token": token,
+		"page":            newPageInfo(token, limit),
+	}
+
+	if includeTotal {
+		total, err := s.store.CountTargets(r.Context(), hosts)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "failed to count targets: "+err.Error())
+			return
+		}
+		response["total_count"] = total
+	}
+
+	writeJSONWithETag(w, r, version, response)
+}
+
+// pageInfo is the "page" object of list responses. The top-level
+// next_page_token predates it and is kept for existing clients.
+type pageInfo struct {
+	NextPageToken string `json:"next_page_token"`
+	Limit         int    `json:"limit"`    // The page size applied, after defaults and clamping
+	HasMore       bool   `json:"has_more"` // Whether next_page_token leads anywhere
+}
+
+func newPageInfo(token string, limit int) pageInfo {
+	return pageInfo{NextPageToken: token, Limit: limit, HasMore: token != ""}
+}
+
+// listHosts handles GET /v1/hosts, the hosts usable as a host= filter
+func (s *Server) listHosts(w http.ResponseWriter, r *http.Request) {
+	hosts, err := s.store.GetDistinctHosts(r.Context())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to fetch hosts: "+err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"hosts": hosts})
+}
+
+// targetStatus is one row of the status overview
+type targetStatus struct {
+	ID         string     `json:"id"`
+	URL        string     `json:"url"`
+	Host       string     `json:"host"`
+	State      string     `json:"state"` // "up", "down", or "unknown" before the first check
+	CheckedAt  *time.Time `json:"checked_at"`
+	StatusCode *int       `json:"status_code"`
+	LatencyMs  *int       `json:"latency_ms"`
+	Error      *string    `json:"error"`
+}
+
+// getStatus handles GET /v1/status, paging through targets like listTargets
+// but pairing each with its latest result
+func (s *Server) getStatus(w http.ResponseWriter, r *http.Request) {
+	hosts := parseHostFilter(r)
+
+	limit, order, after, err := s.parseTargetPage(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	targets, cursor, err := s.store.GetTargets(r.Context(), hosts, order, after, limit)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to fetch targets: "+err.Error())
+		return
 	}
 
+	ids := make([]string, len(targets))
+	for i, t := range targets {
+		ids[i] = t.ID
+	}
+	latest, err := s.store.GetLatestResults(r.Context(), ids)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to fetch results: "+err.Error())
+		return
+	}
+
+	items := make([]targetStatus, 0, len(targets))
+	for _, t := range targets {
+		item := targetStatus{ID: t.ID, URL: t.URL, Host: t.Host, State: store.StateUnknown}
+		if res, ok := latest[t.ID]; ok {
+			item.State = res.State(t.ExpectedStatus, s.opts.SuccessStatuses)
+			item.CheckedAt = &res.CheckedAt
+			item.StatusCode = res.StatusCode
+			item.LatencyMs = &res.LatencyMs
+			item.Error = res.Error
+		}
+		items = append(items, item)
+	}
+
+	token := ""
 	if cursor != nil {
-		response["next_page_token"] = buildCursorToken(cursor.CreatedAt, cursor.ID)
-	} else {
-		response["next_page_token"] = ""
+		token = s.buildCursorToken(targetCursor(cursor))
+	}
+	response := map[string]interface{}{
+		"items":           items,
+		"next_page_token": token,
+		"page":            newPageInfo(token, limit),
 	}
 
 	writeJSON(w, http.StatusOK, response)
 }
 
+// getTarget handles GET /v1/targets/{targetID}
+func (s *Server) getTarget(w http.ResponseWriter, r *http.Request) {
+	targetID := chi.URLParam(r, "targetID")
+
+	target, err := s.store.GetTargetByID(r.Context(), targetID)
+	if errors.Is(err, store.ErrTargetNotFound) {
+		writeError(w, http.StatusNotFound, "target not found")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to fetch target: "+err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, target)
+}
+
+// getState handles GET /v1/targets/{targetID}/state. A target that hasn't
+// been checked yet is "unknown", with null timestamps.
+func (s *Server) getState(w http.ResponseWriter, r *http.Request) {
+	targetID := chi.URLParam(r, "targetID")
+
+	state, err := s.store.GetState(r.Context(), targetID)
+	if errors.Is(err, store.ErrNoState) {
+		if _, err := s.store.GetTargetByID(r.Context(), targetID); errors.Is(err, store.ErrTargetNotFound) {
+			writeError(w, http.StatusNotFound, "target not found")
+			return
+		} else if err != nil {
+			writeError(w, http.StatusInternalServerError, "failed to fetch target: "+err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"target_id":    targetID,
+			"state":        store.StateUnknown,
+			"since":        nil,
+			"last_checked": nil,
+
+			"consecutive_failures": 0,
+		})
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to fetch state: "+err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, state)
+}
+
+// checkTargetNow handles POST /v1/targets/{targetID}/check, checking the
+// target straight away and returning the stored result. Paused targets can
+// be checked too.
+func (s *Server) checkTargetNow(w http.ResponseWriter, r *http.Request) {
+	if s.opts.CheckOnce == nil {
+		writeError(w, http.StatusServiceUnavailable, "on-demand checks are not enabled")
+		return
+	}
+
+	target, err := s.store.GetTargetByID(r.Context(), chi.URLParam(r, "targetID"))
+	if errors.Is(err, store.ErrTargetNotFound) {
+		writeError(w, http.StatusNotFound, "target not found")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to fetch target: "+err.Error())
+		return
+	}
+
+	result, err := s.opts.CheckOnce(r.Context(), target)
+	if result == nil {
+		writeError(w, http.StatusServiceUnavailable, "check did not complete: "+err.Error())
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to save result: "+err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, result)
+}
+
+// deleteTarget handles DELETE /v1/targets/{targetID}
+func (s *Server) deleteTarget(w http.ResponseWriter, r *http.Request) {
+	targetID := chi.URLParam(r, "targetID")
+
+	err := s.store.DeleteTarget(r.Context(), targetID)
+	if errors.Is(err, store.ErrTargetNotFound) {
+		writeError(w, http.StatusNotFound, "target not found")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to delete target: "+err.Error())
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
 // getResults handles GET /v1/targets/{targetID}/results
 func (s *Server) getResults(w http.ResponseWriter, r *http.Request) {
 	targetID := chi.URLParam(r, "targetID")
@@ -150,16 +948,25 @@ func (s *Server) getResults(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	sinceParam := r.URL.Query().Get("since")
 	limitParam := r.URL.Query().Get("limit")
+	nodeID := r.URL.Query().Get("node_id")
 
-	var since time.Time
-	if sinceParam != "" {
-		if parsed, err := time.Parse(time.RFC3339, sinceParam); err == nil {
-			since = parsed
-		} else {
-			writeError(w, http.StatusBadRequest, "invalid since timestamp format, use RFC3339")
-			return
+	since, err := parseSince(r, time.Time{})
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if s.opts.MaxResultsWindow > 0 {
+		oldest := time.Now().Add(-s.opts.MaxResultsWindow)
+		if since.Before(oldest) {
+			// A missing since means "as far back as allowed", never an error
+			if s.opts.RejectOutsideWindow && !since.IsZero() {
+				writeError(w, http.StatusBadRequest,
+					fmt.Sprintf("since is older than the maximum results window of %s", s.opts.MaxResultsWindow))
+				return
+			}
+			since = oldest
 		}
 	}
 
@@ -170,23 +977,375 @@ func (s *Server) getResults(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	results, err := s.store.GetResults(r.Context(), targetID, since, limit)
+	after, err := s.parseResultCursor(r.URL.Query().Get("page_token"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid page_token: "+err.Error())
+		return
+	}
+
+	results, cursor, err := s.store.GetResults(r.Context(), targetID, since, nodeID, after, limit)
 	if err != nil {
 		writeError(w, http.StatusInternalServerError, "failed to fetch results: "+err.Error())
 		return
 	}
 
+	token := ""
+	if cursor != nil {
+		token = s.buildCursorToken(&model.Cursor{CreatedAt: cursor.CheckedAt, ID: strconv.FormatInt(cursor.ID, 10)})
+	}
+	response := map[string]interface{}{
+		"items":           results,
+		"next_page_token": token,
+		"page":            newPageInfo(token, limit),
+	}
+	if !since.IsZero() {
+		response["since"] = since.Format(time.RFC3339)
+	}
+
+	writeJSON(w, http.StatusOK, response)
+}
+
+// getStats handles GET /v1/targets/{targetID}/stats
+func (s *Server) getStats(w http.ResponseWriter, r *http.Request) {
+	targetID := chi.URLParam(r, "targetID")
+	if targetID == "" {
+		writeError(w, http.StatusBadRequest, "target ID is required")
+		return
+	}
+
+	since, err := parseSince(r, time.Now().Add(-defaultStatsWindow))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if _, err := s.store.GetTargetByID(r.Context(), targetID); errors.Is(err, store.ErrTargetNotFound) {
+		writeError(w, http.StatusNotFound, "target not found")
+		return
+	} else if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to fetch target: "+err.Error())
+		return
+	}
+	latency, err := s.store.GetLatencyPercentiles(r.Context(), targetID, since)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to compute stats: "+err.Error())
+		return
+	}
+
+	failures, err := s.store.CountFailures(r.Context(), targetID, since)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to compute stats: "+err.Error())
+		return
+	}
+
 	response := map[string]interface{}{
-		"items": results,
+		"target_id": targetID,
+		"since":     since.Format(time.RFC3339),
+		"latency":   latency,
+		"failures":  failures,
 	}
 
 	writeJSON(w, http.StatusOK, response)
 }
 
+// getLatency handles GET /v1/targets/{targetID}/latency, the spread of
+// response times over the last window (default defaultLatencyWindow)
+func (s *Server) getLatency(w http.ResponseWriter, r *http.Request) {
+	targetID := chi.URLParam(r, "targetID")
+	if targetID == "" {
+		writeError(w, http.StatusBadRequest, "target ID is required")
+		return
+	}
+
+	window := defaultLatencyWindow
+	if param := r.URL.Query().Get("window"); param != "" {
+		parsed, err := time.ParseDuration(param)
+		if err != nil || parsed <= 0 {
+			writeError(w, http.StatusBadRequest, "invalid window: must be a positive duration like 1h")
+			return
+		}
+		window = parsed
+	}
+	since := time.Now().Add(-window)
+
+	if _, err := s.store.GetTargetByID(r.Context(), targetID); errors.Is(err, store.ErrTargetNotFound) {
+		writeError(w, http.StatusNotFound, "target not found")
+		return
+	} else if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to fetch target: "+err.Error())
+		return
+	}
+	latency, err := s.store.GetLatencyPercentiles(r.Context(), targetID, since)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to compute latency: "+err.Error())
+		return
+	}
+
+	response := map[string]interface{}{
+		"target_id": targetID,
+		"window":    window.String(),
+		"since":     since.Format(time.RFC3339),
+		"latency":   latency,
+	}
+
+	writeJSON(w, http.StatusOK, response)
+}
+
+// getSummary handles GET /v1/targets/{targetID}/summary
+func (s *Server) getSummary(w http.ResponseWriter, r *http.Request) {
+	targetID := chi.URLParam(r, "targetID")
+	if targetID == "" {
+		writeError(w, http.StatusBadRequest, "target ID is required")
+		return
+	}
+
+	since, err := parseSince(r, time.Now().Add(-defaultStatsWindow))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if _, err := s.store.GetTargetByID(r.Context(), targetID); errors.Is(err, store.ErrTargetNotFound) {
+		writeError(w, http.StatusNotFound, "target not found")
+		return
+	} else if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to fetch target: "+err.Error())
+		return
+	}
+	summary, err := s.store.GetSummary(r.Context(), targetID, since)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to compute summary: "+err.Error())
+		return
+	}
+
+	response := map[string]interface{}{
+		"target_id": targetID,
+		"since":     since.Format(time.RFC3339),
+		"summary":   summary,
+	}
+
+	writeJSON(w, http.StatusOK, response)
+}
+
+// acknowledgeFailures handles POST /v1/targets/{targetID}/ack
+func (s *Server) acknowledgeFailures(w http.ResponseWriter, r *http.Request) {
+	targetID := chi.URLParam(r, "targetID")
+	if targetID == "" {
+		writeError(w, http.StatusBadRequest, "target ID is required")
+		return
+	}
+
+	var req struct {
+		From  *time.Time `json:"from"`
+		Until *time.Time `json:"until"`
+		Note  string     `json:"note"`
+	}
+	if !s.decodeOptionalJSON(w, r, &req) {
+		return
+	}
+
+	// Without a range, acknowledge every outstanding failure up to now
+	var from time.Time
+	until := time.Now()
+	if req.From != nil {
+		from = *req.From
+	}
+	if req.Until != nil {
+		until = *req.Until
+	}
+	if until.Before(from) {
+		writeError(w, http.StatusBadRequest, "until must not be before from")
+		return
+	}
+
+	count, err := s.store.AcknowledgeFailures(r.Context(), targetID, from, until, req.Note)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "failed to acknowledge: "+err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"acknowledged": count})
+}
+
+// streamKeepAlive is how often an idle stream sends a comment so proxies keep it open.
+const streamKeepAlive = 15 * time.Second
+
+// streamReplayBatch is how many missed results a reconnecting stream reads
+// from the store at a time.
+const streamReplayBatch = 500
+
+// streamResults handles GET /v1/stream as server-sent events. Each event's
+// id is the result's ID, so a client reconnecting with Last-Event-ID is
+// first sent the results it missed, then live ones.
+func (s *Server) streamResults(w http.ResponseWriter, r *http.Request) {
+	if s.opts.Broker == nil {
+		writeError(w, http.StatusServiceUnavailable, "result streaming is not enabled")
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, "streaming not supported")
+		return
+	}
+
+	var lastID int64
+	resume := r.Header.Get("Last-Event-ID")
+	if resume != "" {
+		var err error
+		if lastID, err = strconv.ParseInt(resume, 10, 64); err != nil || lastID < 0 {
+			writeError(w, http.StatusBadRequest, "invalid Last-Event-ID: must be a result ID")
+			return
+		}
+	}
+
+	// Subscribe before sending headers so nothing published after the
+	// client sees the response is missed
+	filter := checker.Filter{
+		TargetID: r.URL.Query().Get("target_id"),
+		Host:     r.URL.Query().Get("host"),
+	}
+	sub := s.opts.Broker.Subscribe(filter)
+	defer sub.Close()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	send := func(result *store.CheckResult) {
+		// Encode terminates the data line; the blank line ends the event
+		fmt.Fprintf(w, "id: %d\nevent: result\ndata: ", result.ID)
+		newJSONEncoder(w).Encode(result)
+		fmt.Fprint(w, "\n")
+		flusher.Flush()
+	}
+
+	// Live results stored meanwhile queue up in the subscription; the ones
+	// already replayed are skipped below. Without Last-Event-ID nothing is.
+	if resume != "" {
+		for {
+			missed, err := s.store.GetResultsAfterID(r.Context(), lastID, filter.TargetID, filter.Host, streamReplayBatch)
+			if err != nil {
+				// Closing the stream makes the client reconnect and retry
+				s.requestLogger(r).Error("failed to replay missed results", "last_event_id", lastID, "error", err)
+				return
+			}
+			for _, result := range missed {
+				send(result)
+				lastID = result.ID
+			}
+			if len(missed) < streamReplayBatch {
+				break
+			}
+		}
+	}
+
+	keepAlive := time.NewTicker(streamKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+			flusher.Flush()
+		case result, ok := <-sub.Events():
+			if !ok {
+				return
+			}
+			if result.ID <= lastID {
+				continue
+			}
+			send(result)
+		}
+	}
+}
+
+// recanonicalizeTargets handles POST /v1/admin/recanonicalize
+func (s *Server) recanonicalizeTargets(w http.ResponseWriter, r *http.Request) {
+	report, err := s.store.RecanonicalizeTargets(r.Context(), s.opts.Canonicalize.Canonicalize)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "recanonicalize failed: "+err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, report)
+}
+
+// listInFlight handles GET /v1/debug/inflight
+func (s *Server) listInFlight(w http.ResponseWriter, r *http.Request) {
+	if s.opts.InFlight == nil {
+		writeError(w, http.StatusServiceUnavailable, "in-flight tracking is not enabled")
+		return
+	}
+
+	ids := s.opts.InFlight()
+	writeJSON(w, http.StatusOK, map[string]any{"target_ids": ids, "count": len(ids)})
+}
+
 func (s *Server) healthCheck(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
 }
 
+func (s *Server) version(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.opts.Build)
+}
+
+// resolveTimeout bounds the DNS lookup guarding new targets.
+const resolveTimeout = 2 * time.Second
+
+// checkPublicURL fails if the URL's host resolves to a private address.
+func (s *Server) checkPublicURL(ctx context.Context, canonicalURL string) error {
+	parsed, err := url.Parse(canonicalURL)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, resolveTimeout)
+	defer cancel()
+	return model.CheckPublicHost(ctx, parsed.Hostname())
+}
+
+// readinessTimeout bounds the database ping so /readyz answers promptly
+// even when the database hangs.
+const readinessTimeout = 2 * time.Second
+
+// readinessCheck reports 503 while the database is unreachable, unlike
+// healthCheck which only shows the process is alive.
+func (s *Server) readinessCheck(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+	defer cancel()
+
+	if err := s.store.Ping(ctx); err != nil {
+		s.requestLogger(r).Warn("readiness check failed", "error", err)
+		writeError(w, http.StatusServiceUnavailable, "database unavailable")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// defaultStatsWindow is how far back stats look when no since is given.
+const defaultStatsWindow = 24 * time.Hour
+
+// defaultLatencyWindow is how far back the latency endpoint looks when no
+// window is given.
+const defaultLatencyWindow = time.Hour
+
+// parseSince reads the RFC3339 since query param, returning fallback when absent.
+func parseSince(r *http.Request, fallback time.Time) (time.Time, error) {
+	sinceParam := r.URL.Query().Get("since")
+	if sinceParam == "" {
+		return fallback, nil
+	}
+	since, err := time.Parse(time.RFC3339, sinceParam)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid since timestamp format, use RFC3339")
+	}
+	return since, nil
+}
+
 func parseInt(s string, min, max int) (int, error) {
 	val, err := strconv.Atoi(s)
 	if err != nil {
@@ -198,45 +1357,163 @@ func parseInt(s string, min, max int) (int, error) {
 	return val, nil
 }
 
-func parseCursorToken(token string) (time.Time, string) {
+// parseHostFilter reads the host=, host_prefix= and host_like= filters of a
+// target listing. Stored hosts are lowercase, and SQLite's LIKE ignores case
+// where Postgres's doesn't, so the patterns are lowercased to match alike.
+func parseHostFilter(r *http.Request) store.HostFilter {
+	q := r.URL.Query()
+	return store.HostFilter{
+		Host:    q.Get("host"),
+		Prefix:  strings.ToLower(q.Get("host_prefix")),
+		Pattern: strings.ToLower(q.Get("host_like")),
+	}
+}
+
+// parseTargetPage reads the limit, sort and page_token shared by the target
+// listings. An unknown sort is an error; the default is oldest first.
+func (s *Server) parseTargetPage(r *http.Request) (int, store.TargetOrder, *store.Cursor, error) {
+	limit := 20
+	if limitParam := r.URL.Query().Get("limit"); limitParam != "" {
+		if parsed, err := parseInt(limitParam, 1, 100); err == nil {
+			limit = parsed
+		}
+	}
+
+	order := store.OrderCreatedAt
+	if sortParam := r.URL.Query().Get("sort"); sortParam != "" {
+		order = store.TargetOrder(sortParam)
+		if !order.Valid() {
+			return 0, "", nil, fmt.Errorf("invalid sort: must be created_at, -created_at, host or next_check_at")
+		}
+	}
+
+	cursor, err := s.parseCursorToken(r.URL.Query().Get("page_token"))
+	if err != nil {
+		return 0, "", nil, fmt.Errorf("invalid page_token: %w", err)
+	}
+	if cursor == nil {
+		return limit, order, nil, nil
+	}
+	return limit, order, &store.Cursor{CreatedAt: cursor.CreatedAt, ID: cursor.ID, Host: cursor.Host, NextCheckAt: cursor.NextCheckAt}, nil
+}
+
+// targetCursor is the page position of a target listing
+func targetCursor(c *store.Cursor) *model.Cursor {
+	return &model.Cursor{CreatedAt: c.CreatedAt, ID: c.ID, Host: c.Host, NextCheckAt: c.NextCheckAt}
+}
+
+// parseCursorToken decodes a page token, returning nil for the first page.
+// Oversized tokens, and signed ones that fail verification, are rejected;
+// other malformed unsigned tokens restart from the first page.
+func (s *Server) parseCursorToken(token string) (*model.Cursor, error) {
 	if token == "" {
-		return time.Time{}, ""
+		return nil, nil
+	}
+
+	if len(token) > model.MaxCursorLength {
+		return nil, model.ErrCursorTooLong
+	}
+
+	// Base64 never contains '.', so only signed tokens have one
+	if len(s.opts.CursorSecret) > 0 && (strings.Contains(token, ".") || !s.opts.AllowUnsignedCursors) {
+		return model.DecodeCursorSigned(token, s.opts.CursorSecret)
 	}
 
 	decoded, err := base64.URLEncoding.DecodeString(token)
 	if err != nil {
-		return time.Time{}, ""
+		return nil, nil
 	}
 
+	// Target listings append the host, and then the next check when sorted
+	// by it; tokens from before that have neither
 	parts := strings.Split(string(decoded), "|")
-	if len(parts) != 2 {
-		return time.Time{}, ""
+	if len(parts) < 2 || len(parts) > 4 {
+		return nil, nil
 	}
 
 	createdAt, err := time.Parse(time.RFC3339, parts[0])
 	if err != nil {
-		return time.Time{}, ""
+		return nil, nil
 	}
 
-	return createdAt, parts[1]
+	cursor := &model.Cursor{CreatedAt: createdAt, ID: parts[1]}
+	if len(parts) >= 3 {
+		cursor.Host = parts[2]
+	}
+	if len(parts) == 4 {
+		next, err := time.Parse(time.RFC3339, parts[3])
+		if err != nil {
+			return nil, nil
+		}
+		cursor.NextCheckAt = &next
+	}
+	return cursor, nil
+}
+
+// parseResultCursor decodes a results page token, which carries a numeric
+// result ID. Like parseCursorToken, malformed tokens restart from the first page.
+func (s *Server) parseResultCursor(token string) (*store.ResultCursor, error) {
+	cursor, err := s.parseCursorToken(token)
+	if err != nil || cursor == nil || cursor.CreatedAt.IsZero() {
+		return nil, err
+	}
+
+	id, err := strconv.ParseInt(cursor.ID, 10, 64)
+	if err != nil {
+		return nil, nil
+	}
+	return &store.ResultCursor{CheckedAt: cursor.CreatedAt, ID: id}, nil
 }
 
-func buildCursorToken(createdAt time.Time, id string) string {
-	token := fmt.Sprintf("%s|%s", createdAt.Format(time.RFC3339), id)
+// buildCursorToken encodes a page position. Results have no host or next
+// check, which unsigned tokens then leave out.
+func (s *Server) buildCursorToken(cursor *model.Cursor) string {
+	if len(s.opts.CursorSecret) > 0 {
+		signed := *cursor
+		signed.CreatedAt = cursor.CreatedAt.Truncate(time.Second)
+		// Marshalling a Cursor can't fail
+		token, _ := model.EncodeCursorSigned(&signed, s.opts.CursorSecret)
+		return token
+	}
+
+	token := fmt.Sprintf("%s|%s", cursor.CreatedAt.Format(time.RFC3339), cursor.ID)
+	if cursor.Host != "" || cursor.NextCheckAt != nil {
+		token += "|" + cursor.Host
+	}
+	if cursor.NextCheckAt != nil {
+		token += "|" + cursor.NextCheckAt.UTC().Format(time.RFC3339)
+	}
 	return base64.URLEncoding.EncodeToString([]byte(token))
 }
 
-func (s *Server) checkIdempotencyKey(ctx context.Context, key, requestURL, targetURL string) (*store.IdempotencyResponse, bool, error) {
-	return s.store.GetIdempotencyKey(ctx, key)
+// errIdempotencyMismatch means an Idempotency-Key was reused for a different request
+var errIdempotencyMismatch = errors.New("Idempotency-Key was already used with a different request")
+
+func (s *Server) checkIdempotencyKey(ctx context.Context, key, requestHash string) (*store.IdempotencyResponse, bool, error) {
+	cached, found, err := s.store.GetIdempotencyKey(ctx, key)
+	if err != nil || !found {
+		return nil, false, err
+	}
+	if cached.RequestHash != requestHash {
+		return nil, false, errIdempotencyMismatch
+	}
+	return cached, true, nil
 }
 
-func (s *Server) storeIdempotencyResult(ctx context.Context, key, requestURL, targetID string, responseCode int, responseBody interface{}) error {
-	requestHash := createRequestHash(requestURL)
-	_, _, err := s.store.UpsertIdempotencyKey(ctx, key, requestHash, targetID, responseCode, responseBody)
+func (s *Server) storeIdempotencyResult(ctx context.Context, key, requestHash, targetID string, responseCode int, responseBody interface{}) error {
+	ttl := s.opts.IdempotencyTTL
+	if ttl <= 0 {
+		ttl = defaultIdempotencyTTL
+	}
+	_, _, err := s.store.UpsertIdempotencyKey(ctx, key, requestHash, targetID, responseCode, responseBody, time.Now().Add(ttl))
 	return err
 }
 
-func createRequestHash(requestURL string) string {
-	hash := sha256.Sum256([]byte(requestURL))
+// createRequestHash identifies a create request by its canonical URL and
+// validated settings, so a key reused with either changed is a mismatch
+func createRequestHash(canonicalURL string, settings store.TargetSettings) string {
+	// Settings always marshal, and map keys are sorted
+	body, _ := json.Marshal(createTargetRequest{URL: canonicalURL, TargetSettings: settings})
+	hash := sha256.Sum256(body)
 	return fmt.Sprintf("%x", hash)
 }
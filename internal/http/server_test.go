@@ -1,15 +1,35 @@
 package http
 
 import (
+	"bufio"
 	"bytes"
 	"context"
+	"database/sql"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/you/linkwatch/internal/checker"
+	"github.com/you/linkwatch/internal/metrics"
+	"github.com/you/linkwatch/internal/model"
 	"github.com/you/linkwatch/internal/store"
+
+	_ "modernc.org/sqlite"
 )
 
 // MockStore implements the Store interface for testing
@@ -17,6 +37,16 @@ type MockStore struct {
 	targets         map[string]*store.Target
 	idempotencyKeys map[string]*store.IdempotencyResponse
 	results         map[string][]*store.CheckResult
+	leases          map[string]string
+
+	idempotencyExpiry map[string]time.Time
+
+	pingErr error // Returned by Ping
+
+	latencySince time.Time // The window start GetLatencyPercentiles was last asked for
+
+	targetsOrder store.TargetOrder // The order GetTargets was last asked for
+	targetsAfter *store.Cursor     // The cursor GetTargets was last given
 }
 
 func NewMockStore() *MockStore {
@@ -24,10 +54,13 @@ func NewMockStore() *MockStore {
 		targets:         make(map[string]*store.Target),
 		idempotencyKeys: make(map[string]*store.IdempotencyResponse),
 		results:         make(map[string][]*store.CheckResult),
+		leases:          make(map[string]string),
+
+		idempotencyExpiry: make(map[string]time.Time),
 	}
 }
 
-func (m *MockStore) UpsertTargetByURL(ctx context.Context, canonicalURL, host string) (*store.Target, bool, error) {
+func (m *MockStore) UpsertTargetByURL(ctx context.Context, canonicalURL, host string, settings store.TargetSettings) (*store.Target, bool, error) {
 	// Check if target already exists
 	for _, target := range m.targets {
 		if target.URL == canonicalURL {
@@ -41,56 +74,408 @@ func (m *MockStore) UpsertTargetByURL(ctx context.Context, canonicalURL, host st
 		URL:       canonicalURL,
 		Host:      host,
 		CreatedAt: time.Now(),
+		Enabled:   true,
+
+		TargetSettings: settings,
 	}
 	m.targets[target.ID] = target
 	return target, true, nil
 }
 
-func (m *MockStore) GetTargets(ctx context.Context, hostFilter string, afterCreatedAt time.Time, afterID string, limit int) ([]*store.Target, *store.Cursor, error) {
+func (m *MockStore) GetTargetByID(ctx context.Context, id string) (*store.Target, error) {
+	target, ok := m.targets[id]
+	if !ok {
+		return nil, store.ErrTargetNotFound
+	}
+	return target, nil
+}
+
+func (m *MockStore) UpdateTarget(ctx context.Context, id string, update store.TargetUpdate) (*store.Target, error) {
+	target, ok := m.targets[id]
+	if !ok {
+		return nil, store.ErrTargetNotFound
+	}
+	updated := *target
+	for _, field := range update.Fields {
+		switch field {
+		case "retention":
+			updated.Retention = update.Settings.Retention
+		case "schedule":
+			updated.Schedule = update.Settings.Schedule
+		case "headers":
+			updated.Headers = update.Settings.Headers
+		case "expected_status":
+			updated.ExpectedStatus = update.Settings.ExpectedStatus
+		case "match_pattern":
+			updated.MatchPattern = update.Settings.MatchPattern
+		case "match_mode":
+			updated.MatchMode = update.Settings.MatchMode
+		case "proxy":
+			updated.Proxy = update.Settings.Proxy
+		case "insecure_skip_verify":
+			updated.InsecureSkipVerify = update.Settings.InsecureSkipVerify
+		case "method":
+			updated.Method = update.Settings.Method
+		case "request_body":
+			updated.RequestBody = update.Settings.RequestBody
+		case "failure_threshold":
+			updated.FailureThreshold = update.Settings.FailureThreshold
+		}
+	}
+	if update.Enabled != nil {
+		updated.Enabled = *update.Enabled
+	}
+	m.targets[id] = &updated
+	return &updated, nil
+}
+
+func (m *MockStore) DeleteTarget(ctx context.Context, id string) error {
+	if _, ok := m.targets[id]; !ok {
+		return store.ErrTargetNotFound
+	}
+	delete(m.targets, id)
+	delete(m.results, id)
+	return nil
+}
+
+// matchesHosts mimics the store's HostFilter; hosts contain no '/', so
+// path.Match's * covers any run of characters in them
+func matchesHosts(f store.HostFilter, host string) bool {
+	if f.Pattern != "" {
+		if ok, _ := path.Match(f.Pattern, host); !ok {
+			return false
+		}
+	}
+	return (f.Host == "" || host == f.Host) && strings.HasPrefix(host, f.Prefix)
+}
+
+func (m *MockStore) GetTargets(ctx context.Context, hosts store.HostFilter, order store.TargetOrder, after *store.Cursor, limit int) ([]*store.Target, *store.Cursor, error) {
+	m.targetsOrder, m.targetsAfter = order, after
+	var targets []*store.Target
+	for _, target := range m.targets {
+		if matchesHosts(hosts, target.Host) {
+			targets = append(targets, target)
+		}
+	}
+	return targets, nil, nil
+}
+
+func (m *MockStore) GetDistinctHosts(ctx context.Context) ([]string, error) {
+	seen := make(map[string]bool)
+	hosts := []string{}
+	for _, target := range m.targets {
+		if !seen[target.Host] {
+			seen[target.Host] = true
+			hosts = append(hosts, target.Host)
+		}
+	}
+	sort.Strings(hosts)
+	return hosts, nil
+}
+
+func (m *MockStore) CountTargets(ctx context.Context, hosts store.HostFilter) (int, error) {
+	count := 0
+	for _, target := range m.targets {
+		if matchesHosts(hosts, target.Host) {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (m *MockStore) GetTargetsVersion(ctx context.Context, hosts store.HostFilter) (*store.TargetsVersion, error) {
+	var v store.TargetsVersion
+	for _, target := range m.targets {
+		if !matchesHosts(hosts, target.Host) {
+			continue
+		}
+		v.Count++
+		if target.CreatedAt.After(v.LastModified) {
+			v.LastModified = target.CreatedAt
+		}
+	}
+	return &v, nil
+}
+
+func (m *MockStore) GetEnabledTargets(ctx context.Context, afterCreatedAt time.Time, afterID string, limit int) ([]*store.Target, *store.Cursor, error) {
 	var targets []*store.Target
 	for _, target := range m.targets {
-		if hostFilter == "" || target.Host == hostFilter {
+		if target.Enabled {
 			targets = append(targets, target)
 		}
 	}
 	return targets, nil, nil
 }
 
+func (m *MockStore) GetStaleTargets(ctx context.Context, checkedBefore time.Time, limit int) ([]*store.Target, error) {
+	var stale []*store.Target
+	for _, target := range m.targets {
+		if !target.CreatedAt.Before(checkedBefore) {
+			continue
+		}
+		fresh := false
+		for _, result := range m.results[target.ID] {
+			if !result.CheckedAt.Before(checkedBefore) {
+				fresh = true
+				break
+			}
+		}
+		if !fresh {
+			stale = append(stale, target)
+		}
+	}
+	return stale, nil
+}
+
+// ClaimStaleTargets is GetStaleTargets; handlers never claim.
+func (m *MockStore) ClaimStaleTargets(ctx context.Context, checkedBefore, now time.Time, lease time.Duration, limit int) ([]*store.Target, error) {
+	return m.GetStaleTargets(ctx, checkedBefore, limit)
+}
+
+// ClaimDueTargets treats every target as due; handlers never claim.
+func (m *MockStore) ClaimDueTargets(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]*store.Target, error) {
+	var due []*store.Target
+	for _, target := range m.targets {
+		if len(due) == limit {
+			break
+		}
+		due = append(due, target)
+	}
+	return due, nil
+}
+
 func (m *MockStore) InsertCheckResult(ctx context.Context, result *store.CheckResult) error {
 	if m.results[result.TargetID] == nil {
 		m.results[result.TargetID] = []*store.CheckResult{}
 	}
 	m.results[result.TargetID] = append(m.results[result.TargetID], result)
+	if target, ok := m.targets[result.TargetID]; ok && result.NextCheckAt != nil {
+		target.NextCheckAt = result.NextCheckAt
+	}
+	return nil
+}
+
+func (m *MockStore) InsertCheckResults(ctx context.Context, results []*store.CheckResult) error {
+	for _, result := range results {
+		m.InsertCheckResult(ctx, result)
+	}
+	return nil
+}
+
+func (m *MockStore) GetState(ctx context.Context, targetID string) (*store.TargetState, error) {
+	var state *store.TargetState
+	for _, r := range m.results[targetID] {
+		var expected *int
+		if target, ok := m.targets[targetID]; ok {
+			expected = target.ExpectedStatus
+		}
+		current := r.State(expected, nil)
+		if state == nil || state.State != current {
+			state = &store.TargetState{TargetID: targetID, State: current, Since: r.CheckedAt}
+		}
+		state.LastChecked = r.CheckedAt
+	}
+	if state == nil {
+		return nil, store.ErrNoState
+	}
+	return state, nil
+}
+
+func (m *MockStore) DeleteExpiredResults(ctx context.Context, now time.Time, defaultRetention time.Duration) (int64, error) {
+	return 0, nil
+}
+
+func (m *MockStore) DeleteResultsBefore(ctx context.Context, cutoff time.Time) (int64, error) {
+	return 0, nil
+}
+
+func (m *MockStore) GetResults(ctx context.Context, targetID string, since time.Time, nodeID string, after *store.ResultCursor, limit int) ([]*store.CheckResult, *store.ResultCursor, error) {
+	var results []*store.CheckResult
+	for _, result := range m.results[targetID] {
+		if nodeID != "" && result.NodeID != nodeID {
+			continue
+		}
+		if after != nil && !result.CheckedAt.Before(after.CheckedAt) {
+			continue
+		}
+		results = append(results, result)
+	}
+	if len(results) < limit {
+		return results, nil, nil
+	}
+	results = results[:limit]
+	last := results[limit-1]
+	return results, &store.ResultCursor{CheckedAt: last.CheckedAt, ID: last.ID}, nil
+}
+
+func (m *MockStore) GetResultsAfterID(ctx context.Context, afterID int64, targetID, host string, limit int) ([]*store.CheckResult, error) {
+	var results []*store.CheckResult
+	for id, targetResults := range m.results {
+		if targetID != "" && id != targetID {
+			continue
+		}
+		if target, ok := m.targets[id]; host != "" && (!ok || target.Host != host) {
+			continue
+		}
+		for _, result := range targetResults {
+			if result.ID > afterID {
+				results = append(results, result)
+			}
+		}
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].ID < results[j].ID })
+	if len(results) > limit {
+		results = results[:limit]
+	}
+	return results, nil
+}
+
+func (m *MockStore) GetLatestResults(ctx context.Context, targetIDs []string) (map[string]*store.CheckResult, error) {
+	latest := make(map[string]*store.CheckResult)
+	for _, id := range targetIDs {
+		for _, result := range m.results[id] {
+			if cur, ok := latest[id]; !ok || result.CheckedAt.After(cur.CheckedAt) {
+				latest[id] = result
+			}
+		}
+	}
+	return latest, nil
+}
+
+func (m *MockStore) GetLatencyPercentiles(ctx context.Context, targetID string, since time.Time) (*store.LatencyPercentiles, error) {
+	m.latencySince = since
+	return &store.LatencyPercentiles{Count: len(m.results[targetID])}, nil
+}
+
+func (m *MockStore) expectedStatus(targetID string) *int {
+	if target, ok := m.targets[targetID]; ok {
+		return target.ExpectedStatus
+	}
 	return nil
 }
 
-func (m *MockStore) GetResults(ctx context.Context, targetID string, since time.Time, limit int) ([]*store.CheckResult, error) {
-	return m.results[targetID], nil
+func (m *MockStore) GetSummary(ctx context.Context, targetID string, since time.Time) (*store.Summary, error) {
+	var sum store.Summary
+	for _, result := range m.results[targetID] {
+		if result.CheckedAt.Before(since) {
+			continue
+		}
+		sum.TotalChecks++
+		if result.Succeeded(m.expectedStatus(targetID), nil) {
+			sum.SuccessfulChecks++
+		}
+	}
+	sum.FailedChecks = sum.TotalChecks - sum.SuccessfulChecks
+	if sum.TotalChecks > 0 {
+		uptime := float64(sum.SuccessfulChecks) * 100 / float64(sum.TotalChecks)
+		sum.UptimePercent = &uptime
+	}
+	return &sum, nil
+}
+
+func (m *MockStore) AcknowledgeFailures(ctx context.Context, targetID string, from, until time.Time, note string) (int64, error) {
+	var count int64
+	for _, result := range m.results[targetID] {
+		if result.Succeeded(m.expectedStatus(targetID), nil) || result.Acknowledged {
+			continue
+		}
+		if result.CheckedAt.Before(from) || result.CheckedAt.After(until) {
+			continue
+		}
+		result.Acknowledged = true
+		if note != "" {
+			result.AckNote = &note
+		}
+		count++
+	}
+	return count, nil
+}
+
+func (m *MockStore) CountFailures(ctx context.Context, targetID string, since time.Time) (*store.FailureCounts, error) {
+	var counts store.FailureCounts
+	for _, result := range m.results[targetID] {
+		if result.Succeeded(m.expectedStatus(targetID), nil) || result.CheckedAt.Before(since) {
+			continue
+		}
+		counts.Total++
+		if result.Acknowledged {
+			counts.Acknowledged++
+		}
+	}
+	counts.Unacknowledged = counts.Total - counts.Acknowledged
+	return &counts, nil
 }
 
-func (m *MockStore) UpsertIdempotencyKey(ctx context.Context, key, requestHash, targetID string, responseCode int, responseBody interface{}) (*store.IdempotencyResponse, bool, error) {
-	if existing, exists := m.idempotencyKeys[key]; exists {
+func (m *MockStore) UpsertIdempotencyKey(ctx context.Context, key, requestHash, targetID string, responseCode int, responseBody interface{}, expiresAt time.Time) (*store.IdempotencyResponse, bool, error) {
+	if existing, found, _ := m.GetIdempotencyKey(ctx, key); found {
 		return existing, false, nil
 	}
 
 	response := &store.IdempotencyResponse{
 		ResponseCode: responseCode,
 		ResponseBody: responseBody,
+		RequestHash:  requestHash,
 	}
 	m.idempotencyKeys[key] = response
+	m.idempotencyExpiry[key] = expiresAt
 	return response, true, nil
 }
 
 func (m *MockStore) GetIdempotencyKey(ctx context.Context, key string) (*store.IdempotencyResponse, bool, error) {
-	if response, exists := m.idempotencyKeys[key]; exists {
-		return response, true, nil
+	response, exists := m.idempotencyKeys[key]
+	if !exists {
+		return nil, false, nil
+	}
+	if !time.Now().Before(m.idempotencyExpiry[key]) {
+		delete(m.idempotencyKeys, key)
+		delete(m.idempotencyExpiry, key)
+		return nil, false, nil
+	}
+	return response, true, nil
+}
+
+func (m *MockStore) RecanonicalizeTargets(ctx context.Context, canonicalize store.CanonicalizeFunc) (*store.RecanonicalizeReport, error) {
+	report := &store.RecanonicalizeReport{Scanned: len(m.targets)}
+	for _, target := range m.targets {
+		canonicalURL, host, err := canonicalize(target.URL)
+		if err != nil {
+			report.Skipped++
+			continue
+		}
+		if canonicalURL != target.URL || host != target.Host {
+			target.URL, target.Host = canonicalURL, host
+			report.Updated++
+		}
+	}
+	return report, nil
+}
+
+func (m *MockStore) AcquireLease(ctx context.Context, name, holder string, ttl time.Duration) (bool, error) {
+	if current, exists := m.leases[name]; exists && current != holder {
+		return false, nil
+	}
+	m.leases[name] = holder
+	return true, nil
+}
+
+func (m *MockStore) ReleaseLease(ctx context.Context, name, holder string) error {
+	if m.leases[name] == holder {
+		delete(m.leases, name)
 	}
-	return nil, false, nil
+	return nil
+}
+
+func (m *MockStore) WithTx(ctx context.Context, fn func(store.Store) error) error {
+	return fn(m)
+}
+
+func (m *MockStore) Ping(ctx context.Context) error {
+	return m.pingErr
 }
 
 func TestCreateTargetIdempotency(t *testing.T) {
 	mockStore := NewMockStore()
-	server := NewServer(mockStore)
+	server := NewServer(mockStore, Options{})
 
 	// Test data
 	requestBody := `{"url":"https://example.com"}`
@@ -141,9 +526,76 @@ func TestCreateTargetIdempotency(t *testing.T) {
 	}
 }
 
+func TestCreateTargetIdempotencyMismatch(t *testing.T) {
+	server := NewServer(NewMockStore(), Options{})
+
+	send := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/v1/targets", bytes.NewBufferString(body))
+		req.Header.Set("Idempotency-Key", "reused-key")
+		rr := httptest.NewRecorder()
+		server.Router().ServeHTTP(rr, req)
+		return rr
+	}
+
+	if rr := send(`{"url":"https://example.com"}`); rr.Code != http.StatusCreated {
+		t.Fatalf("First request: expected status 201, got %d", rr.Code)
+	}
+	if rr := send(`{"url":"https://example.com"}`); rr.Code != http.StatusOK {
+		t.Errorf("Matching replay: expected status 200, got %d", rr.Code)
+	}
+
+	rr := send(`{"url":"https://other.example.com"}`)
+	if rr.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("Mismatched replay: expected status 422, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if !strings.Contains(rr.Body.String(), "different request") {
+		t.Errorf("Expected mismatch error, got %s", rr.Body.String())
+	}
+
+	// The same URL with different settings is a different request too
+	for _, body := range []string{
+		`{"url":"https://example.com","expected_status":204}`,
+		`{"url":"https://example.com","headers":{"X-Api-Key":"secret"}}`,
+		`{"url":"https://example.com","proxy":"http://proxy.example.com:3128"}`,
+	} {
+		if rr := send(body); rr.Code != http.StatusUnprocessableEntity {
+			t.Errorf("Replay as %s: expected status 422, got %d: %s", body, rr.Code, rr.Body.String())
+		}
+	}
+
+	// Spelling the URL differently doesn't change it
+	if rr := send(`{"url":"HTTPS://Example.com:443"}`); rr.Code != http.StatusOK {
+		t.Errorf("Replay with an equivalent URL: expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestCreateTargetIdempotencyKeyExpired(t *testing.T) {
+	mockStore := NewMockStore()
+	server := NewServer(mockStore, Options{})
+
+	// Cached earlier for a different URL, but long expired
+	_, _, err := mockStore.UpsertIdempotencyKey(context.Background(), "old-key", createRequestHash("https://old.example.com", store.TargetSettings{}),
+		"t_old", http.StatusCreated, map[string]string{"id": "t_old"}, time.Now().Add(-time.Minute))
+	if err != nil {
+		t.Fatalf("Failed to seed idempotency key: %v", err)
+	}
+
+	req := httptest.NewRequest("POST", "/v1/targets", bytes.NewBufferString(`{"url":"https://example.com"}`))
+	req.Header.Set("Idempotency-Key", "old-key")
+	rr := httptest.NewRecorder()
+	server.Router().ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected a fresh create with status 201, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if !strings.Contains(rr.Body.String(), `"url":"https://example.com"`) {
+		t.Errorf("Expected the new target, got %s", rr.Body.String())
+	}
+}
+
 func TestCreateTargetWithoutIdempotencyKey(t *testing.T) {
 	mockStore := NewMockStore()
-	server := NewServer(mockStore)
+	server := NewServer(mockStore, Options{})
 
 	requestBody := `{"url":"https://example.com"}`
 
@@ -172,7 +624,7 @@ func TestCreateTargetWithoutIdempotencyKey(t *testing.T) {
 
 func TestHealthCheck(t *testing.T) {
 	mockStore := NewMockStore()
-	server := NewServer(mockStore)
+	server := NewServer(mockStore, Options{})
 
 	req := httptest.NewRequest("GET", "/healthz", nil)
 	rr := httptest.NewRecorder()
@@ -193,9 +645,61 @@ func TestHealthCheck(t *testing.T) {
 	}
 }
 
+func TestVersion(t *testing.T) {
+	build := BuildInfo{Version: "v1.2.3", GitCommit: "abc1234", BuildTime: "2024-01-01_00:00:00"}
+	server := NewServer(NewMockStore(), Options{Build: build})
+
+	rr := httptest.NewRecorder()
+	server.Router().ServeHTTP(rr, httptest.NewRequest("GET", "/version", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", rr.Code)
+	}
+	var got BuildInfo
+	if err := json.Unmarshal(rr.Body.Bytes(), &got); err != nil {
+		t.Fatalf("Failed to parse version response: %v", err)
+	}
+	if got != build {
+		t.Errorf("Expected %+v, got %+v", build, got)
+	}
+
+	// Unset fields fall back to placeholders
+	server = NewServer(NewMockStore(), Options{})
+	rr = httptest.NewRecorder()
+	server.Router().ServeHTTP(rr, httptest.NewRequest("GET", "/version", nil))
+	json.Unmarshal(rr.Body.Bytes(), &got)
+	if want := (BuildInfo{Version: "dev", GitCommit: "unknown", BuildTime: "unknown"}); got != want {
+		t.Errorf("Expected defaults %+v, got %+v", want, got)
+	}
+}
+
+func TestReadinessCheck(t *testing.T) {
+	mockStore := NewMockStore()
+	server := NewServer(mockStore, Options{})
+
+	rr := httptest.NewRecorder()
+	server.Router().ServeHTTP(rr, httptest.NewRequest("GET", "/readyz", nil))
+	if rr.Code != http.StatusOK {
+		t.Errorf("Readiness check: expected status 200, got %d", rr.Code)
+	}
+
+	mockStore.pingErr = errors.New("connection refused")
+	rr = httptest.NewRecorder()
+	server.Router().ServeHTTP(rr, httptest.NewRequest("GET", "/readyz", nil))
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("Readiness check with failing database: expected status 503, got %d", rr.Code)
+	}
+
+	// Liveness doesn't depend on the database
+	rr = httptest.NewRecorder()
+	server.Router().ServeHTTP(rr, httptest.NewRequest("GET", "/healthz", nil))
+	if rr.Code != http.StatusOK {
+		t.Errorf("Health check with failing database: expected status 200, got %d", rr.Code)
+	}
+}
+
 func TestListTargets(t *testing.T) {
 	mockStore := NewMockStore()
-	server := NewServer(mockStore)
+	server := NewServer(mockStore, Options{})
 
 	// Create a target first
 	requestBody := `{"url":"https://example.com"}`
@@ -229,3 +733,1710 @@ func TestListTargets(t *testing.T) {
 		t.Errorf("Expected 1 target, got %d", len(items))
 	}
 }
+
+func TestListTargetsSort(t *testing.T) {
+	for _, secret := range []string{"", "s3cret"} {
+		mockStore := NewMockStore()
+		server := NewServer(mockStore, Options{CursorSecret: []byte(secret)})
+
+		rr := httptest.NewRecorder()
+		server.Router().ServeHTTP(rr, httptest.NewRequest("GET", "/v1/targets", nil))
+		if rr.Code != http.StatusOK || mockStore.targetsOrder != store.OrderCreatedAt {
+			t.Errorf("Expected the default order created_at, got %d and %q", rr.Code, mockStore.targetsOrder)
+		}
+
+		for _, order := range []store.TargetOrder{store.OrderCreatedAt, store.OrderCreatedAtDesc, store.OrderHost, store.OrderNextCheck} {
+			rr = httptest.NewRecorder()
+			server.Router().ServeHTTP(rr, httptest.NewRequest("GET", "/v1/targets?sort="+url.QueryEscape(string(order)), nil))
+			if rr.Code != http.StatusOK || mockStore.targetsOrder != order {
+				t.Errorf("sort=%s: expected status 200 with that order, got %d and %q", order, rr.Code, mockStore.targetsOrder)
+			}
+		}
+
+		rr = httptest.NewRecorder()
+		server.Router().ServeHTTP(rr, httptest.NewRequest("GET", "/v1/targets?sort=url", nil))
+		if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), "invalid sort") {
+			t.Errorf("Expected 400 for an unknown sort, got %d: %s", rr.Code, rr.Body.String())
+		}
+
+		// The page token carries the host, so a host-sorted listing continues where it left off
+		createdAt := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+		token := server.buildCursorToken(&model.Cursor{CreatedAt: createdAt, ID: "t_1", Host: "b.example.com"})
+		rr = httptest.NewRecorder()
+		server.Router().ServeHTTP(rr, httptest.NewRequest("GET", "/v1/targets?sort=host&page_token="+token, nil))
+		after := mockStore.targetsAfter
+		if rr.Code != http.StatusOK || after == nil || after.Host != "b.example.com" || after.ID != "t_1" || !after.CreatedAt.Equal(createdAt) {
+			t.Errorf("Expected to continue after t_1 on b.example.com, got %d and %+v", rr.Code, after)
+		}
+
+		// Likewise the next check, for a listing sorted by it
+		nextCheck := createdAt.Add(time.Hour)
+		token = server.buildCursorToken(&model.Cursor{CreatedAt: createdAt, ID: "t_1", NextCheckAt: &nextCheck})
+		rr = httptest.NewRecorder()
+		server.Router().ServeHTTP(rr, httptest.NewRequest("GET", "/v1/targets?sort=next_check_at&page_token="+token, nil))
+		after = mockStore.targetsAfter
+		if rr.Code != http.StatusOK || after == nil || after.NextCheckAt == nil || !after.NextCheckAt.Equal(nextCheck) || after.ID != "t_1" {
+			t.Errorf("Expected to continue after t_1 due at %v, got %d and %+v", nextCheck, rr.Code, after)
+		}
+	}
+}
+
+func TestGetTargetNextCheckAt(t *testing.T) {
+	mockStore := NewMockStore()
+	server := NewServer(mockStore, Options{})
+	mockStore.targets["t_1"] = &store.Target{ID: "t_1", URL: "https://example.com", Host: "example.com", Enabled: true}
+
+	get := func() map[string]any {
+		t.Helper()
+		rr := httptest.NewRecorder()
+		server.Router().ServeHTTP(rr, httptest.NewRequest("GET", "/v1/targets/t_1", nil))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+		}
+		var target map[string]any
+		if err := json.Unmarshal(rr.Body.Bytes(), &target); err != nil {
+			t.Fatalf("Failed to parse target: %v", err)
+		}
+		return target
+	}
+
+	// Not scheduled yet
+	if next, ok := get()["next_check_at"]; !ok || next != nil {
+		t.Errorf("Expected next_check_at to be present and null, got %v", next)
+	}
+
+	status := 200
+	checked := time.Now().UTC().Truncate(time.Second)
+	next := checked.Add(15 * time.Second)
+	mockStore.InsertCheckResult(context.Background(), &store.CheckResult{TargetID: "t_1", CheckedAt: checked, StatusCode: &status, NextCheckAt: &next})
+
+	raw, _ := get()["next_check_at"].(string)
+	got, err := time.Parse(time.RFC3339, raw)
+	if err != nil || !got.Equal(next) || !got.After(checked) {
+		t.Errorf("Expected next_check_at %v, one interval after the check at %v, got %q", next, checked, raw)
+	}
+}
+
+// blockingStore hangs target lookups until released, whatever the context
+type blockingStore struct {
+	*MockStore
+	release chan struct{}
+}
+
+func (b *blockingStore) GetTargetByID(ctx context.Context, id string) (*store.Target, error) {
+	<-b.release
+	return nil, ctx.Err()
+}
+
+// RecanonicalizeTargets outlasts TestHandlerTimeout's bound, failing only if
+// its context is cut short
+func (b *blockingStore) RecanonicalizeTargets(ctx context.Context, canonicalize store.CanonicalizeFunc) (*store.RecanonicalizeReport, error) {
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-time.After(150 * time.Millisecond):
+		return &store.RecanonicalizeReport{}, nil
+	}
+}
+
+func TestHandlerTimeout(t *testing.T) {
+	blocking := &blockingStore{MockStore: NewMockStore(), release: make(chan struct{})}
+	defer close(blocking.release)
+	server := NewServer(blocking, Options{HandlerTimeout: 50 * time.Millisecond})
+
+	done := make(chan *httptest.ResponseRecorder)
+	go func() {
+		rr := httptest.NewRecorder()
+		server.Router().ServeHTTP(rr, httptest.NewRequest("GET", "/v1/targets/t_1", nil))
+		done <- rr
+	}()
+
+	select {
+	case rr := <-done:
+		if rr.Code != http.StatusServiceUnavailable {
+			t.Fatalf("Expected status 503, got %d: %s", rr.Code, rr.Body.String())
+		}
+		if ct := rr.Header().Get("Content-Type"); !strings.HasPrefix(ct, "application/json") {
+			t.Errorf("Expected a JSON error, got Content-Type %q", ct)
+		}
+		var body map[string]string
+		if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil || body["error"] != "request timed out" {
+			t.Errorf("Expected a request timed out error, got %s", rr.Body.String())
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Handler hung past its timeout")
+	}
+
+	// Requests that finish in time are untouched
+	rr := httptest.NewRecorder()
+	server.Router().ServeHTTP(rr, httptest.NewRequest("GET", "/v1/hosts", nil))
+	if rr.Code != http.StatusOK {
+		t.Errorf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	// Recanonicalize isn't bounded as a whole
+	rr = httptest.NewRecorder()
+	server.Router().ServeHTTP(rr, httptest.NewRequest("POST", "/v1/admin/recanonicalize", nil))
+	if rr.Code != http.StatusOK {
+		t.Errorf("Expected recanonicalize to outlast the timeout, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestHandlerTimeoutCheckDuration(t *testing.T) {
+	mockStore := NewMockStore()
+	mockStore.targets["t_1"] = &store.Target{ID: "t_1", URL: "https://example.com", Host: "example.com"}
+	// A check that runs well past HandlerTimeout, unless cut short
+	slowCheck := func(ctx context.Context, target *store.Target) (*store.CheckResult, error) {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(150 * time.Millisecond):
+			return &store.CheckResult{TargetID: target.ID, StatusCode: &[]int{200}[0]}, nil
+		}
+	}
+
+	tests := []struct {
+		checkDuration time.Duration
+		want          int
+	}{
+		{0, http.StatusServiceUnavailable},
+		{time.Second, http.StatusOK},
+	}
+	for _, tt := range tests {
+		server := NewServer(mockStore, Options{HandlerTimeout: 50 * time.Millisecond, CheckDuration: tt.checkDuration, CheckOnce: slowCheck})
+		rr := httptest.NewRecorder()
+		server.Router().ServeHTTP(rr, httptest.NewRequest("POST", "/v1/targets/t_1/check", nil))
+		if rr.Code != tt.want {
+			t.Errorf("CheckDuration %v: expected status %d, got %d: %s", tt.checkDuration, tt.want, rr.Code, rr.Body.String())
+		}
+	}
+}
+
+func TestListTargetsIncludeTotal(t *testing.T) {
+	mockStore := NewMockStore()
+	server := NewServer(mockStore, Options{})
+
+	for id, host := range map[string]string{"t_a": "example.com", "t_b": "example.com", "t_c": "example.org"} {
+		mockStore.targets[id] = &store.Target{ID: id, URL: "https://" + host + "/" + id, Host: host}
+	}
+
+	list := func(query string) map[string]interface{} {
+		t.Helper()
+		rr := httptest.NewRecorder()
+		server.Router().ServeHTTP(rr, httptest.NewRequest("GET", "/v1/targets"+query, nil))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status 200 for %q, got %d", query, rr.Code)
+		}
+		var response map[string]interface{}
+		if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+			t.Fatalf("Failed to parse list response: %v", err)
+		}
+		return response
+	}
+
+	// Opt-in only
+	if _, ok := list("")["total_count"]; ok {
+		t.Error("Expected no total_count without include_total")
+	}
+
+	response := list("?include_total=true")
+	if total := response["total_count"]; total != float64(3) || len(response["items"].([]interface{})) != 3 {
+		t.Errorf("Expected total_count 3 matching the items, got %v", total)
+	}
+
+	response = list("?include_total=true&host=example.com")
+	if total := response["total_count"]; total != float64(2) || len(response["items"].([]interface{})) != 2 {
+		t.Errorf("Expected host-filtered total_count 2 matching the items, got %v", total)
+	}
+
+	// Patterns are lowercased like the stored hosts
+	for query, want := range map[string]float64{
+		"host_prefix=Example":                    3,
+		"host_like=*.org":                        1,
+		"host_like=EXAMPLE.*&host_prefix=exam":   3,
+		"host_like=*.com&host=example.org":       0,
+		"host_prefix=example.c&host=example.com": 2,
+	} {
+		if total := list("?include_total=true&" + query)["total_count"]; total != want {
+			t.Errorf("%s: expected total_count %v, got %v", query, want, total)
+		}
+	}
+
+	rr := httptest.NewRecorder()
+	server.Router().ServeHTTP(rr, httptest.NewRequest("GET", "/v1/targets?include_total=maybe", nil))
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for invalid include_total, got %d", rr.Code)
+	}
+}
+
+func TestListHosts(t *testing.T) {
+	mockStore := NewMockStore()
+	server := NewServer(mockStore, Options{})
+
+	for id, host := range map[string]string{"t_a": "example.org", "t_b": "example.com", "t_c": "example.org"} {
+		mockStore.targets[id] = &store.Target{ID: id, URL: "https://" + host + "/" + id, Host: host}
+	}
+
+	rr := httptest.NewRecorder()
+	server.Router().ServeHTTP(rr, httptest.NewRequest("GET", "/v1/hosts", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", rr.Code)
+	}
+	if got := strings.TrimSpace(rr.Body.String()); got != `{"hosts":["example.com","example.org"]}` {
+		t.Errorf("Unexpected hosts payload %s", got)
+	}
+}
+
+func TestListTargetsConditionalGet(t *testing.T) {
+	mockStore := NewMockStore()
+	server := NewServer(mockStore, Options{})
+
+	create := func(url string) {
+		req := httptest.NewRequest("POST", "/v1/targets", bytes.NewBufferString(`{"url":"`+url+`"}`))
+		req.Header.Set("Content-Type", "application/json")
+		server.Router().ServeHTTP(httptest.NewRecorder(), req)
+	}
+	list := func(ifNoneMatch string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/v1/targets", nil)
+		if ifNoneMatch != "" {
+			req.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		rr := httptest.NewRecorder()
+		server.Router().ServeHTTP(rr, req)
+		return rr
+	}
+
+	create("https://example.com")
+
+	first := list("")
+	etag := first.Header().Get("ETag")
+	if !strings.HasPrefix(etag, `W/"`) {
+		t.Fatalf("Expected a weak ETag, got %q", etag)
+	}
+
+	// Matching validator means the client's copy is current
+	rr := list(etag)
+	if rr.Code != http.StatusNotModified {
+		t.Errorf("Expected status 304 for matching If-None-Match, got %d", rr.Code)
+	}
+	if rr.Body.Len() != 0 {
+		t.Errorf("Expected empty body on 304, got %q", rr.Body.String())
+	}
+	if rr.Header().Get("ETag") != etag {
+		t.Errorf("Expected 304 to repeat the ETag, got %q", rr.Header().Get("ETag"))
+	}
+
+	// Any entry of a list may match
+	if rr := list(`"other", ` + etag); rr.Code != http.StatusNotModified {
+		t.Errorf("Expected status 304 when one listed ETag matches, got %d", rr.Code)
+	}
+
+	// A stale validator gets the full listing
+	rr = list(`W/"stale"`)
+	if rr.Code != http.StatusOK {
+		t.Errorf("Expected status 200 for non-matching If-None-Match, got %d", rr.Code)
+	}
+	if rr.Body.String() != first.Body.String() {
+		t.Errorf("Expected full listing, got %q", rr.Body.String())
+	}
+
+	// Adding a target changes the listing's ETag
+	create("https://example.org")
+	rr = list(etag)
+	if rr.Code != http.StatusOK {
+		t.Errorf("Expected status 200 after the listing changed, got %d", rr.Code)
+	}
+	if rr.Header().Get("ETag") == etag {
+		t.Error("Expected ETag to change after adding a target")
+	}
+}
+
+func TestResultsWindowClamp(t *testing.T) {
+	mockStore := NewMockStore()
+	server := NewServer(mockStore, Options{MaxResultsWindow: time.Hour})
+
+	req := httptest.NewRequest("GET", "/v1/targets/t_1/results?since=2000-01-01T00:00:00Z", nil)
+	rr := httptest.NewRecorder()
+	server.Router().ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", rr.Code)
+	}
+
+	var response map[string]interface{}
+	if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to parse response: %v", err)
+	}
+
+	sinceStr, ok := response["since"].(string)
+	if !ok {
+		t.Fatalf("Expected effective since in response")
+	}
+	since, err := time.Parse(time.RFC3339, sinceStr)
+	if err != nil {
+		t.Fatalf("Failed to parse effective since: %v", err)
+	}
+	if time.Since(since) > time.Hour+time.Minute {
+		t.Errorf("Expected since clamped to the last hour, got %s", sinceStr)
+	}
+}
+
+func TestResultsWindowReject(t *testing.T) {
+	mockStore := NewMockStore()
+	server := NewServer(mockStore, Options{MaxResultsWindow: time.Hour, RejectOutsideWindow: true})
+
+	req := httptest.NewRequest("GET", "/v1/targets/t_1/results?since=2000-01-01T00:00:00Z", nil)
+	rr := httptest.NewRecorder()
+	server.Router().ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for since outside window, got %d", rr.Code)
+	}
+
+	// Omitting since falls back to the window instead of failing
+	req = httptest.NewRequest("GET", "/v1/targets/t_1/results", nil)
+	rr = httptest.NewRecorder()
+	server.Router().ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("Expected status 200 without since, got %d", rr.Code)
+	}
+}
+
+func TestStreamResults(t *testing.T) {
+	mockStore := NewMockStore()
+	broker := checker.NewBroker(4)
+	server := NewServer(mockStore, Options{Broker: broker})
+
+	ts := httptest.NewServer(server.Router())
+	defer ts.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	req, _ := http.NewRequestWithContext(ctx, "GET", ts.URL+"/v1/stream?target_id=t_watched", nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Failed to open stream: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("Expected text/event-stream, got %q", ct)
+	}
+
+	// The filtered-out result must not reach the client
+	other := &store.Target{ID: "t_other", Host: "other.com"}
+	watched := &store.Target{ID: "t_watched", Host: "example.com"}
+	broker.Publish(other, &store.CheckResult{ID: 1, TargetID: other.ID})
+	broker.Publish(watched, &store.CheckResult{ID: 2, TargetID: watched.ID})
+
+	reader := bufio.NewReader(resp.Body)
+	var id, data string
+	for data == "" {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("Failed to read stream: %v", err)
+		}
+		if strings.HasPrefix(line, "id: ") {
+			id = strings.TrimSpace(strings.TrimPrefix(line, "id: "))
+		}
+		if strings.HasPrefix(line, "data: ") {
+			data = strings.TrimPrefix(line, "data: ")
+		}
+	}
+
+	if id != "2" {
+		t.Errorf("Expected event id 2, got %q", id)
+	}
+
+	var result store.CheckResult
+	if err := json.Unmarshal([]byte(data), &result); err != nil {
+		t.Fatalf("Failed to parse event data: %v", err)
+	}
+	if result.TargetID != "t_watched" {
+		t.Errorf("Expected result for t_watched, got %s", result.TargetID)
+	}
+}
+
+func TestStreamResultsResumesFromLastEventID(t *testing.T) {
+	mockStore := NewMockStore()
+	broker := checker.NewBroker(4)
+	server := NewServer(mockStore, Options{Broker: broker})
+	for id := int64(1); id <= 3; id++ {
+		mockStore.results["t_watched"] = append(mockStore.results["t_watched"], &store.CheckResult{ID: id, TargetID: "t_watched"})
+	}
+	mockStore.results["t_other"] = []*store.CheckResult{{ID: 4, TargetID: "t_other"}}
+
+	ts := httptest.NewServer(server.Router())
+	defer ts.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// The client saw results 1 and 2 before it disconnected
+	req, _ := http.NewRequestWithContext(ctx, "GET", ts.URL+"/v1/stream?target_id=t_watched", nil)
+	req.Header.Set("Last-Event-ID", "2")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Failed to open stream: %v", err)
+	}
+	defer resp.Body.Close()
+
+	// Result 3 is replayed, so its live copy is skipped
+	watched := &store.Target{ID: "t_watched", Host: "example.com"}
+	broker.Publish(watched, &store.CheckResult{ID: 3, TargetID: watched.ID})
+	broker.Publish(watched, &store.CheckResult{ID: 5, TargetID: watched.ID})
+
+	reader := bufio.NewReader(resp.Body)
+	var ids []string
+	for len(ids) < 2 {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("Failed to read stream: %v", err)
+		}
+		if strings.HasPrefix(line, "id: ") {
+			ids = append(ids, strings.TrimSpace(strings.TrimPrefix(line, "id: ")))
+		}
+	}
+	if ids[0] != "3" || ids[1] != "5" {
+		t.Errorf("Expected events 3 then 5, got %v", ids)
+	}
+
+	req = httptest.NewRequest("GET", "/v1/stream", nil)
+	req.Header.Set("Last-Event-ID", "abc")
+	rr := httptest.NewRecorder()
+	server.Router().ServeHTTP(rr, req)
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for a malformed Last-Event-ID, got %d", rr.Code)
+	}
+}
+
+func TestStreamResultsDisabled(t *testing.T) {
+	server := NewServer(NewMockStore(), Options{})
+
+	req := httptest.NewRequest("GET", "/v1/stream", nil)
+	rr := httptest.NewRecorder()
+	server.Router().ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status 503 without a broker, got %d", rr.Code)
+	}
+}
+
+func TestCheckTargetNow(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.WriteHeader(http.StatusTeapot)
+		io.WriteString(w, "short and stout")
+	}))
+	defer upstream.Close()
+
+	mockStore := NewMockStore()
+	target, _, _ := mockStore.UpsertTargetByURL(context.Background(), upstream.URL, "local", store.TargetSettings{})
+	chk := checker.NewChecker(mockStore, checker.Options{HTTPTimeout: time.Second, CheckMethod: http.MethodGet})
+	defer chk.Shutdown()
+	server := NewServer(mockStore, Options{CheckOnce: chk.CheckOnce})
+
+	req := httptest.NewRequest("POST", "/v1/targets/"+target.ID+"/check", nil)
+	rr := httptest.NewRecorder()
+	server.Router().ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	var result store.CheckResult
+	if err := json.Unmarshal(rr.Body.Bytes(), &result); err != nil {
+		t.Fatalf("Failed to decode result: %v", err)
+	}
+	if result.TargetID != target.ID || result.StatusCode == nil || *result.StatusCode != http.StatusTeapot {
+		t.Errorf("Expected status 418 for %s, got %+v", target.ID, result)
+	}
+	if result.ContentType == nil || *result.ContentType != "text/plain" || result.ContentLength == nil || *result.ContentLength != 15 {
+		t.Errorf("Expected the upstream's text/plain body of 15 bytes, got %v and %v", result.ContentType, result.ContentLength)
+	}
+	if stored := mockStore.results[target.ID]; len(stored) != 1 || *stored[0].StatusCode != http.StatusTeapot {
+		t.Errorf("Expected the result to be stored, got %v", stored)
+	}
+
+	req = httptest.NewRequest("POST", "/v1/targets/t_missing/check", nil)
+	rr = httptest.NewRecorder()
+	server.Router().ServeHTTP(rr, req)
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 for an unknown target, got %d", rr.Code)
+	}
+}
+
+func TestListInFlight(t *testing.T) {
+	server := NewServer(NewMockStore(), Options{})
+	req := httptest.NewRequest("GET", "/v1/debug/inflight", nil)
+	rr := httptest.NewRecorder()
+	server.Router().ServeHTTP(rr, req)
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status 503 without in-flight tracking, got %d", rr.Code)
+	}
+
+	server = NewServer(NewMockStore(), Options{
+		APITokens: []string{"secret"},
+		InFlight:  func() []string { return []string{"t_1", "t_2"} },
+	})
+	rr = httptest.NewRecorder()
+	server.Router().ServeHTTP(rr, req)
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status 401 without a token, got %d", rr.Code)
+	}
+
+	req.Header.Set("Authorization", "Bearer secret")
+	rr = httptest.NewRecorder()
+	server.Router().ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var body struct {
+		TargetIDs []string `json:"target_ids"`
+		Count     int      `json:"count"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if body.Count != 2 || len(body.TargetIDs) != 2 || body.TargetIDs[0] != "t_1" || body.TargetIDs[1] != "t_2" {
+		t.Errorf("Expected t_1 and t_2 in flight, got %+v", body)
+	}
+}
+
+func TestListTargetsRejectsOversizedCursor(t *testing.T) {
+	server := NewServer(NewMockStore(), Options{})
+
+	token := strings.Repeat("A", 4<<20)
+	req := httptest.NewRequest("GET", "/v1/targets?page_token="+token, nil)
+	rr := httptest.NewRecorder()
+
+	start := time.Now()
+	server.Router().ServeHTTP(rr, req)
+	elapsed := time.Since(start)
+
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for oversized cursor, got %d", rr.Code)
+	}
+	if elapsed > time.Second {
+		t.Errorf("Expected oversized cursor to be rejected quickly, took %v", elapsed)
+	}
+}
+
+func TestCreateTargetRetentionOverride(t *testing.T) {
+	server := NewServer(NewMockStore(), Options{MaxRetention: 30 * 24 * time.Hour})
+
+	req := httptest.NewRequest("POST", "/v1/targets", bytes.NewBufferString(`{"url":"https://example.com","retention":"168h"}`))
+	rr := httptest.NewRecorder()
+	server.Router().ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	var target map[string]interface{}
+	if err := json.Unmarshal(rr.Body.Bytes(), &target); err != nil {
+		t.Fatalf("Failed to parse response: %v", err)
+	}
+	if target["retention"] != "168h0m0s" {
+		t.Errorf("Expected retention 168h0m0s, got %v", target["retention"])
+	}
+
+	// Over the global maximum
+	req = httptest.NewRequest("POST", "/v1/targets", bytes.NewBufferString(`{"url":"https://other.com","retention":"8760h"}`))
+	rr = httptest.NewRecorder()
+	server.Router().ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for retention over maximum, got %d", rr.Code)
+	}
+
+	// Under a second, which would be stored as 0 and purge everything
+	req = httptest.NewRequest("POST", "/v1/targets", bytes.NewBufferString(`{"url":"https://other.com","retention":"500ms"}`))
+	rr = httptest.NewRecorder()
+	server.Router().ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), "at least 1s") {
+		t.Errorf("Expected status 400 for sub-second retention, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestCreateTargetRejectsInvalidHeaders(t *testing.T) {
+	server := NewServer(NewMockStore(), Options{})
+
+	for _, headers := range []string{`{"Bad Name":"x"}`, `{"X-Api-Key":"line\nbreak"}`} {
+		body := `{"url":"https://example.com","headers":` + headers + `}`
+		req := httptest.NewRequest("POST", "/v1/targets", bytes.NewBufferString(body))
+		rr := httptest.NewRecorder()
+		server.Router().ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400 for headers %s, got %d", headers, rr.Code)
+		}
+	}
+}
+
+func TestCreateTargetExpectedStatus(t *testing.T) {
+	server := NewServer(NewMockStore(), Options{})
+
+	req := httptest.NewRequest("POST", "/v1/targets", bytes.NewBufferString(`{"url":"https://example.com","expected_status":401}`))
+	rr := httptest.NewRecorder()
+	server.Router().ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var target store.Target
+	if err := json.Unmarshal(rr.Body.Bytes(), &target); err != nil {
+		t.Fatalf("Failed to parse response: %v", err)
+	}
+	if target.ExpectedStatus == nil || *target.ExpectedStatus != 401 {
+		t.Errorf("Expected expected_status 401, got %v", target.ExpectedStatus)
+	}
+
+	for _, status := range []string{"99", "600"} {
+		req := httptest.NewRequest("POST", "/v1/targets", bytes.NewBufferString(`{"url":"https://other.com","expected_status":`+status+`}`))
+		rr := httptest.NewRecorder()
+		server.Router().ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400 for expected_status %s, got %d", status, rr.Code)
+		}
+	}
+}
+
+func TestCreateTargetRequestMethod(t *testing.T) {
+	server := NewServer(NewMockStore(), Options{})
+
+	req := httptest.NewRequest("POST", "/v1/targets", bytes.NewBufferString(`{"url":"https://api.example.com/health","method":"post","request_body":"{\"ping\":true}"}`))
+	rr := httptest.NewRecorder()
+	server.Router().ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var target store.Target
+	if err := json.Unmarshal(rr.Body.Bytes(), &target); err != nil {
+		t.Fatalf("Failed to parse response: %v", err)
+	}
+	if target.Method != http.MethodPost || target.RequestBody == nil || *target.RequestBody != `{"ping":true}` {
+		t.Errorf("Expected POST with its body, got %q %v", target.Method, target.RequestBody)
+	}
+
+	for _, settings := range []string{`"method":"TRACE"`, `"method":"GET","request_body":"x"`, `"request_body":"x"`} {
+		req := httptest.NewRequest("POST", "/v1/targets", bytes.NewBufferString(`{"url":"https://other.com",`+settings+`}`))
+		rr := httptest.NewRecorder()
+		server.Router().ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400 for %s, got %d", settings, rr.Code)
+		}
+	}
+}
+
+func TestUpdateTarget(t *testing.T) {
+	mockStore := NewMockStore()
+	server := NewServer(mockStore, Options{MaxRetention: 30 * 24 * time.Hour})
+
+	retention := store.Duration(48 * time.Hour)
+	status := 204
+	mockStore.targets["t_1"] = &store.Target{
+		ID: "t_1", URL: "https://example.com", Host: "example.com", CreatedAt: time.Now(),
+		TargetSettings: store.TargetSettings{Retention: &retention, ExpectedStatus: &status, Headers: store.Headers{"X-Probe": "1"}},
+	}
+
+	patch := func(id, body string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		server.Router().ServeHTTP(rr, httptest.NewRequest("PATCH", "/v1/targets/"+id, bytes.NewBufferString(body)))
+		return rr
+	}
+
+	// Only the named settings change; null clears one
+	rr := patch("t_1", `{"expected_status":401,"retention":null}`)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var target store.Target
+	if err := json.Unmarshal(rr.Body.Bytes(), &target); err != nil {
+		t.Fatalf("Failed to parse response: %v", err)
+	}
+	if target.ExpectedStatus == nil || *target.ExpectedStatus != 401 {
+		t.Errorf("Expected expected_status 401, got %v", target.ExpectedStatus)
+	}
+	if target.Retention != nil {
+		t.Errorf("Expected retention cleared, got %v", *target.Retention)
+	}
+	if target.Headers["X-Probe"] != "1" || target.URL != "https://example.com" {
+		t.Errorf("Expected untouched settings to be kept, got %+v", target)
+	}
+
+	// Pausing leaves the settings alone
+	rr = patch("t_1", `{"enabled":false}`)
+	if rr.Code != http.StatusOK || mockStore.targets["t_1"].Enabled || *mockStore.targets["t_1"].ExpectedStatus != 401 {
+		t.Errorf("Expected the target paused with settings kept, got %d %+v", rr.Code, mockStore.targets["t_1"])
+	}
+	if rr := patch("t_1", `{"enabled":true}`); rr.Code != http.StatusOK || !mockStore.targets["t_1"].Enabled {
+		t.Errorf("Expected the target resumed, got %d", rr.Code)
+	}
+
+	// A pattern alone gets the default mode
+	rr = patch("t_1", `{"match_pattern":"ok"}`)
+	if rr.Code != http.StatusOK || mockStore.targets["t_1"].MatchMode != store.MatchContains {
+		t.Errorf("Expected match_mode to default to contains, got %d %+v", rr.Code, mockStore.targets["t_1"].TargetSettings)
+	}
+
+	tests := map[string]struct {
+		id, body string
+		want     int
+	}{
+		"url is immutable":     {"t_1", `{"url":"https://other.com"}`, http.StatusBadRequest},
+		"unknown field":        {"t_1", `{"interval":"5m"}`, http.StatusBadRequest},
+		"invalid setting":      {"t_1", `{"expected_status":600}`, http.StatusBadRequest},
+		"retention over max":   {"t_1", `{"retention":"8760h"}`, http.StatusBadRequest},
+		"mode without pattern": {"t_1", `{"match_pattern":null,"match_mode":"absent"}`, http.StatusBadRequest},
+		"wrong type":           {"t_1", `{"expected_status":"ok"}`, http.StatusBadRequest},
+		"null enabled":         {"t_1", `{"enabled":null}`, http.StatusBadRequest},
+		"invalid proxy":        {"t_1", `{"proxy":"ftp://proxy.example:21"}`, http.StatusBadRequest},
+		"body without method":  {"t_1", `{"request_body":"{}"}`, http.StatusBadRequest},
+		"zero threshold":       {"t_1", `{"failure_threshold":0}`, http.StatusBadRequest},
+		"missing target":       {"t_missing", `{"expected_status":200}`, http.StatusNotFound},
+		"empty patch is no-op": {"t_1", `{}`, http.StatusOK},
+	}
+	for name, tt := range tests {
+		if rr := patch(tt.id, tt.body); rr.Code != tt.want {
+			t.Errorf("%s: expected status %d, got %d: %s", name, tt.want, rr.Code, rr.Body.String())
+		}
+	}
+	if got := mockStore.targets["t_1"]; got.URL != "https://example.com" || *got.ExpectedStatus != 401 {
+		t.Errorf("Expected rejected patches to leave the target alone, got %+v", got)
+	}
+}
+
+func TestGetLatency(t *testing.T) {
+	mockStore := NewMockStore()
+	server := NewServer(mockStore, Options{})
+	mockStore.targets["t_1"] = &store.Target{ID: "t_1", URL: "https://example.com", Host: "example.com"}
+
+	get := func(query string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		server.Router().ServeHTTP(rr, httptest.NewRequest("GET", "/v1/targets/t_1/latency"+query, nil))
+		return rr
+	}
+
+	for query, window := range map[string]time.Duration{"": time.Hour, "?window=15m": 15 * time.Minute} {
+		before := time.Now()
+		rr := get(query)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("%q: expected status 200, got %d: %s", query, rr.Code, rr.Body.String())
+		}
+		var body struct {
+			Window  string                   `json:"window"`
+			Latency store.LatencyPercentiles `json:"latency"`
+		}
+		if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil {
+			t.Fatalf("Failed to parse response: %v", err)
+		}
+		if body.Window != window.String() {
+			t.Errorf("%q: expected window %s, got %s", query, window, body.Window)
+		}
+		if since := mockStore.latencySince; since.Before(before.Add(-window)) || since.After(time.Now().Add(-window)) {
+			t.Errorf("%q: expected stats since %s ago, got %v", query, window, since)
+		}
+	}
+
+	for _, query := range []string{"?window=fast", "?window=0s", "?window=-1h"} {
+		if rr := get(query); rr.Code != http.StatusBadRequest {
+			t.Errorf("%q: expected status 400, got %d", query, rr.Code)
+		}
+	}
+
+	rr := httptest.NewRecorder()
+	server.Router().ServeHTTP(rr, httptest.NewRequest("GET", "/v1/targets/t_missing/latency", nil))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 for an unknown target, got %d", rr.Code)
+	}
+}
+
+func TestGetState(t *testing.T) {
+	mockStore := NewMockStore()
+	server := NewServer(mockStore, Options{})
+	mockStore.targets["t_1"] = &store.Target{ID: "t_1", URL: "https://example.com", Host: "example.com"}
+
+	get := func(id string) (*httptest.ResponseRecorder, map[string]interface{}) {
+		rr := httptest.NewRecorder()
+		server.Router().ServeHTTP(rr, httptest.NewRequest("GET", "/v1/targets/"+id+"/state", nil))
+		var body map[string]interface{}
+		json.Unmarshal(rr.Body.Bytes(), &body)
+		return rr, body
+	}
+
+	rr, body := get("t_1")
+	if rr.Code != http.StatusOK || body["state"] != "unknown" || body["since"] != nil {
+		t.Errorf("Expected unknown state before the first check, got %d %v", rr.Code, body)
+	}
+
+	down := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	ok, fail := 200, 503
+	mockStore.InsertCheckResult(context.Background(), &store.CheckResult{TargetID: "t_1", CheckedAt: down.Add(-time.Minute), StatusCode: &ok})
+	mockStore.InsertCheckResult(context.Background(), &store.CheckResult{TargetID: "t_1", CheckedAt: down, StatusCode: &fail})
+	mockStore.InsertCheckResult(context.Background(), &store.CheckResult{TargetID: "t_1", CheckedAt: down.Add(time.Minute), StatusCode: &fail})
+
+	rr, body = get("t_1")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", rr.Code)
+	}
+	if body["state"] != "down" || body["since"] != "2024-01-01T00:00:00Z" || body["last_checked"] != "2024-01-01T00:01:00Z" {
+		t.Errorf("Unexpected state %v", body)
+	}
+
+	if rr, _ := get("t_missing"); rr.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 for unknown target, got %d", rr.Code)
+	}
+}
+
+func TestJSONResponseEncoding(t *testing.T) {
+	server := NewServer(NewMockStore(), Options{})
+
+	requestBody := `{"url":"https://example.com/search?q=a&tag=<b>","match_pattern":"<title>"}`
+	req := httptest.NewRequest("POST", "/v1/targets", bytes.NewBufferString(requestBody))
+	rr := httptest.NewRecorder()
+	server.Router().ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d", rr.Code)
+	}
+
+	if ct := rr.Header().Get("Content-Type"); ct != "application/json; charset=utf-8" {
+		t.Errorf("Expected JSON content type with charset, got %q", ct)
+	}
+
+	body := rr.Body.String()
+	if !strings.HasSuffix(body, "}\n") {
+		t.Errorf("Expected body to end with a single trailing newline, got %q", body)
+	}
+	// Canonicalizing percent-encodes the query's <b>, but not its &
+	if !strings.Contains(body, `"url":"https://example.com/search?q=a&tag=%3Cb%3E"`) {
+		t.Errorf("Expected URL without HTML escaping, got %s", body)
+	}
+	if !strings.Contains(body, `"match_pattern":"<title>"`) {
+		t.Errorf("Expected match_pattern without HTML escaping, got %s", body)
+	}
+}
+
+func TestStatusOverview(t *testing.T) {
+	mockStore := NewMockStore()
+	server := NewServer(mockStore, Options{})
+
+	now := time.Now().UTC().Truncate(time.Second)
+	up := &store.Target{ID: "t_up", URL: "https://up.example.com", Host: "up.example.com", CreatedAt: now}
+	down := &store.Target{ID: "t_down", URL: "https://down.example.com", Host: "down.example.com", CreatedAt: now}
+	fresh := &store.Target{ID: "t_fresh", URL: "https://fresh.example.com", Host: "fresh.example.com", CreatedAt: now}
+	for _, target := range []*store.Target{up, down, fresh} {
+		mockStore.targets[target.ID] = target
+	}
+
+	errMsg := "connection refused"
+	mockStore.results[up.ID] = []*store.CheckResult{
+		{TargetID: up.ID, CheckedAt: now.Add(-time.Minute), StatusCode: &[]int{500}[0]},
+		{TargetID: up.ID, CheckedAt: now, StatusCode: &[]int{200}[0], LatencyMs: 42},
+	}
+	mockStore.results[down.ID] = []*store.CheckResult{
+		{TargetID: down.ID, CheckedAt: now, Error: &errMsg},
+	}
+
+	req := httptest.NewRequest("GET", "/v1/status", nil)
+	rr := httptest.NewRecorder()
+	server.Router().ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	var response struct {
+		Items []struct {
+			ID         string     `json:"id"`
+			State      string     `json:"state"`
+			CheckedAt  *time.Time `json:"checked_at"`
+			StatusCode *int       `json:"status_code"`
+			Error      *string    `json:"error"`
+		} `json:"items"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to parse response: %v", err)
+	}
+	if len(response.Items) != 3 {
+		t.Fatalf("Expected 3 items, got %d", len(response.Items))
+	}
+
+	for _, item := range response.Items {
+		switch item.ID {
+		case up.ID:
+			if item.State != "up" || item.StatusCode == nil || *item.StatusCode != 200 || item.CheckedAt == nil || !item.CheckedAt.Equal(now) {
+				t.Errorf("Expected latest 200 result for up target, got %+v", item)
+			}
+		case down.ID:
+			if item.State != "down" || item.Error == nil || *item.Error != errMsg {
+				t.Errorf("Expected error for down target, got %+v", item)
+			}
+		case fresh.ID:
+			if item.State != "unknown" || item.CheckedAt != nil {
+				t.Errorf("Expected unchecked target to be unknown, got %+v", item)
+			}
+		}
+	}
+
+	filtered := httptest.NewRecorder()
+	server.Router().ServeHTTP(filtered, httptest.NewRequest("GET", "/v1/status?host=down.example.com", nil))
+	if err := json.Unmarshal(filtered.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to parse filtered response: %v", err)
+	}
+	if len(response.Items) != 1 || response.Items[0].ID != down.ID {
+		t.Errorf("Expected only the down target for host filter, got %+v", response.Items)
+	}
+}
+
+func TestDeleteTarget(t *testing.T) {
+	mockStore := NewMockStore()
+	server := NewServer(mockStore, Options{})
+
+	mockStore.targets["t_1"] = &store.Target{ID: "t_1", URL: "https://example.com", Host: "example.com"}
+	mockStore.results["t_1"] = []*store.CheckResult{{TargetID: "t_1", CheckedAt: time.Now()}}
+
+	rr := httptest.NewRecorder()
+	server.Router().ServeHTTP(rr, httptest.NewRequest("DELETE", "/v1/targets/t_1", nil))
+
+	if rr.Code != http.StatusNoContent {
+		t.Fatalf("Expected status 204, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr.Body.Len() != 0 {
+		t.Errorf("Expected empty body, got %q", rr.Body.String())
+	}
+	if _, ok := mockStore.targets["t_1"]; ok {
+		t.Error("Expected target to be deleted")
+	}
+	if len(mockStore.results["t_1"]) != 0 {
+		t.Error("Expected target results to be deleted")
+	}
+}
+
+func TestDeleteTargetNotFound(t *testing.T) {
+	server := NewServer(NewMockStore(), Options{})
+
+	rr := httptest.NewRecorder()
+	server.Router().ServeHTTP(rr, httptest.NewRequest("DELETE", "/v1/targets/t_missing", nil))
+
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404, got %d", rr.Code)
+	}
+}
+
+func TestGetTarget(t *testing.T) {
+	mockStore := NewMockStore()
+	server := NewServer(mockStore, Options{})
+
+	retention := store.Duration(48 * time.Hour)
+	mockStore.targets["t_1"] = &store.Target{
+		ID: "t_1", URL: "https://example.com", Host: "example.com", CreatedAt: time.Now(),
+		TargetSettings: store.TargetSettings{Retention: &retention},
+	}
+
+	rr := httptest.NewRecorder()
+	server.Router().ServeHTTP(rr, httptest.NewRequest("GET", "/v1/targets/t_1", nil))
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	var got map[string]interface{}
+	if err := json.Unmarshal(rr.Body.Bytes(), &got); err != nil {
+		t.Fatalf("Failed to parse response: %v", err)
+	}
+	for _, key := range []string{"id", "url", "host", "created_at", "retention", "schedule"} {
+		if _, ok := got[key]; !ok {
+			t.Errorf("Expected %q in response like a list item, got %v", key, got)
+		}
+	}
+	if got["id"] != "t_1" || got["retention"] != "48h0m0s" {
+		t.Errorf("Unexpected target %v", got)
+	}
+
+	missing := httptest.NewRecorder()
+	server.Router().ServeHTTP(missing, httptest.NewRequest("GET", "/v1/targets/t_missing", nil))
+	if missing.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 for unknown target, got %d", missing.Code)
+	}
+}
+
+func TestAcknowledgeFailures(t *testing.T) {
+	mockStore := NewMockStore()
+	server := NewServer(mockStore, Options{})
+
+	now := time.Now()
+	mockStore.results["t_1"] = []*store.CheckResult{
+		{TargetID: "t_1", CheckedAt: now.Add(-3 * time.Hour), StatusCode: &[]int{503}[0]},
+		{TargetID: "t_1", CheckedAt: now.Add(-2 * time.Hour), StatusCode: &[]int{200}[0]},
+		{TargetID: "t_1", CheckedAt: now.Add(-time.Hour), StatusCode: &[]int{503}[0]},
+	}
+	ack := func(body string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		server.Router().ServeHTTP(rr, httptest.NewRequest("POST", "/v1/targets/t_1/ack", strings.NewReader(body)))
+		return rr
+	}
+
+	from := now.Add(-90 * time.Minute).UTC().Format(time.RFC3339)
+	if rr := ack(`{"from":"` + from + `","note":"deploy"}`); rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `"acknowledged":1`) {
+		t.Errorf("Expected the failure in range acknowledged, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	// An empty body acknowledges every outstanding failure up to now
+	if rr := ack(""); rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `"acknowledged":1`) {
+		t.Errorf("Expected the remaining failure acknowledged, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr := ack(""); rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `"acknowledged":0`) {
+		t.Errorf("Expected nothing left to acknowledge, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	if rr := ack(`{"from":"` + from + `","until":"2000-01-01T00:00:00Z"}`); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for until before from, got %d", rr.Code)
+	}
+	if rr := ack(`{"from":`); rr.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for a truncated body, got %d", rr.Code)
+	}
+}
+
+func TestGetStats(t *testing.T) {
+	mockStore := NewMockStore()
+	server := NewServer(mockStore, Options{})
+	mockStore.targets["t_1"] = &store.Target{ID: "t_1", URL: "https://example.com", Host: "example.com"}
+
+	now := time.Now()
+	mockStore.results["t_1"] = []*store.CheckResult{
+		{TargetID: "t_1", CheckedAt: now.Add(-time.Hour), StatusCode: &[]int{200}[0]},
+		{TargetID: "t_1", CheckedAt: now.Add(-2 * time.Hour), StatusCode: &[]int{503}[0]},
+	}
+
+	rr := httptest.NewRecorder()
+	server.Router().ServeHTTP(rr, httptest.NewRequest("GET", "/v1/targets/t_1/stats", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var response struct {
+		Latency  store.LatencyPercentiles `json:"latency"`
+		Failures store.FailureCounts      `json:"failures"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to parse response: %v", err)
+	}
+	if response.Latency.Count != 2 || response.Failures.Total != 1 {
+		t.Errorf("Expected 2 checks with 1 failure, got %+v and %+v", response.Latency, response.Failures)
+	}
+
+	rr = httptest.NewRecorder()
+	server.Router().ServeHTTP(rr, httptest.NewRequest("GET", "/v1/targets/t_missing/stats", nil))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 for an unknown target, got %d", rr.Code)
+	}
+}
+
+func TestGetSummary(t *testing.T) {
+	mockStore := NewMockStore()
+	server := NewServer(mockStore, Options{})
+	mockStore.targets["t_1"] = &store.Target{ID: "t_1", URL: "https://example.com", Host: "example.com"}
+
+	now := time.Now()
+	mockStore.results["t_1"] = []*store.CheckResult{
+		{TargetID: "t_1", CheckedAt: now.Add(-time.Hour), StatusCode: &[]int{200}[0]},
+		{TargetID: "t_1", CheckedAt: now.Add(-2 * time.Hour), StatusCode: &[]int{503}[0]},
+		{TargetID: "t_1", CheckedAt: now.Add(-48 * time.Hour), StatusCode: &[]int{503}[0]},
+	}
+
+	rr := httptest.NewRecorder()
+	server.Router().ServeHTTP(rr, httptest.NewRequest("GET", "/v1/targets/t_1/summary", nil))
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	var response struct {
+		Since   time.Time     `json:"since"`
+		Summary store.Summary `json:"summary"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+		t.Fatalf("Failed to parse response: %v", err)
+	}
+
+	// Only the two checks from the default 24h window count
+	if response.Summary.TotalChecks != 2 || response.Summary.FailedChecks != 1 {
+		t.Errorf("Expected 2 checks with 1 failure, got %+v", response.Summary)
+	}
+	if since := time.Since(response.Since); since < 23*time.Hour || since > 25*time.Hour {
+		t.Errorf("Expected since to default to 24h ago, got %v", response.Since)
+	}
+
+	bad := httptest.NewRecorder()
+	server.Router().ServeHTTP(bad, httptest.NewRequest("GET", "/v1/targets/t_1/summary?since=yesterday", nil))
+	if bad.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400 for invalid since, got %d", bad.Code)
+	}
+
+	missing := httptest.NewRecorder()
+	server.Router().ServeHTTP(missing, httptest.NewRequest("GET", "/v1/targets/t_missing/summary", nil))
+	if missing.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 for an unknown target, got %d", missing.Code)
+	}
+}
+
+func TestGetResultsPagination(t *testing.T) {
+	mockStore := NewMockStore()
+	server := NewServer(mockStore, Options{})
+
+	now := time.Now().UTC().Truncate(time.Second)
+	for i := 0; i < 3; i++ {
+		mockStore.results["t_1"] = append(mockStore.results["t_1"],
+			&store.CheckResult{ID: int64(3 - i), TargetID: "t_1", CheckedAt: now.Add(-time.Duration(i) * time.Minute)})
+	}
+
+	var ids []int64
+	token := ""
+	for page := 0; page < 3; page++ {
+		rr := httptest.NewRecorder()
+		server.Router().ServeHTTP(rr, httptest.NewRequest("GET", "/v1/targets/t_1/results?limit=2&page_token="+token, nil))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+		}
+
+		var response struct {
+			Items         []store.CheckResult `json:"items"`
+			NextPageToken string              `json:"next_page_token"`
+		}
+		if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+			t.Fatalf("Failed to parse response: %v", err)
+		}
+		for _, item := range response.Items {
+			ids = append(ids, item.ID)
+		}
+
+		token = response.NextPageToken
+		if token == "" {
+			break
+		}
+	}
+
+	if len(ids) != 3 || ids[0] != 3 || ids[1] != 2 || ids[2] != 1 {
+		t.Errorf("Expected results 3, 2, 1 across pages, got %v", ids)
+	}
+}
+
+func TestListPageInfo(t *testing.T) {
+	mockStore := NewMockStore()
+	server := NewServer(mockStore, Options{})
+
+	now := time.Now().UTC().Truncate(time.Second)
+	for i := 0; i < 3; i++ {
+		mockStore.results["t_1"] = append(mockStore.results["t_1"],
+			&store.CheckResult{ID: int64(3 - i), TargetID: "t_1", CheckedAt: now.Add(-time.Duration(i) * time.Minute)})
+	}
+	mockStore.targets["t_1"] = &store.Target{ID: "t_1", URL: "https://example.com", Host: "example.com"}
+
+	type listResponse struct {
+		NextPageToken string `json:"next_page_token"`
+		Page          struct {
+			NextPageToken *string `json:"next_page_token"`
+			Limit         *int    `json:"limit"`
+			HasMore       *bool   `json:"has_more"`
+		} `json:"page"`
+	}
+	list := func(path string) listResponse {
+		t.Helper()
+		rr := httptest.NewRecorder()
+		server.Router().ServeHTTP(rr, httptest.NewRequest("GET", path, nil))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("Expected status 200 from %s, got %d: %s", path, rr.Code, rr.Body.String())
+		}
+		var response listResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &response); err != nil {
+			t.Fatalf("Failed to parse response: %v", err)
+		}
+		if response.Page.NextPageToken == nil || response.Page.Limit == nil || response.Page.HasMore == nil {
+			t.Fatalf("Expected page to have next_page_token, limit and has_more, got %+v", response.Page)
+		}
+		return response
+	}
+
+	first := list("/v1/targets/t_1/results?limit=2")
+	if *first.Page.Limit != 2 || !*first.Page.HasMore || *first.Page.NextPageToken == "" {
+		t.Errorf("Expected limit 2 with more to come, got limit %d, has_more %t, token %q",
+			*first.Page.Limit, *first.Page.HasMore, *first.Page.NextPageToken)
+	}
+	if first.NextPageToken != *first.Page.NextPageToken {
+		t.Errorf("Expected the top-level token %q to match page's %q", first.NextPageToken, *first.Page.NextPageToken)
+	}
+
+	last := list("/v1/targets/t_1/results?limit=2&page_token=" + *first.Page.NextPageToken)
+	if *last.Page.HasMore || *last.Page.NextPageToken != "" {
+		t.Errorf("Expected the last page to have no more, got has_more %t, token %q", *last.Page.HasMore, *last.Page.NextPageToken)
+	}
+
+	// The limit applied is echoed, including the default for one out of range
+	for path, limit := range map[string]int{"/v1/targets": 20, "/v1/targets?limit=500": 20, "/v1/status?limit=5": 5, "/v1/targets/t_1/results": 50} {
+		if got := list(path); *got.Page.Limit != limit || *got.Page.HasMore {
+			t.Errorf("%s: expected limit %d and no more, got %d, %t", path, limit, *got.Page.Limit, *got.Page.HasMore)
+		}
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	m := metrics.New(prometheus.NewRegistry())
+	server := NewServer(NewMockStore(), Options{Metrics: m})
+
+	for _, path := range []string{"/healthz", "/v1/targets/t_a", "/v1/targets/t_b"} {
+		server.Router().ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+
+	if got := testutil.ToFloat64(m.RequestsTotal.WithLabelValues("GET", "200")); got != 1 {
+		t.Errorf("Expected 1 request with status 200, got %v", got)
+	}
+	if got := testutil.ToFloat64(m.RequestsTotal.WithLabelValues("GET", "404")); got != 2 {
+		t.Errorf("Expected 2 requests with status 404, got %v", got)
+	}
+	// Both lookups share one route label rather than one per target ID
+	if got := testutil.CollectAndCount(m.RequestDuration); got != 2 {
+		t.Errorf("Expected duration series for 2 routes, got %d", got)
+	}
+
+	rr := httptest.NewRecorder()
+	server.Router().ServeHTTP(rr, httptest.NewRequest("GET", "/metrics", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200 from /metrics, got %d", rr.Code)
+	}
+	if !strings.Contains(rr.Body.String(), "linkwatch_http_requests_total") {
+		t.Error("Expected request counter in /metrics output")
+	}
+}
+
+func TestMetricsDisabled(t *testing.T) {
+	server := NewServer(NewMockStore(), Options{})
+
+	rr := httptest.NewRecorder()
+	server.Router().ServeHTTP(rr, httptest.NewRequest("GET", "/metrics", nil))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404 without metrics, got %d", rr.Code)
+	}
+}
+
+func TestSignedCursors(t *testing.T) {
+	secret := []byte("s3cret")
+	signed := NewServer(NewMockStore(), Options{CursorSecret: secret})
+
+	token := signed.buildCursorToken(&model.Cursor{CreatedAt: time.Now(), ID: "t_abc"})
+	legacy := NewServer(NewMockStore(), Options{}).buildCursorToken(&model.Cursor{CreatedAt: time.Now(), ID: "t_abc"})
+
+	// Flip one payload byte, keeping it valid base64
+	b := []byte(token)
+	if b[5] == 'A' {
+		b[5] = 'B'
+	} else {
+		b[5] = 'A'
+	}
+	tampered := string(b)
+
+	tests := []struct {
+		name   string
+		server *Server
+		token  string
+		want   int
+	}{
+		{"signed token", signed, token, http.StatusOK},
+		{"tampered token", signed, tampered, http.StatusBadRequest},
+		{"unsigned token rejected", signed, legacy, http.StatusBadRequest},
+		{"unsigned token allowed", NewServer(NewMockStore(), Options{CursorSecret: secret, AllowUnsignedCursors: true}), legacy, http.StatusOK},
+		{"tampered token with unsigned allowed", NewServer(NewMockStore(), Options{CursorSecret: secret, AllowUnsignedCursors: true}), tampered, http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			tt.server.Router().ServeHTTP(rr, httptest.NewRequest("GET", "/v1/targets?page_token="+tt.token, nil))
+			if rr.Code != tt.want {
+				t.Errorf("Expected status %d, got %d: %s", tt.want, rr.Code, rr.Body.String())
+			}
+		})
+	}
+}
+
+func TestBearerTokenAuth(t *testing.T) {
+	server := NewServer(NewMockStore(), Options{APITokens: []string{"token-a", "token-b"}})
+
+	tests := []struct {
+		name          string
+		authorization string
+		want          int
+	}{
+		{"valid token", "Bearer token-b", http.StatusOK},
+		{"lowercase scheme", "bearer token-a", http.StatusOK},
+		{"missing header", "", http.StatusUnauthorized},
+		{"wrong token", "Bearer token-c", http.StatusUnauthorized},
+		{"wrong scheme", "Basic dG9rZW4tYQ==", http.StatusUnauthorized},
+		{"empty token", "Bearer ", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/v1/targets", nil)
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			rr := httptest.NewRecorder()
+			server.Router().ServeHTTP(rr, req)
+
+			if rr.Code != tt.want {
+				t.Errorf("Expected status %d, got %d", tt.want, rr.Code)
+			}
+			if tt.want == http.StatusUnauthorized && rr.Header().Get("WWW-Authenticate") == "" {
+				t.Error("Expected WWW-Authenticate header on 401")
+			}
+		})
+	}
+
+	// Health checks stay public
+	rr := httptest.NewRecorder()
+	server.Router().ServeHTTP(rr, httptest.NewRequest("GET", "/healthz", nil))
+	if rr.Code != http.StatusOK {
+		t.Errorf("Expected /healthz to stay public, got %d", rr.Code)
+	}
+}
+
+func TestAuthDisabledWithoutTokens(t *testing.T) {
+	server := NewServer(NewMockStore(), Options{})
+
+	rr := httptest.NewRecorder()
+	server.Router().ServeHTTP(rr, httptest.NewRequest("GET", "/v1/targets", nil))
+	if rr.Code != http.StatusOK {
+		t.Errorf("Expected open API without tokens, got %d", rr.Code)
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	server := NewServer(NewMockStore(), Options{RateLimitRPS: 1, RateLimitBurst: 3})
+
+	send := func(path, forwardedFor string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		if forwardedFor != "" {
+			req.Header.Set("X-Forwarded-For", forwardedFor)
+		}
+		rr := httptest.NewRecorder()
+		server.Router().ServeHTTP(rr, req)
+		return rr
+	}
+
+	for i := 0; i < 3; i++ {
+		if rr := send("/v1/targets", "203.0.113.7"); rr.Code != http.StatusOK {
+			t.Fatalf("Request %d within burst: expected status 200, got %d", i+1, rr.Code)
+		}
+	}
+
+	rr := send("/v1/targets", "203.0.113.7, 10.0.0.1")
+	if rr.Code != http.StatusTooManyRequests {
+		t.Fatalf("Expected status 429 past the burst, got %d", rr.Code)
+	}
+	if rr.Header().Get("Retry-After") != "1" {
+		t.Errorf("Expected Retry-After: 1, got %q", rr.Header().Get("Retry-After"))
+	}
+
+	// Other clients and health checks are unaffected
+	if rr := send("/v1/targets", "198.51.100.1"); rr.Code != http.StatusOK {
+		t.Errorf("Expected another client to be allowed, got %d", rr.Code)
+	}
+	if rr := send("/healthz", "203.0.113.7"); rr.Code != http.StatusOK {
+		t.Errorf("Expected /healthz to skip the limiter, got %d", rr.Code)
+	}
+}
+
+func TestRateLimiterSweepsIdleClients(t *testing.T) {
+	l := newRateLimiter(1, 1)
+	now := time.Now()
+
+	l.allow("idle", now)
+	l.allow("active", now.Add(limiterIdleTTL))
+	l.allow("active", now.Add(limiterIdleTTL+limiterSweepInterval))
+
+	if _, ok := l.clients["idle"]; ok {
+		t.Error("Expected idle client to be swept")
+	}
+	if _, ok := l.clients["active"]; !ok {
+		t.Error("Expected active client to be kept")
+	}
+}
+
+func TestCreateTargetMaxURLLength(t *testing.T) {
+	server := NewServer(NewMockStore(), Options{MaxURLLength: 64})
+
+	post := func(url string) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(map[string]string{"url": url})
+		req := httptest.NewRequest("POST", "/v1/targets", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		rr := httptest.NewRecorder()
+		server.Router().ServeHTTP(rr, req)
+		return rr
+	}
+
+	prefix := "https://example.com/"
+	atLimit := prefix + strings.Repeat("a", 64-len(prefix))
+
+	if rr := post(atLimit); rr.Code != http.StatusCreated {
+		t.Errorf("URL at the limit: expected status 201, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	rr := post(atLimit + "b")
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("URL one byte over the limit: expected status 400, got %d", rr.Code)
+	}
+	if !strings.Contains(rr.Body.String(), "64 bytes") {
+		t.Errorf("Expected the limit in the error, got %s", rr.Body.String())
+	}
+}
+
+func TestRequestLogging(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, nil))
+	server := NewServer(NewMockStore(), Options{Logger: logger})
+
+	req := httptest.NewRequest("GET", "/v1/targets/t_missing", nil)
+	req.Header.Set("X-Request-Id", "req-123")
+	server.Router().ServeHTTP(httptest.NewRecorder(), req)
+
+	var entry map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("Expected one JSON log line, got %q: %v", buf.String(), err)
+	}
+	want := map[string]any{
+		"level":      "INFO",
+		"msg":        "request",
+		"request_id": "req-123",
+		"method":     "GET",
+		"path":       "/v1/targets/t_missing",
+		"status":     float64(http.StatusNotFound),
+	}
+	for key, value := range want {
+		if entry[key] != value {
+			t.Errorf("Log field %s = %v, want %v", key, entry[key], value)
+		}
+	}
+	if _, ok := entry["latency_ms"].(float64); !ok {
+		t.Errorf("Expected numeric latency_ms, got %v", entry["latency_ms"])
+	}
+}
+
+func TestCreateTargetRejectsBadBodies(t *testing.T) {
+	server := NewServer(NewMockStore(), Options{MaxRequestBytes: 128})
+
+	tests := []struct {
+		name   string
+		body   string
+		status int
+		error  string
+	}{
+		{"empty", "", http.StatusBadRequest, "request body is empty, expected a JSON object"},
+		{"unknown field", `{"url":"https://example.com","retentoin":"1h"}`, http.StatusBadRequest, `invalid JSON body: unknown field "retentoin"`},
+		{"trailing data", `{"url":"https://example.com"} {"url":"https://other.com"}`, http.StatusBadRequest, "invalid JSON body: body must contain a single JSON object"},
+		{"malformed", `{"url":`, http.StatusBadRequest, "invalid JSON body: unexpected EOF"},
+		{"oversized", `{"url":"https://example.com/` + strings.Repeat("a", 200) + `"}`, http.StatusRequestEntityTooLarge, "request body must not exceed 128 bytes"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/v1/targets", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			rr := httptest.NewRecorder()
+			server.Router().ServeHTTP(rr, req)
+
+			if rr.Code != tt.status {
+				t.Fatalf("Expected status %d, got %d: %s", tt.status, rr.Code, rr.Body.String())
+			}
+			var resp map[string]string
+			if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("Failed to parse error response: %v", err)
+			}
+			if resp["error"] != tt.error {
+				t.Errorf("Expected error %q, got %q", tt.error, resp["error"])
+			}
+		})
+	}
+}
+
+func TestCreateTargetFieldErrors(t *testing.T) {
+	server := NewServer(NewMockStore(), Options{MaxRetention: 24 * time.Hour})
+
+	tests := []struct {
+		name   string
+		body   string
+		fields map[string]string
+	}{
+		{"missing url", `{}`, map[string]string{"url": "url is required"}},
+		{"blank url", `{"url":"  "}`, map[string]string{"url": "url is required"}},
+		{"out of range", `{"url":"https://example.com","expected_status":600,"failure_threshold":0}`, map[string]string{
+			"expected_status":   "expected_status must be between 100 and 599",
+			"failure_threshold": "failure_threshold must be at least 1",
+		}},
+		{"every problem at once", `{"retention":"48h","method":"GET","request_body":"{}","match_mode":"absent"}`, map[string]string{
+			"url":          "url is required",
+			"retention":    "retention must not exceed 24h0m0s",
+			"request_body": "request_body requires method POST, PUT or PATCH",
+			"match_mode":   "match_mode requires match_pattern",
+		}},
+		{"invalid url", `{"url":"ftp://example.com"}`, map[string]string{"url": "invalid URL: unsupported scheme: ftp"}},
+		{"invalid maintenance", `{"url":"https://example.com","maintenance":{"start":"25:00","end":"02:00"}}`, map[string]string{
+			"maintenance": `maintenance: invalid schedule start "25:00", use HH:MM`,
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/v1/targets", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			rr := httptest.NewRecorder()
+			server.Router().ServeHTTP(rr, req)
+
+			if rr.Code != http.StatusBadRequest {
+				t.Fatalf("Expected status 400, got %d: %s", rr.Code, rr.Body.String())
+			}
+			var resp struct {
+				Error  string            `json:"error"`
+				Fields map[string]string `json:"fields"`
+			}
+			if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("Failed to parse error response: %v", err)
+			}
+			if !maps.Equal(resp.Fields, tt.fields) {
+				t.Errorf("Expected fields %v, got %v", tt.fields, resp.Fields)
+			}
+			for _, msg := range tt.fields {
+				if !strings.Contains(resp.Error, msg) {
+					t.Errorf("Expected error to include %q, got %q", msg, resp.Error)
+				}
+			}
+		})
+	}
+}
+
+func TestCreateTargetMaxTargets(t *testing.T) {
+	mockStore := NewMockStore()
+	server := NewServer(mockStore, Options{MaxTargets: 2})
+
+	post := func(target string) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(map[string]string{"url": target})
+		req := httptest.NewRequest("POST", "/v1/targets", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		rr := httptest.NewRecorder()
+		server.Router().ServeHTTP(rr, req)
+		return rr
+	}
+
+	mockStore.targets["t_a"] = &store.Target{ID: "t_a", URL: "https://a.example.com", Host: "a.example.com"}
+	if rr := post("https://b.example.com"); rr.Code != http.StatusCreated {
+		t.Fatalf("Expected the second target to be created, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	rr := post("https://c.example.com")
+	if rr.Code != http.StatusForbidden || !strings.Contains(rr.Body.String(), "at most 2 targets") {
+		t.Errorf("Expected 403 at the cap, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	// Existing URLs are still returned at the cap
+	rr = post("https://a.example.com")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected re-posting an existing URL to succeed, got %d: %s", rr.Code, rr.Body.String())
+	}
+	var target store.Target
+	if err := json.Unmarshal(rr.Body.Bytes(), &target); err != nil || target.ID != "t_a" {
+		t.Errorf("Expected the existing target t_a, got %+v (%v)", target, err)
+	}
+}
+
+// The cap against a real store: the create over it must be rolled back, not
+// just answered with 403
+func TestCreateTargetMaxTargetsSQLite(t *testing.T) {
+	db, err := sql.Open("sqlite", ":memory:")
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+	if err := store.RunMigrations(db, "../../migrations", true); err != nil {
+		t.Fatalf("Failed to run migrations: %v", err)
+	}
+	st := store.NewSQLiteStore(db)
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	server := NewServer(st, Options{MaxTargets: 2, Logger: logger})
+
+	post := func(target string) int {
+		body, _ := json.Marshal(map[string]string{"url": target})
+		req := httptest.NewRequest("POST", "/v1/targets", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		rr := httptest.NewRecorder()
+		server.Router().ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	for _, target := range []string{"https://a.example.com", "https://b.example.com"} {
+		if code := post(target); code != http.StatusCreated {
+			t.Fatalf("Expected %s to be created, got %d", target, code)
+		}
+	}
+
+	// Concurrent creates over the cap all fail and leave nothing behind
+	var wg sync.WaitGroup
+	codes := make([]int, 5)
+	for i := range codes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			codes[i] = post(fmt.Sprintf("https://%d.example.org", i))
+		}()
+	}
+	wg.Wait()
+	for i, code := range codes {
+		if code != http.StatusForbidden {
+			t.Errorf("Create %d over the cap: expected 403, got %d", i, code)
+		}
+	}
+
+	count, err := st.CountTargets(context.Background(), store.HostFilter{})
+	if err != nil {
+		t.Fatalf("CountTargets failed: %v", err)
+	}
+	if count != 2 {
+		t.Errorf("Expected the creates over the cap to be rolled back, leaving 2 targets, got %d", count)
+	}
+
+	if code := post("https://a.example.com"); code != http.StatusOK {
+		t.Errorf("Expected re-posting an existing URL at the cap to succeed, got %d", code)
+	}
+}
+
+func TestCreateTargetBlocksPrivateIPs(t *testing.T) {
+	server := NewServer(NewMockStore(), Options{BlockPrivateIPs: true})
+
+	post := func(target string) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(map[string]string{"url": target})
+		req := httptest.NewRequest("POST", "/v1/targets", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		rr := httptest.NewRecorder()
+		server.Router().ServeHTTP(rr, req)
+		return rr
+	}
+
+	for _, target := range []string{"http://169.254.169.254/latest/meta-data", "http://localhost:8080", "https://10.0.0.5", "http://[::1]/"} {
+		rr := post(target)
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected status 400, got %d", target, rr.Code)
+			continue
+		}
+		if !strings.Contains(rr.Body.String(), "private address") {
+			t.Errorf("%s: expected a private address error, got %s", target, rr.Body.String())
+		}
+	}
+
+	if rr := post("http://93.184.216.34/"); rr.Code != http.StatusCreated {
+		t.Errorf("Public address: expected status 201, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
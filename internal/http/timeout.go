@@ -0,0 +1,57 @@
+package http
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// timeoutBody is what a request that overran its bound gets, as writeError would send it
+const timeoutBody = `{"error":"request timed out"}` + "\n"
+
+// timeout bounds each request to d. The request context is cancelled at the
+// deadline, so store calls, which derive their own query timeouts from it,
+// stop with whichever bound comes first, and the client gets 503 at once even
+// if the handler is still unwinding. The response is buffered until the
+// handler returns, so streaming routes must not use it. It must wrap a routed
+// handler, not a mount, since the handler runs on with its own copy of the
+// route context after the 503.
+func (s *Server) timeout(d time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		h := http.TimeoutHandler(next, d, timeoutBody)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			h.ServeHTTP(timeoutWriter{w}, detachRouteContext(r))
+		})
+	}
+}
+
+// detachRouteContext gives r a copy of its chi route context. chi reuses the
+// original for another request as soon as ServeHTTP returns, which for a
+// request that timed out is while its handler may still read URL params.
+func detachRouteContext(r *http.Request) *http.Request {
+	rctx := chi.RouteContext(r.Context())
+	if rctx == nil {
+		return r
+	}
+	detached := *rctx
+	detached.URLParams.Keys = slices.Clone(rctx.URLParams.Keys)
+	detached.URLParams.Values = slices.Clone(rctx.URLParams.Values)
+	detached.RoutePatterns = slices.Clone(rctx.RoutePatterns)
+	return r.WithContext(context.WithValue(r.Context(), chi.RouteCtxKey, &detached))
+}
+
+// timeoutWriter marks http.TimeoutHandler's 503, which carries no headers of
+// its own, as JSON like every other error.
+type timeoutWriter struct {
+	http.ResponseWriter
+}
+
+func (w timeoutWriter) WriteHeader(status int) {
+	if status == http.StatusServiceUnavailable && w.Header().Get("Content-Type") == "" {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
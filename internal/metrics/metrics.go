@@ -0,0 +1,98 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Metrics holds the Prometheus collectors shared by the checker and the API.
+// A nil *Metrics is valid and records nothing.
+type Metrics struct {
+	registry *prometheus.Registry
+
+	ChecksTotal   prometheus.Counter
+	ChecksFailed  prometheus.Counter
+	CheckDuration prometheus.Histogram
+
+	RequestsTotal   *prometheus.CounterVec   // By method and status code
+	RequestDuration *prometheus.HistogramVec // By route pattern
+
+	NotificationsDropped *prometheus.CounterVec // By reason: queue_full or delivery_failed
+}
+
+// New creates the collectors and registers them with registry, which is
+// also what Handler serves. Tests pass a fresh registry per case.
+func New(registry *prometheus.Registry) *Metrics {
+	m := &Metrics{
+		registry: registry,
+		ChecksTotal: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "linkwatch_checks_total",
+			Help: "URL checks performed, including retries.",
+		}),
+		ChecksFailed: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "linkwatch_checks_failed_total",
+			Help: "URL checks that errored or got a status that doesn't count as up.",
+		}),
+		CheckDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "linkwatch_check_duration_seconds",
+			Help:    "Latency of URL checks.",
+			Buckets: prometheus.DefBuckets,
+		}),
+		RequestsTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "linkwatch_http_requests_total",
+			Help: "API requests served.",
+		}, []string{"method", "code"}),
+		RequestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "linkwatch_http_request_duration_seconds",
+			Help:    "Time spent serving API requests.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"route"}),
+		NotificationsDropped: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "linkwatch_notifications_dropped_total",
+			Help: "Webhook transitions never delivered.",
+		}, []string{"reason"}),
+	}
+
+	registry.MustRegister(m.ChecksTotal, m.ChecksFailed, m.CheckDuration, m.RequestsTotal, m.RequestDuration,
+		m.NotificationsDropped)
+	return m
+}
+
+// ObserveCheck records one check attempt.
+func (m *Metrics) ObserveCheck(latency time.Duration, failed bool) {
+	if m == nil {
+		return
+	}
+	m.ChecksTotal.Inc()
+	if failed {
+		m.ChecksFailed.Inc()
+	}
+	m.CheckDuration.Observe(latency.Seconds())
+}
+
+// ObserveRequest records one served API request. Route should be the matched
+// route pattern rather than the raw path to keep label cardinality bounded.
+func (m *Metrics) ObserveRequest(method, route string, status int, elapsed time.Duration) {
+	if m == nil {
+		return
+	}
+	m.RequestsTotal.WithLabelValues(method, strconv.Itoa(status)).Inc()
+	m.RequestDuration.WithLabelValues(route).Observe(elapsed.Seconds())
+}
+
+// ObserveNotificationDropped records one webhook transition given up on.
+func (m *Metrics) ObserveNotificationDropped(reason string) {
+	if m == nil {
+		return
+	}
+	m.NotificationsDropped.WithLabelValues(reason).Inc()
+}
+
+// Handler serves the registry in the Prometheus text format.
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
@@ -1,31 +1,80 @@
 package model
 
 import (
+	"errors"
 	"fmt"
 	"net/url"
 	"sort"
 	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/net/idna"
 )
 
 // Rules to apply during canonicalization:
-//   - Only http and https schemes allowed
+//   - Only absolute http and https URLs with a host allowed
 //   - Scheme and host lowercased
+//   - Internationalized hosts converted to punycode
 //   - Default ports removed
 //   - URL fragments  removed
+//   - Credentials (user:pass@) removed
+//   - Percent-encoding in the path normalized: unreserved characters
+//     decoded, everything else encoded with uppercase hex
 //   - Query parameters re sorted by key
 //   - Path normalized (removes empty values, trailing slashes if not root)
+//   - Root path dropped, so https://example.com/ becomes https://example.com
+//     (see RootPathStyle)
+//
+// Canonical URLs are fixed points: canonicalizing one again, with the same
+// options, returns it unchanged.
 func Canonicalize(raw string) (string, string, error) {
+	return CanonicalizeOptions{}.Canonicalize(raw)
+}
+
+// CanonicalizeOptions are opt-in rules on top of the defaults above.
+type CanonicalizeOptions struct {
+	// StripWWW drops a leading "www." label from the host, so www.example.com
+	// and example.com are one target. Only the first label is considered.
+	StripWWW bool
+
+	// RootPath is how a URL with no path beyond "/" is written. Zero means
+	// RootPathEmpty.
+	RootPath RootPathStyle
+}
+
+// RootPathStyle chooses the canonical form of the root path.
+type RootPathStyle string
+
+const (
+	// RootPathEmpty drops the root path: https://example.com and
+	// https://example.com?a=1, the default.
+	RootPathEmpty RootPathStyle = "empty"
+	// RootPathSlash keeps it: https://example.com/ and https://example.com/?a=1.
+	RootPathSlash RootPathStyle = "slash"
+)
+
+// Canonicalize is the package-level Canonicalize with these options applied.
+func (o CanonicalizeOptions) Canonicalize(raw string) (string, string, error) {
 	// Parse the input
 	parsed, err := url.Parse(raw)
 	if err != nil {
 		return "", "", fmt.Errorf("invalid URL: %w", err)
 	}
 
+	// url.Parse reads "example.com" as a relative path, so say what's missing
+	if !parsed.IsAbs() {
+		return "", "", errors.New("URL must be absolute, e.g. https://example.com")
+	}
+
 	// Only support HTTP and HTTPS
 	if parsed.Scheme != "http" && parsed.Scheme != "https" {
 		return "", "", fmt.Errorf("unsupported scheme: %s", parsed.Scheme)
 	}
 
+	if parsed.Hostname() == "" {
+		return "", "", errors.New("URL must include a host")
+	}
+
 	// Normalize scheme and host casing
 	parsed.Scheme = strings.ToLower(parsed.Scheme)
 	parsed.Host = strings.ToLower(parsed.Host)
@@ -38,15 +87,30 @@ func Canonicalize(raw string) (string, string, error) {
 		parsed.Host = parsed.Hostname()
 	}
 
+	// Store Unicode hosts in their ASCII form so both spellings match
+	if parsed.Host, err = asciiHost(parsed.Host); err != nil {
+		return "", "", err
+	}
+
+	if o.StripWWW {
+		parsed.Host = stripWWW(parsed.Host)
+	}
+
 	parsed.Fragment = ""
+	parsed.User = nil
 
 	// Sort query parameters
 	if parsed.RawQuery != "" {
 		parsed.RawQuery = sortQueryParams(parsed.RawQuery)
 	}
 
-	// Normalize path and remove trailing slashes
-	parsed.Path = normalizePath(parsed.Path)
+	// Normalize path and remove trailing slashes. Working on the escaped form
+	// keeps reserved octets like %2F from turning into path separators.
+	escapedPath := normalizePath(normalizeEscapes(parsed.EscapedPath()), o.RootPath)
+	if parsed.Path, err = url.PathUnescape(escapedPath); err != nil {
+		return "", "", fmt.Errorf("invalid URL path: %w", err)
+	}
+	parsed.RawPath = escapedPath
 
 	// Final canonical form
 	canonicalURL := parsed.String()
@@ -55,8 +119,103 @@ func Canonicalize(raw string) (string, string, error) {
 	return canonicalURL, host, nil
 }
 
-func normalizePath(path string) string {
+// asciiHost converts an internationalized host to punycode, keeping any port.
+// ASCII hosts are returned unchanged.
+func asciiHost(hostport string) (string, error) {
+	if isASCII(hostport) {
+		return hostport, nil
+	}
+
+	host, port := hostport, ""
+	if i := strings.LastIndex(hostport, ":"); i >= 0 {
+		host, port = hostport[:i], hostport[i:]
+	}
+
+	ascii, err := idna.Lookup.ToASCII(host)
+	if err != nil {
+		return "", fmt.Errorf("invalid internationalized host %q: %w", host, err)
+	}
+	return ascii + port, nil
+}
+
+// stripWWW removes a leading "www." unless what remains is a bare TLD,
+// as in www.com.
+func stripWWW(hostport string) string {
+	rest, ok := strings.CutPrefix(hostport, "www.")
+	if !ok {
+		return hostport
+	}
+	host := rest
+	if i := strings.LastIndex(rest, ":"); i >= 0 {
+		host = rest[:i]
+	}
+	if !strings.Contains(host, ".") {
+		return hostport
+	}
+	return rest
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+// normalizeEscapes decodes percent-encoded unreserved characters (RFC 3986
+// section 2.3) and uppercases the hex digits of the escapes that remain.
+func normalizeEscapes(escaped string) string {
+	var b strings.Builder
+	for i := 0; i < len(escaped); i++ {
+		if escaped[i] != '%' || i+2 >= len(escaped) {
+			b.WriteByte(escaped[i])
+			continue
+		}
+		octet, ok := unhex(escaped[i+1], escaped[i+2])
+		if !ok {
+			b.WriteByte(escaped[i])
+			continue
+		}
+		if isUnreserved(octet) {
+			b.WriteByte(octet)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", octet)
+		}
+		i += 2
+	}
+	return b.String()
+}
+
+func unhex(hi, lo byte) (byte, bool) {
+	h, ok1 := hexValue(hi)
+	l, ok2 := hexValue(lo)
+	return h<<4 | l, ok1 && ok2
+}
+
+func hexValue(c byte) (byte, bool) {
+	switch {
+	case '0' <= c && c <= '9':
+		return c - '0', true
+	case 'a' <= c && c <= 'f':
+		return c - 'a' + 10, true
+	case 'A' <= c && c <= 'F':
+		return c - 'A' + 10, true
+	}
+	return 0, false
+}
+
+func isUnreserved(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' ||
+		c == '-' || c == '.' || c == '_' || c == '~'
+}
+
+func normalizePath(path string, root RootPathStyle) string {
 	if path == "" || path == "/" {
+		if root == RootPathSlash {
+			return "/"
+		}
 		return ""
 	}
 
@@ -67,25 +226,18 @@ func normalizePath(path string) string {
 	return path
 }
 
+// sortQueryParams orders the query by key and, within a key, by value, so
+// repeated parameters canonicalize the same whatever order they came in.
+// Keys and values are re-encoded, e.g. a space as "+"; a query that won't
+// parse is kept as it is.
 func sortQueryParams(rawQuery string) string {
 	values, err := url.ParseQuery(rawQuery)
 	if err != nil {
 		return rawQuery
 	}
-
-	var keys []string
-	for k := range values {
-		keys = append(keys, k)
-	}
-	sort.Strings(keys)
-
-	// Rebuild query string with keys in order
-	var pairs []string
-	for _, k := range keys {
-		for _, v := range values[k] {
-			pairs = append(pairs, fmt.Sprintf("%s=%s", k, v))
-		}
+	for _, vs := range values {
+		sort.Strings(vs)
 	}
-
-	return strings.Join(pairs, "&")
+	// Encode sorts the keys
+	return values.Encode()
 }
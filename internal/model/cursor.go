@@ -1,17 +1,36 @@
 package model
 
 import (
+	"crypto/hmac"
+	"crypto/sha256"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"strings"
 	"time"
 )
 
+// MaxCursorLength is the longest cursor token we'll try to decode. Real tokens
+// are a few dozen bytes; anything near this limit is garbage or abuse.
+const MaxCursorLength = 1024
+
+// ErrCursorTooLong is returned for tokens over MaxCursorLength, before any
+// decoding work (and allocation) happens.
+var ErrCursorTooLong = errors.New("cursor token too long")
+
+// ErrCursorSignature is returned when a signed cursor's HMAC doesn't match,
+// meaning the token was altered or signed with a different secret.
+var ErrCursorSignature = errors.New("cursor signature mismatch")
+
 // Cursor represents where in a paginated list we left off.
 // It helps us know "start from here" when fetching the next page.
 type Cursor struct {
-	CreatedAt time.Time `json:"created_at"` // When the record was created
-	ID        string    `json:"id"`         // Unique ID of the record
+	CreatedAt time.Time `json:"created_at"`     // When the record was created
+	ID        string    `json:"id"`             // Unique ID of the record
+	Host      string    `json:"host,omitempty"` // Record's host, for listings sorted by it
+
+	NextCheckAt *time.Time `json:"next_check_at,omitempty"` // Record's next check, for listings sorted by it
 }
 
 // EncodeCursor turns a Cursor into a base64 string so it can be safely
@@ -51,6 +70,10 @@ func DecodeCursor(token string) (*Cursor, error) {
 		return nil, nil
 	}
 
+	if len(token) > MaxCursorLength {
+		return nil, ErrCursorTooLong
+	}
+
 	// Decode base64 string back to raw JSON
 	data, err := base64.URLEncoding.DecodeString(token)
 	if err != nil {
@@ -65,3 +88,44 @@ func DecodeCursor(token string) (*Cursor, error) {
 
 	return &cursor, nil
 }
+
+// EncodeCursorSigned is like EncodeCursor but appends an HMAC-SHA256 of the
+// payload, so clients can't forge positions. The token is "<payload>.<mac>".
+func EncodeCursorSigned(cursor *Cursor, secret []byte) (string, error) {
+	payload, err := EncodeCursor(cursor)
+	if err != nil || payload == "" {
+		return payload, err
+	}
+
+	return payload + "." + base64.URLEncoding.EncodeToString(cursorMAC(payload, secret)), nil
+}
+
+// DecodeCursorSigned verifies and decodes a token from EncodeCursorSigned.
+// Tokens that fail verification return ErrCursorSignature.
+func DecodeCursorSigned(token string, secret []byte) (*Cursor, error) {
+	if token == "" {
+		return nil, nil
+	}
+
+	if len(token) > MaxCursorLength {
+		return nil, ErrCursorTooLong
+	}
+
+	payload, sig, ok := strings.Cut(token, ".")
+	if !ok {
+		return nil, fmt.Errorf("invalid cursor token: missing signature")
+	}
+
+	mac, err := base64.URLEncoding.DecodeString(sig)
+	if err != nil || !hmac.Equal(mac, cursorMAC(payload, secret)) {
+		return nil, ErrCursorSignature
+	}
+
+	return DecodeCursor(payload)
+}
+
+func cursorMAC(payload string, secret []byte) []byte {
+	h := hmac.New(sha256.New, secret)
+	h.Write([]byte(payload))
+	return h.Sum(nil)
+}
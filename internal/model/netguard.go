@@ -0,0 +1,57 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"syscall"
+)
+
+// ErrPrivateAddress marks a host or connection refused by the private
+// address guard.
+var ErrPrivateAddress = errors.New("private address")
+
+// IsPrivateAddr reports whether addr is loopback, link-local, unspecified or
+// in a private range (RFC 1918, RFC 4193), i.e. somewhere checks must not be
+// pointed at when guarding against SSRF.
+func IsPrivateAddr(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	return addr.IsLoopback() || addr.IsPrivate() || addr.IsUnspecified() ||
+		addr.IsLinkLocalUnicast() || addr.IsLinkLocalMulticast()
+}
+
+// CheckPublicHost resolves host and fails with ErrPrivateAddress if any of
+// its addresses is private. A host that doesn't resolve passes, since there
+// is nothing to reach; connections are checked again when dialed.
+func CheckPublicHost(ctx context.Context, host string) error {
+	addrs, err := net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+	if err != nil {
+		return nil
+	}
+	for _, addr := range addrs {
+		if IsPrivateAddr(addr) {
+			return fmt.Errorf("host %s resolves to %w %s", host, ErrPrivateAddress, addr.Unmap())
+		}
+	}
+	return nil
+}
+
+// CheckPublicDial is a net.Dialer Control function refusing connections to
+// private addresses. Running after resolution, it also catches DNS
+// rebinding and redirects to internal hosts.
+func CheckPublicDial(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return err
+	}
+	if IsPrivateAddr(addr) {
+		return fmt.Errorf("refusing to connect to %w %s", ErrPrivateAddress, addr.Unmap())
+	}
+	return nil
+}
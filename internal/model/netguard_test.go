@@ -0,0 +1,58 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"net/netip"
+	"testing"
+)
+
+func TestIsPrivateAddr(t *testing.T) {
+	tests := map[string]bool{
+		"127.0.0.1":       true,
+		"10.1.2.3":        true,
+		"172.16.0.1":      true,
+		"192.168.1.1":     true,
+		"169.254.169.254": true,
+		"0.0.0.0":         true,
+		"::1":             true,
+		"fe80::1":         true,
+		"fd00::1":         true,
+		"::ffff:10.0.0.1": true,
+		"93.184.216.34":   false,
+		"8.8.8.8":         false,
+		"172.32.0.1":      false,
+		"2606:4700::1111": false,
+		"::ffff:8.8.8.8":  false,
+	}
+	for ip, want := range tests {
+		if got := IsPrivateAddr(netip.MustParseAddr(ip)); got != want {
+			t.Errorf("IsPrivateAddr(%s) = %t, want %t", ip, got, want)
+		}
+	}
+}
+
+func TestCheckPublicHost(t *testing.T) {
+	ctx := context.Background()
+
+	for _, host := range []string{"127.0.0.1", "169.254.169.254", "localhost"} {
+		if err := CheckPublicHost(ctx, host); !errors.Is(err, ErrPrivateAddress) {
+			t.Errorf("CheckPublicHost(%s) = %v, want ErrPrivateAddress", host, err)
+		}
+	}
+	if err := CheckPublicHost(ctx, "93.184.216.34"); err != nil {
+		t.Errorf("CheckPublicHost(93.184.216.34) = %v, want nil", err)
+	}
+}
+
+func TestCheckPublicDial(t *testing.T) {
+	if err := CheckPublicDial("tcp", "10.0.0.1:80", nil); !errors.Is(err, ErrPrivateAddress) {
+		t.Errorf("Expected ErrPrivateAddress dialing 10.0.0.1, got %v", err)
+	}
+	if err := CheckPublicDial("tcp", "[::1]:443", nil); !errors.Is(err, ErrPrivateAddress) {
+		t.Errorf("Expected ErrPrivateAddress dialing ::1, got %v", err)
+	}
+	if err := CheckPublicDial("tcp", "93.184.216.34:443", nil); err != nil {
+		t.Errorf("Expected public address to be allowed, got %v", err)
+	}
+}
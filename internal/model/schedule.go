@@ -0,0 +1,131 @@
+package model
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Schedule limits monitoring to a recurring daily window, e.g. business hours.
+// Outside the window the target simply isn't checked. The same window marks
+// a target's maintenance, when its checks don't notify.
+//
+// Example: Mon–Fri 08:00–18:00 Berlin time
+//
+//	{"days": ["mon","tue","wed","thu","fri"], "start": "08:00", "end": "18:00", "timezone": "Europe/Berlin"}
+type Schedule struct {
+	Days     []string `json:"days"`     // "mon".."sun" the window opens on; empty means every day
+	Start    string   `json:"start"`    // "HH:MM" local time the window opens
+	End      string   `json:"end"`      // "HH:MM" local time it closes; earlier than Start spans midnight
+	Timezone string   `json:"timezone"` // IANA zone name; empty means UTC
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// Validate checks the schedule is well-formed.
+func (s *Schedule) Validate() error {
+	for _, d := range s.Days {
+		if _, ok := weekdays[strings.ToLower(d)]; !ok {
+			return fmt.Errorf("invalid schedule day %q, use mon..sun", d)
+		}
+	}
+
+	start, err := parseClock(s.Start)
+	if err != nil || start == minutesPerDay {
+		return fmt.Errorf("invalid schedule start %q, use HH:MM", s.Start)
+	}
+	end, err := parseClock(s.End)
+	if err != nil || end == 0 {
+		return fmt.Errorf("invalid schedule end %q, use HH:MM", s.End)
+	}
+	if start == end {
+		return fmt.Errorf("schedule start and end must differ")
+	}
+
+	if _, err := loadLocation(s.Timezone); err != nil {
+		return fmt.Errorf("invalid schedule timezone %q", s.Timezone)
+	}
+	return nil
+}
+
+// Active reports whether now falls inside the schedule's window.
+// An invalid schedule is treated as always active so a bad value never
+// silently stops monitoring.
+func (s *Schedule) Active(now time.Time) bool {
+	loc, err := loadLocation(s.Timezone)
+	if err != nil {
+		return true
+	}
+	start, errStart := parseClock(s.Start)
+	end, errEnd := parseClock(s.End)
+	if errStart != nil || errEnd != nil {
+		return true
+	}
+
+	local := now.In(loc)
+	minute := local.Hour()*60 + local.Minute()
+
+	if start < end {
+		return s.onDay(local.Weekday()) && minute >= start && minute < end
+	}
+
+	// Overnight: the evening part belongs to today, the early morning part
+	// to the window that opened yesterday
+	if minute >= start {
+		return s.onDay(local.Weekday())
+	}
+	if minute < end {
+		return s.onDay((local.Weekday() + 6) % 7)
+	}
+	return false
+}
+
+func (s *Schedule) onDay(day time.Weekday) bool {
+	if len(s.Days) == 0 {
+		return true
+	}
+	for _, d := range s.Days {
+		if weekdays[strings.ToLower(d)] == day {
+			return true
+		}
+	}
+	return false
+}
+
+const minutesPerDay = 24 * 60
+
+// parseClock turns "HH:MM" into minutes since midnight. "24:00" is allowed
+// so a window can run to the end of the day.
+func parseClock(clock string) (int, error) {
+	var h, m int
+	if _, err := fmt.Sscanf(clock, "%d:%d", &h, &m); err != nil || len(clock) != 5 {
+		return 0, fmt.Errorf("invalid time %q", clock)
+	}
+	minutes := h*60 + m
+	if h < 0 || m < 0 || m > 59 || minutes > minutesPerDay {
+		return 0, fmt.Errorf("invalid time %q", clock)
+	}
+	return minutes, nil
+}
+
+// Locations are cached since the scheduler evaluates schedules every pass
+var locationCache sync.Map
+
+func loadLocation(name string) (*time.Location, error) {
+	if name == "" {
+		return time.UTC, nil
+	}
+	if loc, ok := locationCache.Load(name); ok {
+		return loc.(*time.Location), nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, err
+	}
+	locationCache.Store(name, loc)
+	return loc, nil
+}
@@ -0,0 +1,66 @@
+package model
+
+import (
+	"testing"
+	"time"
+)
+
+func TestScheduleActive(t *testing.T) {
+	business := &Schedule{
+		Days:     []string{"mon", "tue", "wed", "thu", "fri"},
+		Start:    "08:00",
+		End:      "18:00",
+		Timezone: "Europe/Berlin",
+	}
+	overnight := &Schedule{Days: []string{"fri"}, Start: "22:00", End: "06:00"}
+
+	tests := []struct {
+		name     string
+		schedule *Schedule
+		at       string
+		expected bool
+	}{
+		// 2024-01-15 is a Monday; Berlin is UTC+1 in January
+		{"weekday inside window", business, "2024-01-15T09:30:00Z", true},
+		{"weekday before window", business, "2024-01-15T06:30:00Z", false},
+		{"end is exclusive", business, "2024-01-15T17:00:00Z", false},
+		{"weekend", business, "2024-01-13T10:00:00Z", false},
+		{"overnight evening", overnight, "2024-01-19T23:00:00Z", true},
+		{"overnight next morning", overnight, "2024-01-20T05:00:00Z", true},
+		{"overnight after close", overnight, "2024-01-20T07:00:00Z", false},
+		{"overnight wrong day", overnight, "2024-01-18T23:00:00Z", false},
+	}
+
+	for _, test := range tests {
+		at, _ := time.Parse(time.RFC3339, test.at)
+		if got := test.schedule.Active(at); got != test.expected {
+			t.Errorf("%s: Active(%s) = %v, want %v", test.name, test.at, got, test.expected)
+		}
+	}
+}
+
+func TestScheduleValidate(t *testing.T) {
+	valid := []Schedule{
+		{Start: "08:00", End: "18:00"},
+		{Days: []string{"Sat", "sun"}, Start: "00:00", End: "24:00", Timezone: "America/New_York"},
+		{Start: "22:00", End: "06:00"},
+	}
+	for _, s := range valid {
+		if err := s.Validate(); err != nil {
+			t.Errorf("Validate(%+v) failed: %v", s, err)
+		}
+	}
+
+	invalid := []Schedule{
+		{Start: "8:00", End: "18:00"},
+		{Start: "08:00", End: "25:00"},
+		{Start: "08:00", End: "08:00"},
+		{Days: []string{"funday"}, Start: "08:00", End: "18:00"},
+		{Start: "08:00", End: "18:00", Timezone: "Mars/Olympus"},
+	}
+	for _, s := range invalid {
+		if err := s.Validate(); err == nil {
+			t.Errorf("Validate(%+v) succeeded, want error", s)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package model
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// StatusRange is an inclusive range of HTTP status codes.
+type StatusRange struct {
+	Min, Max int
+}
+
+// StatusRanges is a set of status codes, e.g. the ones a check succeeds with.
+type StatusRanges []StatusRange
+
+// DefaultSuccessStatuses is any 2xx or 3xx.
+var DefaultSuccessStatuses = StatusRanges{{200, 399}}
+
+// ParseStatusRanges parses comma-separated codes and ranges, e.g.
+// "200-299,301,302". Codes must lie within 100-599.
+func ParseStatusRanges(s string) (StatusRanges, error) {
+	var ranges StatusRanges
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		first, last, isRange := strings.Cut(part, "-")
+		if !isRange {
+			last = first
+		}
+		from, errFrom := strconv.Atoi(strings.TrimSpace(first))
+		to, errTo := strconv.Atoi(strings.TrimSpace(last))
+		if errFrom != nil || errTo != nil {
+			return nil, fmt.Errorf("invalid status range %q, use a code or FROM-TO", part)
+		}
+		if from < 100 || to > 599 || from > to {
+			return nil, fmt.Errorf("invalid status range %q, codes run from 100 to 599", part)
+		}
+		ranges = append(ranges, StatusRange{from, to})
+	}
+	return ranges, nil
+}
+
+// Contains reports whether code is in any of the ranges.
+func (r StatusRanges) Contains(code int) bool {
+	for _, sr := range r {
+		if code >= sr.Min && code <= sr.Max {
+			return true
+		}
+	}
+	return false
+}
+
+// String formats the ranges as ParseStatusRanges takes them.
+func (r StatusRanges) String() string {
+	parts := make([]string, len(r))
+	for i, sr := range r {
+		parts[i] = strconv.Itoa(sr.Min)
+		if sr.Max != sr.Min {
+			parts[i] += "-" + strconv.Itoa(sr.Max)
+		}
+	}
+	return strings.Join(parts, ",")
+}
@@ -0,0 +1,27 @@
+package model
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseStatusRanges(t *testing.T) {
+	ranges, err := ParseStatusRanges("200-299, 301,302")
+	if err != nil {
+		t.Fatalf("ParseStatusRanges failed: %v", err)
+	}
+	if got := ranges.String(); got != "200-299,301,302" {
+		t.Errorf("Expected the ranges to round-trip, got %s", got)
+	}
+	for code, want := range map[int]bool{199: false, 200: true, 299: true, 300: false, 301: true, 302: true, 304: false} {
+		if ranges.Contains(code) != want {
+			t.Errorf("Contains(%d) = %v, want %v", code, !want, want)
+		}
+	}
+
+	for _, bad := range []string{"", "2xx", "200-", "299-200", "99", "200-600", "200,,204"} {
+		if _, err := ParseStatusRanges(bad); err == nil || !strings.Contains(err.Error(), "invalid status range") {
+			t.Errorf("Expected %q to be rejected, got %v", bad, err)
+		}
+	}
+}
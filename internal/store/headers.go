@@ -0,0 +1,36 @@
+package store
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"golang.org/x/net/http/httpguts"
+)
+
+// Headers are extra request headers sent with every check of a target.
+// Values are often credentials, so String redacts them for logging.
+type Headers map[string]string
+
+// String lists header names only, e.g. "map[Authorization:<redacted>]".
+func (h Headers) String() string {
+	names := make([]string, 0, len(h))
+	for name := range h {
+		names = append(names, name+":<redacted>")
+	}
+	sort.Strings(names)
+	return "map[" + strings.Join(names, " ") + "]"
+}
+
+// Validate rejects header names and values net/http would refuse to send.
+func (h Headers) Validate() error {
+	for name, value := range h {
+		if !httpguts.ValidHeaderFieldName(name) {
+			return fmt.Errorf("invalid header name %q", name)
+		}
+		if !httpguts.ValidHeaderFieldValue(value) {
+			return fmt.Errorf("invalid value for header %q", name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package store
+
+import (
+	"crypto/rand"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// IDGenerator makes the unique part of new target IDs; the store adds the
+// t_ prefix. Implementations must be safe for concurrent use.
+type IDGenerator interface {
+	NewID() string
+}
+
+// UUIDGenerator makes random UUIDv4 IDs, the default.
+type UUIDGenerator struct{}
+
+func (UUIDGenerator) NewID() string { return uuid.NewString() }
+
+// ULIDGenerator makes ULIDs: a millisecond timestamp followed by randomness,
+// in Crockford base32, so IDs sort in creation order. IDs made within the
+// same millisecond, or while the clock steps back, increment the previous
+// one, so each is greater than the last.
+type ULIDGenerator struct {
+	mu   sync.Mutex
+	last [16]byte // Previous ID: 6 bytes of Unix milliseconds, 10 of entropy
+	now  func() time.Time
+}
+
+func NewULIDGenerator() *ULIDGenerator {
+	return &ULIDGenerator{now: time.Now}
+}
+
+func (g *ULIDGenerator) NewID() string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	ms := uint64(g.now().UnixMilli())
+	lastMs := uint64(g.last[0])<<40 | uint64(g.last[1])<<32 | uint64(g.last[2])<<24 |
+		uint64(g.last[3])<<16 | uint64(g.last[4])<<8 | uint64(g.last[5])
+	if ms > lastMs || !g.increment() {
+		ms = max(ms, lastMs+1) // The entropy ran out, borrow the next millisecond
+		_, _ = rand.Read(g.last[6:])
+		for i := range 6 {
+			g.last[i] = byte(ms >> (40 - 8*i))
+		}
+	}
+	return encodeULID(g.last)
+}
+
+// increment adds one to the entropy, reporting false if it overflowed
+func (g *ULIDGenerator) increment() bool {
+	for i := 15; i >= 6; i-- {
+		g.last[i]++
+		if g.last[i] != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// encodeULID writes the 128 bits as 26 base32 digits, most significant
+// first; the leading digit holds only 3 bits
+func encodeULID(id [16]byte) string {
+	var out [26]byte
+	var acc uint32
+	bits := 2 // Pad the front, as 26 digits hold 130 bits
+	n := 0
+	for _, b := range id {
+		acc = acc<<8 | uint32(b)
+		bits += 8
+		for bits >= 5 {
+			bits -= 5
+			out[n] = crockford[acc>>bits&31]
+			n++
+		}
+	}
+	return string(out[:])
+}
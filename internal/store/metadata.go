@@ -0,0 +1,98 @@
+package store
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+)
+
+// Known Metadata keys. Each has a typed accessor below.
+const (
+	MetaResolvedIPs   = "resolved_ips"   // []string of addresses the host resolved to
+	MetaRedirectChain = "redirect_chain" // []string of URLs followed after the first
+	MetaProtocol      = "protocol"       // Negotiated protocol, e.g. "HTTP/2.0"
+	MetaBodyTruncated = "body_truncated" // bool, set when the body exceeded the read cap
+)
+
+// Metadata holds check-type specific result fields as a JSON object, so new
+// check capabilities don't each need a column. It is stored as JSON text.
+type Metadata map[string]json.RawMessage
+
+func (m *Metadata) set(key string, v any) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return
+	}
+	if *m == nil {
+		*m = Metadata{}
+	}
+	(*m)[key] = data
+}
+
+func (m Metadata) get(key string, v any) bool {
+	raw, ok := m[key]
+	if !ok {
+		return false
+	}
+	return json.Unmarshal(raw, v) == nil
+}
+
+func (m *Metadata) SetResolvedIPs(ips []string) { m.set(MetaResolvedIPs, ips) }
+
+func (m Metadata) ResolvedIPs() []string {
+	var ips []string
+	m.get(MetaResolvedIPs, &ips)
+	return ips
+}
+
+func (m *Metadata) SetRedirectChain(urls []string) { m.set(MetaRedirectChain, urls) }
+
+func (m Metadata) RedirectChain() []string {
+	var urls []string
+	m.get(MetaRedirectChain, &urls)
+	return urls
+}
+
+func (m *Metadata) SetProtocol(proto string) { m.set(MetaProtocol, proto) }
+
+func (m Metadata) Protocol() string {
+	var proto string
+	m.get(MetaProtocol, &proto)
+	return proto
+}
+
+func (m *Metadata) SetBodyTruncated(truncated bool) { m.set(MetaBodyTruncated, truncated) }
+
+func (m Metadata) BodyTruncated() bool {
+	var truncated bool
+	m.get(MetaBodyTruncated, &truncated)
+	return truncated
+}
+
+// Value stores empty metadata as NULL and everything else as JSON text.
+func (m Metadata) Value() (driver.Value, error) {
+	if len(m) == 0 {
+		return nil, nil
+	}
+	data, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+func (m *Metadata) Scan(src any) error {
+	var data []byte
+	switch v := src.(type) {
+	case nil:
+		*m = nil
+		return nil
+	case string:
+		data = []byte(v)
+	case []byte:
+		data = v
+	default:
+		return fmt.Errorf("unsupported metadata type %T", src)
+	}
+	return json.Unmarshal(data, m)
+}
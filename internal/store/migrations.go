@@ -2,16 +2,93 @@
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
+	"log/slog"
 	"os"
 	"path/filepath"
 	"sort"
 	"strings"
 )
 
-// RunMigrations applies pending database migrations
-func RunMigrations(db *sql.DB, migrationsDir string) error {
-	if err := createMigrationsTable(db); err != nil {
+// ErrNoMigrations means the migrations directory exists but holds no .sql files,
+// usually a packaging mistake that leaves a fresh database without a schema.
+var ErrNoMigrations = errors.New("no migration files found")
+
+// ErrNoAppliedMigrations means there is nothing to roll back.
+var ErrNoAppliedMigrations = errors.New("no applied migrations")
+
+// ErrNoDownMigration means the latest migration has no .down.sql file, as is
+// the case for every migration written in the single-file style.
+var ErrNoDownMigration = errors.New("no down migration")
+
+// Forward migrations are either NNN_name.sql or NNN_name.up.sql; both record
+// the version NNN_name, so a migration can gain a down file without being
+// applied again.
+const (
+	upSuffix   = ".up.sql"
+	downSuffix = ".down.sql"
+)
+
+// migrationDialect holds the bookkeeping statements that differ per database
+type migrationDialect struct {
+	createTable   string
+	selectApplied string
+	insertApplied string
+	selectLatest  string
+	deleteApplied string
+}
+
+var sqliteMigrations = migrationDialect{
+	createTable: `
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version TEXT PRIMARY KEY,
+			applied_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		);`,
+	selectApplied: "SELECT COUNT(*) FROM schema_migrations WHERE version = ?",
+	insertApplied: "INSERT INTO schema_migrations (version) VALUES (?)",
+	selectLatest:  "SELECT version FROM schema_migrations ORDER BY applied_at DESC, version DESC LIMIT 1",
+	deleteApplied: "DELETE FROM schema_migrations WHERE version = ?",
+}
+
+var postgresMigrations = migrationDialect{
+	createTable: `
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version TEXT PRIMARY KEY,
+			applied_at TIMESTAMPTZ DEFAULT now()
+		);`,
+	selectApplied: "SELECT COUNT(*) FROM schema_migrations WHERE version = $1",
+	insertApplied: "INSERT INTO schema_migrations (version) VALUES ($1)",
+	selectLatest:  "SELECT version FROM schema_migrations ORDER BY applied_at DESC, version DESC LIMIT 1",
+	deleteApplied: "DELETE FROM schema_migrations WHERE version = $1",
+}
+
+// RunMigrations applies pending database migrations. An empty migrations
+// directory only logs a warning unless strict is set, in which case it
+// returns ErrNoMigrations.
+func RunMigrations(db *sql.DB, migrationsDir string, strict bool) error {
+	return runMigrations(db, migrationsDir, strict, sqliteMigrations)
+}
+
+// RunPostgresMigrations is RunMigrations for a PostgreSQL database, whose
+// migrations live in their own directory.
+func RunPostgresMigrations(db *sql.DB, migrationsDir string, strict bool) error {
+	return runMigrations(db, migrationsDir, strict, postgresMigrations)
+}
+
+// RollbackMigration reverts the most recently applied migration by running its
+// .down.sql file and forgetting the version, both in one transaction.
+func RollbackMigration(db *sql.DB, migrationsDir string) error {
+	return rollbackMigration(db, migrationsDir, sqliteMigrations)
+}
+
+// RollbackPostgresMigration is RollbackMigration for a PostgreSQL database.
+func RollbackPostgresMigration(db *sql.DB, migrationsDir string) error {
+	return rollbackMigration(db, migrationsDir, postgresMigrations)
+}
+
+func runMigrations(db *sql.DB, migrationsDir string, strict bool, dialect migrationDialect) error {
+	if err := createMigrationsTable(db, dialect); err != nil {
 		return fmt.Errorf("failed to create migrations table: %w", err)
 	}
 
@@ -20,31 +97,32 @@ func RunMigrations(db *sql.DB, migrationsDir string) error {
 		return fmt.Errorf("failed to find migration files: %w", err)
 	}
 
+	if len(migrationFiles) == 0 {
+		if strict {
+			return fmt.Errorf("%w in %s", ErrNoMigrations, migrationsDir)
+		}
+		slog.Warn("no migration files found, database schema may be missing", "dir", migrationsDir)
+	}
+
 	for _, filename := range migrationFiles {
-		migrationName := strings.TrimSuffix(filename, ".sql")
+		migrationName := migrationVersion(filename)
 
-		if hasBeenApplied(db, migrationName) {
+		if hasBeenApplied(db, dialect, migrationName) {
 			continue
 		}
 
-		if err := applyMigration(db, migrationsDir, filename, migrationName); err != nil {
+		if err := applyMigration(db, dialect, migrationsDir, filename, migrationName); err != nil {
 			return fmt.Errorf("failed to apply migration %s: %w", migrationName, err)
 		}
 
-		fmt.Printf("Applied migration: %s\n", migrationName)
+		slog.Info("applied migration", "name", migrationName)
 	}
 
 	return nil
 }
 
-func createMigrationsTable(db *sql.DB) error {
-	query := `
-		CREATE TABLE IF NOT EXISTS schema_migrations (
-			version TEXT PRIMARY KEY,
-			applied_at DATETIME DEFAULT CURRENT_TIMESTAMP
-		);`
-
-	_, err := db.Exec(query)
+func createMigrationsTable(db *sql.DB, dialect migrationDialect) error {
+	_, err := db.Exec(dialect.createTable)
 	return err
 }
 
@@ -61,25 +139,34 @@ func findMigrationFiles(migrationsDir string) ([]string, error) {
 			continue
 		}
 
-		if strings.HasSuffix(entry.Name(), ".sql") {
+		if strings.HasSuffix(entry.Name(), ".sql") && !strings.HasSuffix(entry.Name(), downSuffix) {
 			files = append(files, entry.Name())
 		}
 	}
 
-	sort.Strings(files)
+	sort.Slice(files, func(i, j int) bool {
+		return migrationVersion(files[i]) < migrationVersion(files[j])
+	})
 
 	return files, nil
 }
 
-func hasBeenApplied(db *sql.DB, migrationName string) bool {
+// migrationVersion is the name a forward migration file is recorded under
+func migrationVersion(filename string) string {
+	if strings.HasSuffix(filename, upSuffix) {
+		return strings.TrimSuffix(filename, upSuffix)
+	}
+	return strings.TrimSuffix(filename, ".sql")
+}
+
+func hasBeenApplied(db *sql.DB, dialect migrationDialect, migrationName string) bool {
 	var count int
-	query := "SELECT COUNT(*) FROM schema_migrations WHERE version = ?"
-	err := db.QueryRow(query, migrationName).Scan(&count)
+	err := db.QueryRow(dialect.selectApplied, migrationName).Scan(&count)
 
 	return err == nil && count > 0
 }
 
-func applyMigration(db *sql.DB, migrationsDir, filename, migrationName string) error {
+func applyMigration(db *sql.DB, dialect migrationDialect, migrationsDir, filename, migrationName string) error {
 	filePath := filepath.Join(migrationsDir, filename)
 	sqlContent, err := os.ReadFile(filePath)
 	if err != nil {
@@ -97,10 +184,54 @@ func applyMigration(db *sql.DB, migrationsDir, filename, migrationName string) e
 		return fmt.Errorf("failed to execute migration SQL: %w", err)
 	}
 
-	_, err = tx.Exec("INSERT INTO schema_migrations (version) VALUES (?)", migrationName)
+	_, err = tx.Exec(dialect.insertApplied, migrationName)
 	if err != nil {
 		return fmt.Errorf("failed to record migration: %w", err)
 	}
 
 	return tx.Commit()
 }
+
+func rollbackMigration(db *sql.DB, migrationsDir string, dialect migrationDialect) error {
+	if err := createMigrationsTable(db, dialect); err != nil {
+		return fmt.Errorf("failed to create migrations table: %w", err)
+	}
+
+	var migrationName string
+	err := db.QueryRow(dialect.selectLatest).Scan(&migrationName)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNoAppliedMigrations
+	}
+	if err != nil {
+		return fmt.Errorf("failed to find latest migration: %w", err)
+	}
+
+	sqlContent, err := os.ReadFile(filepath.Join(migrationsDir, migrationName+downSuffix))
+	if errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("%w for %s", ErrNoDownMigration, migrationName)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read down migration: %w", err)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to start transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(string(sqlContent)); err != nil {
+		return fmt.Errorf("failed to roll back migration %s: %w", migrationName, err)
+	}
+
+	if _, err := tx.Exec(dialect.deleteApplied, migrationName); err != nil {
+		return fmt.Errorf("failed to forget migration %s: %w", migrationName, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	slog.Info("rolled back migration", "name", migrationName)
+	return nil
+}
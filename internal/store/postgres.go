@@ -0,0 +1,69 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"strconv"
+	"strings"
+)
+
+// PostgresStore implements Store on PostgreSQL. Its schema (migrations/postgres)
+// mirrors SQLite's, timestamps included as RFC3339 text, so formatTime and
+// parseTime behave identically and the SQLite queries are reused as-is with
+// their ? placeholders rewritten to $1, $2, ...
+type PostgresStore struct {
+	*SQLiteStore
+}
+
+func NewPostgresStore(db *sql.DB) *PostgresStore {
+	s := &SQLiteStore{conn: db, postgres: true, ids: UUIDGenerator{}}
+	s.db = s.bind(db)
+	return &PostgresStore{SQLiteStore: s}
+}
+
+// bind wraps db so queries use the store's placeholder style
+func (s *SQLiteStore) bind(db dbtx) dbtx {
+	if s.postgres {
+		return postgresDB{db}
+	}
+	return db
+}
+
+// postgresDB rebinds every query before handing it to the underlying db
+type postgresDB struct {
+	db dbtx
+}
+
+func (p postgresDB) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	return p.db.ExecContext(ctx, rebind(query), args...)
+}
+
+func (p postgresDB) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	return p.db.QueryContext(ctx, rebind(query), args...)
+}
+
+func (p postgresDB) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	return p.db.QueryRowContext(ctx, rebind(query), args...)
+}
+
+// rebind numbers ? placeholders as $1, $2, ..., leaving quoted literals alone
+func rebind(query string) string {
+	var b strings.Builder
+	b.Grow(len(query) + 16)
+
+	n := 0
+	inQuote := false
+	for _, r := range query {
+		switch {
+		case r == '\'':
+			inQuote = !inQuote
+		case r == '?' && !inQuote:
+			n++
+			b.WriteByte('$')
+			b.WriteString(strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
@@ -0,0 +1,196 @@
+//go:build integration
+
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	_ "github.com/jackc/pgx/v5/stdlib"
+)
+
+// Run with a disposable database, whose tables are dropped first:
+//
+//	LINKWATCH_TEST_POSTGRES_URL=postgres://localhost/linkwatch_test go test -tags integration ./internal/store
+func setupPostgres(t *testing.T) *PostgresStore {
+	dsn := os.Getenv("LINKWATCH_TEST_POSTGRES_URL")
+	if dsn == "" {
+		t.Skip("LINKWATCH_TEST_POSTGRES_URL not set")
+	}
+
+	db, err := sql.Open("pgx", dsn)
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	_, err = db.Exec(`DROP TABLE IF EXISTS check_results, idempotency_keys, leases, targets, schema_migrations CASCADE`)
+	if err != nil {
+		t.Fatalf("Failed to reset database: %v", err)
+	}
+	if err := RunPostgresMigrations(db, "../../migrations/postgres", true); err != nil {
+		t.Fatalf("Failed to run migrations: %v", err)
+	}
+
+	return NewPostgresStore(db)
+}
+
+func TestPostgresTargetsAndResults(t *testing.T) {
+	st := setupPostgres(t)
+	ctx := context.Background()
+
+	expected := 401
+	target, created, err := st.UpsertTargetByURL(ctx, "https://example.com", "example.com",
+		TargetSettings{ExpectedStatus: &expected, Headers: Headers{"X-Api-Key": "k"}})
+	if err != nil || !created {
+		t.Fatalf("Failed to create target: created=%v err=%v", created, err)
+	}
+	if _, created, err := st.UpsertTargetByURL(ctx, "https://example.com", "example.com", TargetSettings{}); err != nil || created {
+		t.Fatalf("Expected existing target, created=%v err=%v", created, err)
+	}
+
+	got, err := st.GetTargetByID(ctx, target.ID)
+	if err != nil {
+		t.Fatalf("Failed to get target: %v", err)
+	}
+	if !got.CreatedAt.Equal(target.CreatedAt.Truncate(time.Second)) || got.Headers["X-Api-Key"] != "k" {
+		t.Errorf("Target did not round-trip, got %+v", got)
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	hash := "abc"
+	var lastID int64
+	for i, code := range []int{500, 401, 401} {
+		r := &CheckResult{TargetID: target.ID, CheckedAt: now.Add(time.Duration(i-2) * time.Minute),
+			StatusCode: &code, LatencyMs: 10 * (i + 1), BodyHash: &hash}
+		r.Metadata.SetProtocol("HTTP/2.0")
+		if err := st.InsertCheckResult(ctx, r); err != nil {
+			t.Fatalf("Failed to insert check result: %v", err)
+		}
+		if r.ID <= lastID {
+			t.Errorf("Expected increasing result IDs, got %d after %d", r.ID, lastID)
+		}
+		lastID = r.ID
+	}
+
+	page, cursor, err := st.GetResults(ctx, target.ID, time.Time{}, "", nil, 2)
+	if err != nil {
+		t.Fatalf("Failed to get results: %v", err)
+	}
+	if len(page) != 2 || cursor == nil || page[0].ID != lastID || page[0].Metadata.Protocol() != "HTTP/2.0" {
+		t.Fatalf("Unexpected first page %+v, cursor %+v", page, cursor)
+	}
+	rest, _, err := st.GetResults(ctx, target.ID, time.Time{}, "", cursor, 2)
+	if err != nil || len(rest) != 1 {
+		t.Fatalf("Expected 1 result on second page, got %d (err %v)", len(rest), err)
+	}
+
+	latest, err := st.GetLatestResults(ctx, []string{target.ID, "t_missing"})
+	if err != nil || len(latest) != 1 || latest[target.ID].ID != lastID {
+		t.Errorf("Unexpected latest results %+v (err %v)", latest, err)
+	}
+
+	sum, err := st.GetSummary(ctx, target.ID, now.Add(-time.Hour))
+	if err != nil {
+		t.Fatalf("Failed to get summary: %v", err)
+	}
+	if sum.TotalChecks != 3 || sum.SuccessfulChecks != 2 || sum.AvgLatencyMs == nil || *sum.AvgLatencyMs != 20 {
+		t.Errorf("Unexpected summary %+v", sum)
+	}
+
+	p, err := st.GetLatencyPercentiles(ctx, target.ID, now.Add(-time.Hour))
+	if err != nil || p.Count != 3 || p.P99 == nil || *p.P99 != 30 {
+		t.Errorf("Unexpected percentiles %+v (err %v)", p, err)
+	}
+
+	acked, err := st.AcknowledgeFailures(ctx, target.ID, now.Add(-time.Hour), now, "deploy")
+	if err != nil || acked != 1 {
+		t.Errorf("Expected 1 acknowledged failure, got %d (err %v)", acked, err)
+	}
+	counts, err := st.CountFailures(ctx, target.ID, now.Add(-time.Hour))
+	if err != nil || counts.Total != 1 || counts.Acknowledged != 1 {
+		t.Errorf("Unexpected failure counts %+v (err %v)", counts, err)
+	}
+
+	deleted, err := st.DeleteExpiredResults(ctx, now, 90*time.Second)
+	if err != nil || deleted != 1 {
+		t.Errorf("Expected 1 expired result deleted, got %d (err %v)", deleted, err)
+	}
+
+	if err := st.DeleteTarget(ctx, target.ID); err != nil {
+		t.Fatalf("Failed to delete target: %v", err)
+	}
+	if err := st.DeleteTarget(ctx, target.ID); !errors.Is(err, ErrTargetNotFound) {
+		t.Errorf("Expected ErrTargetNotFound, got %v", err)
+	}
+}
+
+func TestPostgresLeasesAndTransactions(t *testing.T) {
+	st := setupPostgres(t)
+	ctx := context.Background()
+
+	if ok, err := st.AcquireLease(ctx, "scheduler", "node-a", time.Minute); err != nil || !ok {
+		t.Fatalf("Expected node-a to acquire lease, ok=%v err=%v", ok, err)
+	}
+	if ok, err := st.AcquireLease(ctx, "scheduler", "node-b", time.Minute); err != nil || ok {
+		t.Errorf("Expected node-b to be refused, ok=%v err=%v", ok, err)
+	}
+	if err := st.ReleaseLease(ctx, "scheduler", "node-a"); err != nil {
+		t.Fatalf("Failed to release lease: %v", err)
+	}
+
+	boom := errors.New("boom")
+	err := st.WithTx(ctx, func(tx Store) error {
+		if _, _, err := tx.UpsertTargetByURL(ctx, "https://rolledback.com", "rolledback.com", TargetSettings{}); err != nil {
+			return err
+		}
+		return boom
+	})
+	if !errors.Is(err, boom) {
+		t.Fatalf("Expected closure error, got %v", err)
+	}
+
+	target, _, err := st.UpsertTargetByURL(ctx, "https://example.com", "example.com", TargetSettings{})
+	if err != nil {
+		t.Fatalf("Failed to create target: %v", err)
+	}
+	if _, stored, err := st.UpsertIdempotencyKey(ctx, "key-1", "hash", target.ID, 201, target, time.Now().Add(time.Hour)); err != nil || !stored {
+		t.Fatalf("Failed to store idempotency key: stored=%v err=%v", stored, err)
+	}
+	if _, found, err := st.GetIdempotencyKey(ctx, "key-1"); err != nil || !found {
+		t.Errorf("Expected idempotency key to be found, found=%v err=%v", found, err)
+	}
+
+	targets, _, err := st.GetTargets(ctx, HostFilter{}, OrderCreatedAt, nil, 10)
+	if err != nil || len(targets) != 1 || targets[0].ID != target.ID {
+		t.Errorf("Expected only the committed target, got %+v (err %v)", targets, err)
+	}
+}
+
+func TestPostgresClaimDueTargets(t *testing.T) {
+	assertDisjointClaims(t, setupPostgres(t))
+}
+
+func TestPostgresClaimStaleTargets(t *testing.T) {
+	st := setupPostgres(t)
+	ctx := context.Background()
+
+	if _, _, err := st.UpsertTargetByURL(ctx, "https://stale.example.com", "stale.example.com", TargetSettings{}); err != nil {
+		t.Fatalf("Failed to create target: %v", err)
+	}
+	// A cutoff past the target's creation makes it stale
+	now := time.Now()
+	for i, want := range []int{1, 0} {
+		targets, err := st.ClaimStaleTargets(ctx, now.Add(time.Minute), now, time.Minute, 10)
+		if err != nil {
+			t.Fatalf("ClaimStaleTargets failed: %v", err)
+		}
+		if len(targets) != want {
+			t.Errorf("Claim %d: expected %d targets, got %d", i+1, want, len(targets))
+		}
+	}
+}
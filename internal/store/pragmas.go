@@ -0,0 +1,80 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// SQLitePragmas tune SQLite for the workload. Empty and zero fields leave
+// SQLite's own default.
+type SQLitePragmas struct {
+	JournalMode string // e.g. "wal", so checks writing don't block API reads
+	Synchronous string // "off", "normal", "full" or "extra"
+	CacheSize   int    // Pages if positive, KiB if negative, as SQLite takes it
+}
+
+// synchronousLevels maps each synchronous setting to the number SQLite reports it as
+var synchronousLevels = map[string]int{"off": 0, "normal": 1, "full": 2, "extra": 3}
+
+// pragmas lists the set ones as name and value
+func (p SQLitePragmas) pragmas() [][2]string {
+	var out [][2]string
+	if p.JournalMode != "" {
+		out = append(out, [2]string{"journal_mode", p.JournalMode})
+	}
+	if p.Synchronous != "" {
+		out = append(out, [2]string{"synchronous", p.Synchronous})
+	}
+	if p.CacheSize != 0 {
+		out = append(out, [2]string{"cache_size", strconv.Itoa(p.CacheSize)})
+	}
+	return out
+}
+
+// DSN adds the pragmas to a SQLite DSN as _pragma parameters, which the
+// driver runs on every connection it opens; a pool-wide Exec would only
+// reach one. Pragmas the DSN already sets are left as given.
+func (p SQLitePragmas) DSN(dsn string) string {
+	for _, pragma := range p.pragmas() {
+		if setIn(dsn, pragma[0]) {
+			continue
+		}
+		sep := "?"
+		if strings.Contains(dsn, "?") {
+			sep = "&"
+		}
+		dsn += sep + "_pragma=" + pragma[0] + "(" + pragma[1] + ")"
+	}
+	return dsn
+}
+
+// setIn reports whether dsn sets the named pragma itself
+func setIn(dsn, name string) bool {
+	return strings.Contains(strings.ToLower(dsn), "_pragma="+name+"(")
+}
+
+// VerifyPragmas reads back the pragmas DSN added to dsn and reports the
+// first one SQLite didn't take, e.g. WAL on an in-memory database, which
+// stays "memory".
+func VerifyPragmas(ctx context.Context, db *sql.DB, dsn string, p SQLitePragmas) error {
+	for _, pragma := range p.pragmas() {
+		if setIn(dsn, pragma[0]) {
+			continue
+		}
+		var got string
+		if err := db.QueryRowContext(ctx, "PRAGMA "+pragma[0]).Scan(&got); err != nil {
+			return fmt.Errorf("read %s: %w", pragma[0], err)
+		}
+		want := strings.ToLower(pragma[1])
+		if level, ok := synchronousLevels[want]; ok && pragma[0] == "synchronous" {
+			want = strconv.Itoa(level)
+		}
+		if strings.ToLower(got) != want {
+			return fmt.Errorf("%s is %s, not %s", pragma[0], got, pragma[1])
+		}
+	}
+	return nil
+}
@@ -4,20 +4,61 @@ import (
 	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"math"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
 
-	"github.com/google/uuid"
+	"github.com/you/linkwatch/internal/model"
 )
 
+// ErrTargetNotFound is returned when a target ID doesn't exist
+var ErrTargetNotFound = errors.New("target not found")
+
+// ErrNoState means a target has no stored result yet, so no state either
+var ErrNoState = errors.New("target has not been checked")
+
+// ErrQueryTimeout marks an operation cut short by the store's query timeout,
+// rather than by the caller's own context
+var ErrQueryTimeout = errors.New("database query timed out")
+
 // Store defines all DB operations
 type Store interface {
-	UpsertTargetByURL(ctx context.Context, canonicalURL, host string) (*Target, bool, error)
-	GetTargets(ctx context.Context, hostFilter string, afterCreatedAt time.Time, afterID string, limit int) ([]*Target, *Cursor, error)
+	UpsertTargetByURL(ctx context.Context, canonicalURL, host string, settings TargetSettings) (*Target, bool, error)
+	GetTargetByID(ctx context.Context, id string) (*Target, error)
+	UpdateTarget(ctx context.Context, id string, update TargetUpdate) (*Target, error)
+	DeleteTarget(ctx context.Context, id string) error
+	GetTargets(ctx context.Context, hosts HostFilter, order TargetOrder, after *Cursor, limit int) ([]*Target, *Cursor, error)
+	GetEnabledTargets(ctx context.Context, afterCreatedAt time.Time, afterID string, limit int) ([]*Target, *Cursor, error)
+	GetTargetsVersion(ctx context.Context, hosts HostFilter) (*TargetsVersion, error)
+	CountTargets(ctx context.Context, hosts HostFilter) (int, error)
+	GetDistinctHosts(ctx context.Context) ([]string, error)
+	GetStaleTargets(ctx context.Context, checkedBefore time.Time, limit int) ([]*Target, error)
+	ClaimDueTargets(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]*Target, error)
+	ClaimStaleTargets(ctx context.Context, checkedBefore, now time.Time, lease time.Duration, limit int) ([]*Target, error)
 	InsertCheckResult(ctx context.Context, result *CheckResult) error
-	GetResults(ctx context.Context, targetID string, since time.Time, limit int) ([]*CheckResult, error)
-	UpsertIdempotencyKey(ctx context.Context, key, requestHash, targetID string, responseCode int, responseBody interface{}) (*IdempotencyResponse, bool, error)
+	InsertCheckResults(ctx context.Context, results []*CheckResult) error
+	GetState(ctx context.Context, targetID string) (*TargetState, error)
+	DeleteExpiredResults(ctx context.Context, now time.Time, defaultRetention time.Duration) (int64, error)
+	DeleteResultsBefore(ctx context.Context, cutoff time.Time) (int64, error)
+	GetResults(ctx context.Context, targetID string, since time.Time, nodeID string, after *ResultCursor, limit int) ([]*CheckResult, *ResultCursor, error)
+	GetResultsAfterID(ctx context.Context, afterID int64, targetID, host string, limit int) ([]*CheckResult, error)
+	GetLatestResults(ctx context.Context, targetIDs []string) (map[string]*CheckResult, error)
+	GetLatencyPercentiles(ctx context.Context, targetID string, since time.Time) (*LatencyPercentiles, error)
+	GetSummary(ctx context.Context, targetID string, since time.Time) (*Summary, error)
+	AcknowledgeFailures(ctx context.Context, targetID string, from, until time.Time, note string) (int64, error)
+	CountFailures(ctx context.Context, targetID string, since time.Time) (*FailureCounts, error)
+	UpsertIdempotencyKey(ctx context.Context, key, requestHash, targetID string, responseCode int, responseBody interface{}, expiresAt time.Time) (*IdempotencyResponse, bool, error)
 	GetIdempotencyKey(ctx context.Context, key string) (*IdempotencyResponse, bool, error)
+	RecanonicalizeTargets(ctx context.Context, canonicalize CanonicalizeFunc) (*RecanonicalizeReport, error)
+	AcquireLease(ctx context.Context, name, holder string, ttl time.Duration) (bool, error)
+	ReleaseLease(ctx context.Context, name, holder string) error
+	WithTx(ctx context.Context, fn func(Store) error) error
+	Ping(ctx context.Context) error
 }
 
 type Target struct {
@@ -25,102 +66,1047 @@ type Target struct {
 	URL       string    `json:"url"`
 	Host      string    `json:"host"`
 	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"` // Last change to its URL or settings
+	Enabled   bool      `json:"enabled"`    // Paused targets aren't checked
+
+	// When the scheduler is next due to check it: set by each scheduled
+	// check, or by claiming it with ClaimTargets. Nil until then.
+	NextCheckAt *time.Time `json:"next_check_at"`
+
+	TargetSettings
+}
+
+// TargetSettings are the per-target monitoring options chosen at creation.
+// Nil fields fall back to the global configuration.
+type TargetSettings struct {
+	Retention *Duration       `json:"retention"` // How long to keep this target's results
+	Schedule  *model.Schedule `json:"schedule"`  // Only check inside this window
+	Headers   Headers         `json:"headers"`   // Sent with every check
+
+	ExpectedStatus *int `json:"expected_status"` // The only status counting as up, instead of SUCCESS_STATUS_RANGES
+
+	MatchPattern *string `json:"match_pattern"` // Regex checked against the response body
+	MatchMode    string  `json:"match_mode"`    // MatchContains or MatchAbsent, set with MatchPattern
+
+	Proxy *string `json:"proxy"` // Proxy URL checks go through instead of the global one
+
+	InsecureSkipVerify bool `json:"insecure_skip_verify"` // Accept any certificate, e.g. a self-signed one
+
+	Method      string  `json:"method"`       // Checked with this method instead of CHECK_METHOD
+	RequestBody *string `json:"request_body"` // Sent with every check, set with a method taking a body
+
+	FailureThreshold *int `json:"failure_threshold"` // Consecutive failures before it is down, instead of FAILURE_THRESHOLD
+
+	Maintenance *model.Schedule `json:"maintenance"` // Checks inside this window are tagged in_maintenance and don't notify
+}
+
+// TargetUpdate changes some of a target's settings: only the ones named in
+// Fields, by JSON name, are written from Settings, so a nil value clears a
+// setting only when it is named. A non-nil Enabled pauses or resumes it.
+type TargetUpdate struct {
+	Settings TargetSettings
+	Fields   []string
+	Enabled  *bool
+}
+
+// targetSettingColumns maps each TargetSettings JSON name to its column
+var targetSettingColumns = []struct{ field, column string }{
+	{"retention", "retention_seconds"},
+	{"schedule", "schedule"},
+	{"headers", "headers"},
+	{"expected_status", "expected_status"},
+	{"match_pattern", "match_pattern"},
+	{"match_mode", "match_mode"},
+	{"proxy", "proxy_url"},
+	{"insecure_skip_verify", "insecure_skip_verify"},
+	{"method", "method"},
+	{"request_body", "request_body"},
+	{"failure_threshold", "failure_threshold"},
+	{"maintenance", "maintenance"},
+}
+
+// IsTargetSetting reports whether name is the JSON name of a TargetSettings field
+func IsTargetSetting(name string) bool {
+	for _, c := range targetSettingColumns {
+		if c.field == name {
+			return true
+		}
+	}
+	return false
+}
+
+// columnValue encodes the setting with the given JSON name for its column
+func (s *TargetSettings) columnValue(field string) (any, error) {
+	switch field {
+	case "retention":
+		return durationSeconds(s.Retention), nil
+	case "schedule":
+		v, err := nullableJSON(s.Schedule)
+		return v, err
+	case "headers":
+		if len(s.Headers) == 0 {
+			return nil, nil
+		}
+		v, err := nullableJSON(&s.Headers)
+		return v, err
+	case "expected_status":
+		return s.ExpectedStatus, nil
+	case "match_pattern":
+		return s.MatchPattern, nil
+	case "match_mode":
+		return nullableString(s.MatchMode), nil
+	case "proxy":
+		return s.Proxy, nil
+	case "insecure_skip_verify":
+		return boolInt(s.InsecureSkipVerify), nil
+	case "method":
+		return nullableString(s.Method), nil
+	case "request_body":
+		return s.RequestBody, nil
+	case "failure_threshold":
+		return s.FailureThreshold, nil
+	case "maintenance":
+		v, err := nullableJSON(s.Maintenance)
+		return v, err
+	}
+	return nil, fmt.Errorf("unknown target setting %q", field)
+}
+
+// Body assertion modes for TargetSettings.MatchMode
+const (
+	MatchContains = "contains" // The body must match MatchPattern
+	MatchAbsent   = "absent"   // The body must not match MatchPattern
+)
+
+// FieldError is a setting that failed validation. Field is its JSON name.
+type FieldError struct {
+	Field string
+	Err   error
+}
+
+func (e *FieldError) Error() string { return e.Err.Error() }
+func (e *FieldError) Unwrap() error { return e.Err }
+
+// BatchError reports the results of an InsertCheckResults batch that
+// weren't stored; the others were. Errs lines up with the batch, nil for
+// each result stored.
+type BatchError struct {
+	Errs []error
+}
+
+func (e *BatchError) Error() string {
+	failed := e.Unwrap()
+	if len(failed) == 0 {
+		return "no results failed"
+	}
+	return fmt.Sprintf("%d of %d results not stored, first: %v", len(failed), len(e.Errs), failed[0])
+}
+
+func (e *BatchError) Unwrap() []error {
+	var failed []error
+	for _, err := range e.Errs {
+		if err != nil {
+			failed = append(failed, err)
+		}
+	}
+	return failed
+}
+
+// ValidateMatch checks the body assertion settings, defaulting the mode to
+// MatchContains when a pattern is given. Failures are *FieldError.
+func (s *TargetSettings) ValidateMatch() error {
+	if s.MatchPattern == nil {
+		if s.MatchMode != "" {
+			return &FieldError{"match_mode", errors.New("match_mode requires match_pattern")}
+		}
+		return nil
+	}
+	if _, err := regexp.Compile(*s.MatchPattern); err != nil {
+		return &FieldError{"match_pattern", fmt.Errorf("invalid match_pattern: %w", err)}
+	}
+	switch s.MatchMode {
+	case "":
+		s.MatchMode = MatchContains
+	case MatchContains, MatchAbsent:
+	default:
+		return &FieldError{"match_mode", fmt.Errorf("match_mode must be %q or %q", MatchContains, MatchAbsent)}
+	}
+	return nil
+}
+
+// requestMethods are the methods a target may be checked with, mapped to
+// whether they take a request body
+var requestMethods = map[string]bool{
+	"GET":     false,
+	"HEAD":    false,
+	"OPTIONS": false,
+	"POST":    true,
+	"PUT":     true,
+	"PATCH":   true,
+}
+
+// ValidateRequest checks the method and request body settings, upper-casing
+// the method. Failures are *FieldError.
+func (s *TargetSettings) ValidateRequest() error {
+	s.Method = strings.ToUpper(s.Method)
+	takesBody, ok := requestMethods[s.Method]
+	if s.Method != "" && !ok {
+		return &FieldError{"method", errors.New("method must be one of GET, HEAD, OPTIONS, POST, PUT or PATCH")}
+	}
+	if s.RequestBody != nil && !takesBody {
+		return &FieldError{"request_body", errors.New("request_body requires method POST, PUT or PATCH")}
+	}
+	return nil
 }
 
 type CheckResult struct {
-	ID         int64     `json:"id"`
+	ID         int64     `json:"id"` // Increases with every stored row and is never reused; a deduplicated check takes its row's
 	TargetID   string    `json:"target_id"`
 	CheckedAt  time.Time `json:"checked_at"`
 	StatusCode *int      `json:"status_code"`
 	LatencyMs  int       `json:"latency_ms"`
 	Error      *string   `json:"error"`
+	NodeID     string    `json:"node_id"`
+
+	Acknowledged bool    `json:"acknowledged"`
+	AckNote      *string `json:"ack_note"`
+
+	Metadata Metadata `json:"metadata"`
+
+	Attempts int `json:"attempts"` // Tries made, including retries of transient failures
+
+	FinalURL      *string `json:"final_url"`      // URL that produced the response, nil on error
+	RedirectCount int     `json:"redirect_count"` // Redirects followed to get there
+
+	BodyHash    *string `json:"body_hash"`    // Hex SHA-256 of the (possibly truncated) body
+	BodyChanged bool    `json:"body_changed"` // Hash differs from the previous hashed result; set on reads
+
+	CertExpiresAt     *time.Time `json:"cert_expires_at"`     // Leaf certificate NotAfter, HTTPS only
+	CertDaysRemaining *int       `json:"cert_days_remaining"` // Whole days from the check until expiry
+
+	// Where the latency went, summed over redirects. Phases a reused
+	// connection skips are 0; nil on results recorded without timings.
+	DNSMs     *int `json:"dns_ms"`     // Resolving the host
+	ConnectMs *int `json:"connect_ms"` // TCP connect
+	TLSMs     *int `json:"tls_ms"`     // TLS handshake
+	TTFBMs    *int `json:"ttfb_ms"`    // From the request being sent to the first response byte
+
+	ContentType   *string `json:"content_type"`   // Content-Type header, nil when absent
+	ContentLength *int64  `json:"content_length"` // Content-Length, or the bytes read when it's missing
+
+	InMaintenance bool `json:"in_maintenance"` // Checked inside the target's maintenance window
+
+	// Identical consecutive checks saved with Dedup share a row: CheckedAt
+	// is the first of them and LastSeen the latest.
+	Occurrences int       `json:"occurrences"`
+	LastSeen    time.Time `json:"last_seen"`
+
+	// The target's state before and after this result, set when it is
+	// saved: empty before the target's first result, and the same when the
+	// result left it alone. Never stored with the result.
+	PreviousState string `json:"-"`
+	NewState      string `json:"-"`
+
+	// NextCheckAt, when set, is recorded as the target's next due time.
+	// Used when saving, never stored with the result.
+	NextCheckAt *time.Time `json:"-"`
+
+	// Dedup folds the result into the target's previous one when their
+	// status, error, body hash and maintenance flag match. Used when saving,
+	// never stored.
+	Dedup bool `json:"-"`
+}
+
+// Succeeded reports whether the check got the target's expected status, or
+// one of the success statuses when the target doesn't set one. Empty success
+// statuses mean model.DefaultSuccessStatuses.
+func (r *CheckResult) Succeeded(expectedStatus *int, success model.StatusRanges) bool {
+	if expectedStatus != nil {
+		success = model.StatusRanges{{Min: *expectedStatus, Max: *expectedStatus}}
+	}
+	return Classify(r.StatusCode, r.Error, success) == StateUp
+}
+
+// Target states derived from their latest result
+const (
+	StateUp      = "up"
+	StateDown    = "down"
+	StateUnknown = "unknown" // Not checked yet, or only failing below the threshold
+)
+
+// State classifies the result as StateUp or StateDown, as in Succeeded.
+func (r *CheckResult) State(expectedStatus *int, success model.StatusRanges) string {
+	if r.Succeeded(expectedStatus, success) {
+		return StateUp
+	}
+	return StateDown
+}
+
+// Classify is the rule every state, summary and notification follows: a
+// check is up when it got a response with one of the success statuses, and
+// down on an error or without a status. Empty success statuses mean
+// model.DefaultSuccessStatuses. failedResult mirrors it in SQL.
+func Classify(statusCode *int, err *string, success model.StatusRanges) string {
+	if len(success) == 0 {
+		success = model.DefaultSuccessStatuses
+	}
+	if err != nil || statusCode == nil || !success.Contains(*statusCode) {
+		return StateDown
+	}
+	return StateUp
+}
+
+// TargetState is a target's current state, kept up to date as results are
+// stored rather than derived from them.
+type TargetState struct {
+	TargetID    string    `json:"target_id"`
+	State       string    `json:"state"`        // StateUp, StateDown, or StateUnknown until either is reached
+	Since       time.Time `json:"since"`        // When the current state was first observed
+	LastChecked time.Time `json:"last_checked"` // The newest result's check time
+
+	Failures int `json:"consecutive_failures"` // Failed results since the last success
+}
+
+// FailureCounts splits a window's failed checks by acknowledgement.
+type FailureCounts struct {
+	Total          int `json:"total"`
+	Acknowledged   int `json:"acknowledged"`
+	Unacknowledged int `json:"unacknowledged"`
+}
+
+// TargetsVersion fingerprints the set of targets matching a listing filter so
+// clients can tell whether it changed without fetching it again.
+type TargetsVersion struct {
+	Count        int       // Targets matching the filter
+	LastModified time.Time // Newest created_at among them, zero when there are none
 }
 
+// LatencyPercentiles summarizes response latency over a window, in
+// milliseconds. Everything but Count is nil when the window holds no
+// responses.
+type LatencyPercentiles struct {
+	Count int  `json:"count"`
+	Min   *int `json:"min"`
+	Avg   *int `json:"avg"` // Rounded to the nearest millisecond
+	P50   *int `json:"p50"`
+	P90   *int `json:"p90"`
+	P95   *int `json:"p95"`
+	P99   *int `json:"p99"`
+	Max   *int `json:"max"`
+}
+
+// Summary is a target's availability over a window. Success honors the
+// target's expected status, as in CheckResult.Succeeded.
+type Summary struct {
+	TotalChecks      int      `json:"total_checks"`
+	SuccessfulChecks int      `json:"successful_checks"`
+	FailedChecks     int      `json:"failed_checks"`
+	UptimePercent    *float64 `json:"uptime_percent"` // Nil when there were no checks
+	AvgLatencyMs     *int     `json:"avg_latency_ms"` // Over checks that got a response
+	P95LatencyMs     *int     `json:"p95_latency_ms"`
+}
+
+// Cursor marks the last target of a page. It carries every sort key, so it
+// continues a listing in whichever TargetOrder is asked for.
 type Cursor struct {
-	CreatedAt time.Time `json:"created_at"`
-	ID        string    `json:"id"`
+	CreatedAt   time.Time  `json:"created_at"`
+	ID          string     `json:"id"`
+	Host        string     `json:"host"`
+	NextCheckAt *time.Time `json:"next_check_at"`
+}
+
+// TargetOrder is the order GetTargets lists targets in. Ties are broken by
+// ID, in the same direction.
+type TargetOrder string
+
+const (
+	OrderCreatedAt     TargetOrder = "created_at"    // Oldest first, the default
+	OrderCreatedAtDesc TargetOrder = "-created_at"   // Newest first
+	OrderHost          TargetOrder = "host"          // By host, alphabetically
+	OrderNextCheck     TargetOrder = "next_check_at" // Soonest due first, never-scheduled ones before all
+)
+
+// targetOrders holds each TargetOrder's keyset predicate, bound to the
+// cursor's key twice and then its ID, and the ORDER BY it pages along.
+var targetOrders = map[TargetOrder]struct {
+	after   string
+	orderBy string
+	key     func(*Cursor) string
+}{
+	OrderCreatedAt: {
+		"(created_at > ? OR (created_at = ? AND id > ?))", "created_at, id",
+		func(c *Cursor) string { return formatTime(c.CreatedAt) },
+	},
+	OrderCreatedAtDesc: {
+		"(created_at < ? OR (created_at = ? AND id < ?))", "created_at DESC, id DESC",
+		func(c *Cursor) string { return formatTime(c.CreatedAt) },
+	},
+	OrderHost: {
+		"(host > ? OR (host = ? AND id > ?))", "host, id",
+		func(c *Cursor) string { return c.Host },
+	},
+	OrderNextCheck: {
+		"(COALESCE(next_check_at, '') > ? OR (COALESCE(next_check_at, '') = ? AND id > ?))", "COALESCE(next_check_at, ''), id",
+		func(c *Cursor) string {
+			if c.NextCheckAt == nil {
+				return ""
+			}
+			return formatTime(c.NextCheckAt.UTC())
+		},
+	},
+}
+
+// Valid reports whether o is one of the supported orders.
+func (o TargetOrder) Valid() bool {
+	_, ok := targetOrders[o]
+	return ok
+}
+
+// HostFilter narrows a target listing by host. Every non-empty field must
+// match, so the zero value lists all targets.
+type HostFilter struct {
+	Host    string // Exactly this host
+	Prefix  string // Hosts starting with this, e.g. "example" for example.com and example.org
+	Pattern string // Hosts matching this, where * stands for any run of characters, e.g. "*.example.com"
+}
+
+// likeEscaper escapes LIKE's wildcards, and its escape character, so text
+// matches only itself
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// where returns the filter's conditions, each starting with AND, and their args
+func (f HostFilter) where() (string, []any) {
+	var where string
+	var args []any
+	if f.Host != "" {
+		where += " AND host = ?"
+		args = append(args, f.Host)
+	}
+	if f.Prefix != "" {
+		where += ` AND host LIKE ? ESCAPE '\'`
+		args = append(args, likeEscaper.Replace(f.Prefix)+"%")
+	}
+	if f.Pattern != "" {
+		where += ` AND host LIKE ? ESCAPE '\'`
+		args = append(args, strings.ReplaceAll(likeEscaper.Replace(f.Pattern), "*", "%"))
+	}
+	return where, args
+}
+
+// ResultCursor marks the last result of a page; results run newest first.
+type ResultCursor struct {
+	CheckedAt time.Time `json:"checked_at"`
+	ID        int64     `json:"id"`
+}
+
+// CanonicalizeFunc maps a stored URL to its canonical URL and host.
+type CanonicalizeFunc func(rawURL string) (canonicalURL, host string, err error)
+
+// RecanonicalizeReport describes what a re-canonicalization pass changed.
+type RecanonicalizeReport struct {
+	Scanned int `json:"scanned"` // Targets examined
+	Updated int `json:"updated"` // Targets whose URL or host was rewritten
+	Merged  int `json:"merged"`  // Duplicate targets folded into a survivor
+	Skipped int `json:"skipped"` // Targets whose URL no longer canonicalizes
 }
 
 type IdempotencyResponse struct {
 	ResponseCode int         `json:"response_code"`
 	ResponseBody interface{} `json:"response_body"`
+
+	RequestHash string `json:"-"` // Hash of the request first sent with the key
+}
+
+// dbtx is the query surface shared by *sql.DB and *sql.Tx
+type dbtx interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
 }
 
 type SQLiteStore struct {
-	db *sql.DB
+	db   dbtx    // The database, or the open transaction inside WithTx
+	conn *sql.DB // Nil when the store is bound to a transaction
+
+	postgres bool // Queries are rebound to $n placeholders (see PostgresStore)
+
+	queryTimeout time.Duration // Bounds each operation; zero leaves it to the caller's context
+	writeRetries int           // Extra tries for writes SQLite reports as busy or locked
+
+	success          model.StatusRanges // Statuses counting as up; empty means the default
+	failureThreshold int                // Failures taking a target down, unless it sets its own; below 1 means 1
+
+	ids IDGenerator // Makes new target IDs
 }
 
 func NewSQLiteStore(db *sql.DB) *SQLiteStore {
-	return &SQLiteStore{db: db}
+	return &SQLiteStore{db: db, conn: db, ids: UUIDGenerator{}}
+}
+
+// SetWriteRetries retries result inserts and upserts up to n more times,
+// with a short growing backoff, when SQLite reports the database busy or
+// locked past its busy_timeout. Other errors are returned straight away.
+func (s *SQLiteStore) SetWriteRetries(n int) {
+	s.writeRetries = n
+}
+
+// writeRetryBackoff is the wait before the first retry of a busy write,
+// doubling after each
+const writeRetryBackoff = 10 * time.Millisecond
+
+// retryBusy runs write, retrying it while SQLite reports the database busy
+// or locked. Inside a transaction a busy write can't be retried on its own,
+// so it is left to whoever retries the transaction.
+func (s *SQLiteStore) retryBusy(ctx context.Context, write func() error) error {
+	backoff := writeRetryBackoff
+	for attempt := 0; ; attempt++ {
+		err := write()
+		if err == nil || attempt >= s.writeRetries || s.conn == nil || s.postgres || !isBusy(err) {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// isBusy reports whether err is SQLITE_BUSY or SQLITE_LOCKED, including
+// their extended codes
+func isBusy(err error) bool {
+	var coded interface{ Code() int }
+	if !errors.As(err, &coded) {
+		return false
+	}
+	code := coded.Code() & 0xff
+	return code == 5 || code == 6 // SQLITE_BUSY, SQLITE_LOCKED
+}
+
+// SetSuccessStatuses sets the statuses a check counts as up with, for
+// targets without an expected_status, in stored states, summaries and
+// acknowledgements. Empty restores model.DefaultSuccessStatuses.
+func (s *SQLiteStore) SetSuccessStatuses(r model.StatusRanges) {
+	s.success = r
+}
+
+// SetFailureThreshold sets how many consecutive failed results take a
+// target's state down, for targets without their own failure_threshold.
+// Below 1 means 1.
+func (s *SQLiteStore) SetFailureThreshold(n int) {
+	s.failureThreshold = n
+}
+
+// classified fills the success statuses into a query using failedResult.
+// The ranges are validated integers, so they are safe to inline.
+func (s *SQLiteStore) classified(query string) string {
+	success := s.success
+	if len(success) == 0 {
+		success = model.DefaultSuccessStatuses
+	}
+	var single []string
+	var conds []string
+	for _, r := range success {
+		if r.Min == r.Max {
+			single = append(single, strconv.Itoa(r.Min))
+		} else {
+			conds = append(conds, fmt.Sprintf("status_code BETWEEN %d AND %d", r.Min, r.Max))
+		}
+	}
+	if len(single) > 0 {
+		conds = append(conds, "status_code IN ("+strings.Join(single, ", ")+")")
+	}
+	return strings.ReplaceAll(query, successStatuses, "("+strings.Join(conds, " OR ")+")")
+}
+
+// SetIDGenerator changes how new target IDs are made. Existing targets keep
+// theirs, so IDs of both kinds can coexist.
+func (s *SQLiteStore) SetIDGenerator(g IDGenerator) {
+	s.ids = g
+}
+
+// SetQueryTimeout bounds every store operation by d on top of the caller's
+// context, so a stalled database fails the operation with ErrQueryTimeout
+// instead of hanging it. Zero removes the bound.
+func (s *SQLiteStore) SetQueryTimeout(d time.Duration) {
+	s.queryTimeout = d
+}
+
+// withTimeout derives the context an operation's queries run under. The
+// returned done must be deferred with the operation's error, which it marks
+// with ErrQueryTimeout if the store's timeout is what cut it short.
+func (s *SQLiteStore) withTimeout(ctx context.Context) (context.Context, func(*error)) {
+	if s.queryTimeout <= 0 {
+		return ctx, func(*error) {}
+	}
+
+	ctx, cancel := context.WithTimeoutCause(ctx, s.queryTimeout, ErrQueryTimeout)
+	return ctx, func(err *error) {
+		if *err != nil && context.Cause(ctx) == ErrQueryTimeout && !errors.Is(*err, ErrQueryTimeout) {
+			*err = fmt.Errorf("%w after %v: %w", ErrQueryTimeout, s.queryTimeout, *err)
+		}
+		cancel()
+	}
+}
+
+// WithTx runs fn against a store bound to a single transaction, committing if fn
+// returns nil and rolling back otherwise. Nested calls join the outer transaction.
+func (s *SQLiteStore) WithTx(ctx context.Context, fn func(Store) error) error {
+	return s.inTx(ctx, func(tx *SQLiteStore) error { return fn(tx) })
+}
+
+func (s *SQLiteStore) inTx(ctx context.Context, fn func(*SQLiteStore) error) error {
+	if s.conn == nil {
+		return fn(s)
+	}
+
+	tx, err := s.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin transaction: %w", err)
+	}
+	// No-op once committed; also covers fn panicking
+	defer tx.Rollback()
+
+	if err := fn(&SQLiteStore{db: s.bind(tx), postgres: s.postgres, queryTimeout: s.queryTimeout,
+		writeRetries: s.writeRetries, success: s.success, failureThreshold: s.failureThreshold, ids: s.ids}); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit transaction: %w", err)
+	}
+	return nil
 }
 
 func formatTime(t time.Time) string {
 	return t.Format(time.RFC3339)
 }
 
+// formatTimePtr formats an optional time, keeping nil as NULL
+func formatTimePtr(t *time.Time) *string {
+	if t == nil {
+		return nil
+	}
+	s := formatTime(*t)
+	return &s
+}
+
 func parseTime(s string) time.Time {
 	t, _ := time.Parse(time.RFC3339, s)
 	return t
 }
 
 const (
+	// targetColumns must stay in sync with scanTarget
+	targetColumns = `id, url, host, created_at, retention_seconds, schedule, headers, expected_status, match_pattern, match_mode, proxy_url, insecure_skip_verify, method, request_body, failure_threshold, enabled, updated_at, maintenance, next_check_at`
+
 	qSelectTargetByURL = `
-		SELECT id, url, host, created_at
+		SELECT ` + targetColumns + `
 		FROM targets
 		WHERE url = ?`
 
+	qSelectTargetByID = `
+		SELECT ` + targetColumns + `
+		FROM targets
+		WHERE id = ?`
+
 	qInsertTarget = `
-		INSERT INTO targets (id, url, host, created_at)
-		VALUES (?, ?, ?, ?)`
+		INSERT INTO targets (id, url, host, created_at, retention_seconds, schedule, headers, expected_status, match_pattern, match_mode, proxy_url, insecure_skip_verify, method, request_body, failure_threshold, updated_at, maintenance)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
 
 	qSelectTargetsBase = `
-		SELECT id, url, host, created_at
+		SELECT ` + targetColumns + `
+		FROM targets
+		WHERE 1=1`
+
+	qSelectDistinctHosts = `
+		SELECT DISTINCT host
+		FROM targets
+		ORDER BY host`
+
+	qCountTargets = `
+		SELECT COUNT(*)
 		FROM targets
 		WHERE 1=1`
 
+	qSelectTargetsVersion = `
+		SELECT COUNT(*), COALESCE(MAX(created_at), '')
+		FROM targets
+		WHERE 1=1`
+
+	// Targets older than the cutoff with no result since the cutoff. A
+	// deduplicated result counts as checked when it was last seen.
+	qSelectStaleTargets = `
+		SELECT ` + targetColumns + `
+		FROM targets t
+		WHERE t.created_at < ?
+		  AND t.enabled = 1
+		  AND NOT EXISTS (
+			SELECT 1 FROM check_results r
+			WHERE r.target_id = t.id AND COALESCE(r.last_seen, r.checked_at) >= ?
+		  )
+		ORDER BY created_at, id
+		LIMIT ?`
+
+	qSetNextCheck = `
+		UPDATE targets
+		SET next_check_at = ?
+		WHERE id = ?`
+
+	// The due condition is repeated outside the subquery so a row another
+	// scheduler claimed meanwhile is re-checked and skipped, not claimed twice.
+	// COALESCE sorts never-claimed targets first on both databases.
+	qClaimDueTargets = `
+		UPDATE targets
+		SET next_check_at = ?
+		WHERE id IN (
+			SELECT id FROM targets
+			WHERE enabled = 1 AND (next_check_at IS NULL OR next_check_at <= ?)
+			ORDER BY COALESCE(next_check_at, ''), id
+			LIMIT ?
+		)
+		  AND (next_check_at IS NULL OR next_check_at <= ?)
+		RETURNING ` + targetColumns
+
+	// Stale targets as in qSelectStaleTargets that are also due, claimed as
+	// qClaimDueTargets claims them
+	qClaimStaleTargets = `
+		UPDATE targets
+		SET next_check_at = ?
+		WHERE id IN (
+			SELECT t.id FROM targets t
+			WHERE t.created_at < ?
+			  AND t.enabled = 1
+			  AND (t.next_check_at IS NULL OR t.next_check_at <= ?)
+			  AND NOT EXISTS (
+				SELECT 1 FROM check_results r
+				WHERE r.target_id = t.id AND COALESCE(r.last_seen, r.checked_at) >= ?
+			  )
+			ORDER BY t.created_at, t.id
+			LIMIT ?
+		)
+		  AND (next_check_at IS NULL OR next_check_at <= ?)
+		RETURNING ` + targetColumns
+
+	qSelectRetentionPolicies = `
+		SELECT DISTINCT retention_seconds
+		FROM targets
+		WHERE retention_seconds IS NOT NULL`
+
+	// Both delete at most pruneBatchSize rows per statement, passed last. A
+	// deduplicated result is only as old as the last check it stands for.
+	qDeleteResultsForPolicy = `
+		DELETE FROM check_results
+		WHERE id IN (
+			SELECT id FROM check_results
+			WHERE ` + resultSeen + ` < ?
+			  AND target_id IN (SELECT id FROM targets WHERE retention_seconds = ?)
+			LIMIT ?
+		)`
+
+	qDeleteResultsForDefaultPolicy = `
+		DELETE FROM check_results
+		WHERE id IN (
+			SELECT id FROM check_results
+			WHERE ` + resultSeen + ` < ?
+			  AND target_id IN (SELECT id FROM targets WHERE retention_seconds IS NULL)
+			LIMIT ?
+		)`
+
 	qInsertCheckResult = `
-		INSERT INTO check_results (target_id, checked_at, status_code, latency_ms, error)
-		VALUES (?, ?, ?, ?, ?)`
+		INSERT INTO check_results (target_id, checked_at, status_code, latency_ms, error, node_id, metadata, attempts,
+			final_url, redirect_count, body_hash, cert_expires_at, cert_days_remaining,
+			dns_ms, connect_ms, tls_ms, ttfb_ms, content_type, content_length, last_seen, in_maintenance)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		RETURNING id`
+
+	// qDedupCheckResult counts a check on the target's latest result if it
+	// matches, and only if the check isn't older than what the row has seen.
+	// Bound to the check time, target ID, status, error, body hash,
+	// maintenance flag and the check time again.
+	qDedupCheckResult = `
+		UPDATE check_results
+		SET occurrences = occurrences + 1, last_seen = ?
+		WHERE id = (SELECT MAX(id) FROM check_results WHERE target_id = ?)
+		  AND status_code IS NOT DISTINCT FROM ? AND error IS NOT DISTINCT FROM ?
+		  AND body_hash IS NOT DISTINCT FROM ? AND in_maintenance = ? AND COALESCE(last_seen, checked_at) <= ?
+		RETURNING id, occurrences`
+
+	// resultColumns must stay in sync with scanResult. Queries using it must
+	// select from check_results unaliased, as bodyChanged refers to it by name.
+	resultColumns = `id, target_id, checked_at, status_code, latency_ms, error, COALESCE(node_id, ''),
+		acknowledged, ack_note, metadata, attempts, final_url, redirect_count, body_hash,
+		cert_expires_at, cert_days_remaining, dns_ms, connect_ms, tls_ms, ttfb_ms, content_type, content_length,
+		occurrences, last_seen, in_maintenance, ` + bodyChanged
+
+	// bodyChanged compares a result's body hash with the target's previous
+	// hashed result; checks without a body don't count as a change.
+	// CASE keeps it an integer, as Postgres won't COALESCE a boolean with 0.
+	bodyChanged = `COALESCE((
+			SELECT CASE WHEN prev.body_hash <> check_results.body_hash THEN 1 ELSE 0 END
+			FROM check_results prev
+			WHERE prev.target_id = check_results.target_id AND prev.body_hash IS NOT NULL
+			  AND (prev.checked_at < check_results.checked_at
+			       OR (prev.checked_at = check_results.checked_at AND prev.id < check_results.id))
+			ORDER BY prev.checked_at DESC, prev.id DESC
+			LIMIT 1
+		), 0)`
+
+	// failedResult mirrors CheckResult.Succeeded: anything but the target's
+	// expected status, or a clean success status when it has none. The
+	// comparison is NULL without an expected status, so COALESCE falls back
+	// to the success statuses. Queries using it must go through classified.
+	failedResult = `(error IS NOT NULL OR status_code IS NULL OR COALESCE(
+		status_code <> (SELECT expected_status FROM targets WHERE targets.id = target_id),
+		NOT ` + successStatuses + `))`
 
-	qSelectResults = `
-		SELECT id, target_id, checked_at, status_code, latency_ms, error
+	// successStatuses stands in for the store's success statuses in SQL
+	successStatuses = "{success_statuses}"
+
+	// The target's own failure threshold, else the store's bound to the
+	// placeholder, for the result being inserted and the state it updates
+	firstThreshold   = `COALESCE((SELECT failure_threshold FROM targets WHERE targets.id = check_results.target_id), ?)`
+	updatedThreshold = `COALESCE((SELECT failure_threshold FROM targets WHERE targets.id = target_state.target_id), ?)`
+
+	// upsertedState is the state after a newer result: up on a success,
+	// down once the failures reach the threshold, and otherwise unchanged.
+	// excluded.failures is 1 for a failed result, 0 for a success.
+	upsertedState = `CASE WHEN excluded.failures = 0 THEN 'up'
+			WHEN target_state.failures + 1 >= ` + updatedThreshold + ` THEN 'down'
+			ELSE target_state.state END`
+
+	// A target's first result makes it up or, failing, down once that
+	// reaches the threshold and unknown before. A newer result of the same
+	// state keeps since; a change of state moves it to the result's check
+	// time. Results older than the stored state, e.g. a slow check finishing
+	// late, leave it alone and return no row. Bound to the default failure
+	// threshold, the result ID, and the threshold twice more. A
+	// deduplicated result counts as checked when it was last seen.
+	qUpsertTargetState = `
+		INSERT INTO target_state (target_id, state, since, last_checked, failures)
+		SELECT target_id, CASE WHEN NOT ` + failedResult + ` THEN 'up'
+				WHEN ` + firstThreshold + ` <= 1 THEN 'down' ELSE 'unknown' END,
+			COALESCE(last_seen, checked_at), COALESCE(last_seen, checked_at),
+			CASE WHEN ` + failedResult + ` THEN 1 ELSE 0 END
 		FROM check_results
-		WHERE target_id = ? AND checked_at >= ?
-		ORDER BY checked_at DESC
-		LIMIT ?`
+		WHERE id = ?
+		ON CONFLICT (target_id) DO UPDATE SET
+			since = CASE WHEN target_state.state = ` + upsertedState + ` THEN target_state.since ELSE excluded.since END,
+			state = ` + upsertedState + `,
+			failures = CASE WHEN excluded.failures = 0 THEN 0 ELSE target_state.failures + 1 END,
+			last_checked = excluded.last_checked
+		WHERE target_state.last_checked <= excluded.last_checked
+		RETURNING state`
+
+	qSelectState = `
+		SELECT state
+		FROM target_state
+		WHERE target_id = ?`
+
+	qSelectTargetState = `
+		SELECT target_id, state, since, last_checked, failures
+		FROM target_state
+		WHERE target_id = ?`
+
+	qDeleteTargetState = `
+		DELETE FROM target_state
+		WHERE target_id = ?`
+
+	qSelectResultsAfterID = `
+		SELECT ` + resultColumns + `
+		FROM check_results
+		WHERE id > ?`
+
+	qSelectResultsBase = `
+		SELECT ` + resultColumns + `
+		FROM check_results
+		WHERE target_id = ? AND checked_at >= ?`
+
+	// The newest result of each listed target, found through the
+	// (target_id, checked_at) index rather than scanning their history.
+	// The %s is filled with one placeholder per target ID.
+	qSelectLatestResults = `
+		SELECT ` + resultColumns + `
+		FROM check_results
+		WHERE id IN (
+			SELECT (
+				SELECT r.id FROM check_results r
+				WHERE r.target_id = t.id
+				ORDER BY r.checked_at DESC, r.id DESC
+				LIMIT 1
+			)
+			FROM targets t
+			WHERE t.id IN (%s)
+		)`
+
+	// Nearest-rank percentiles: the p-th percentile is the value at rank
+	// ceil(n*p/100), computed with integer math since SQLite has no CEIL.
+	// Each row counts once per check it stands for, so the value at a rank
+	// is the first whose running total of occurrences reaches it.
+	// Failed checks (no status code) are excluded as they carry no response time.
+	qSelectLatencyPercentiles = `
+		WITH ranked AS (
+			SELECT latency_ms, occurrences,
+			       SUM(occurrences) OVER (ORDER BY latency_ms, id ROWS UNBOUNDED PRECEDING) AS cum,
+			       SUM(occurrences) OVER () AS n
+			FROM check_results
+			WHERE target_id = ? AND ` + resultSeen + ` >= ? AND status_code IS NOT NULL
+		)
+		SELECT COALESCE(SUM(occurrences), 0),
+		       MIN(latency_ms),
+		       ` + weightedAvgLatency + `,
+		       MIN(CASE WHEN cum >= (n * 50 + 99) / 100 THEN latency_ms END),
+		       MIN(CASE WHEN cum >= (n * 90 + 99) / 100 THEN latency_ms END),
+		       MIN(CASE WHEN cum >= (n * 95 + 99) / 100 THEN latency_ms END),
+		       MIN(CASE WHEN cum >= (n * 99 + 99) / 100 THEN latency_ms END),
+		       MAX(latency_ms)
+		FROM ranked`
+
+	// resultSeen is when a result was last checked, which for a
+	// deduplicated one is its last occurrence rather than its first. Windows
+	// include a result seen in them even if it was first seen before.
+	resultSeen = "COALESCE(last_seen, checked_at)"
+
+	// weightedAvgLatency averages latency_ms over checks rather than rows
+	weightedAvgLatency = "CAST(ROUND(SUM(latency_ms * occurrences) * 1.0 / SUM(occurrences)) AS INTEGER)"
 
+	// Counts, mean and nearest-rank p95 latency in one pass over the window.
+	// Latency only considers checks that got a response, as above.
+	qSelectSummary = `
+		WITH recent AS (
+			SELECT id, target_id, status_code, latency_ms, error, occurrences
+			FROM check_results
+			WHERE target_id = ? AND ` + resultSeen + ` >= ?
+		),
+		ranked AS (
+			SELECT latency_ms,
+			       SUM(occurrences) OVER (ORDER BY latency_ms, id ROWS UNBOUNDED PRECEDING) AS cum,
+			       SUM(occurrences) OVER () AS n
+			FROM recent
+			WHERE status_code IS NOT NULL
+		)
+		SELECT (SELECT COALESCE(SUM(occurrences), 0) FROM recent),
+		       (SELECT COALESCE(SUM(occurrences), 0) FROM recent WHERE NOT ` + failedResult + `),
+		       (SELECT ` + weightedAvgLatency + ` FROM recent WHERE status_code IS NOT NULL),
+		       (SELECT MIN(latency_ms) FROM ranked WHERE cum >= (n * 95 + 99) / 100)`
+
+	// A deduplicated failure is acknowledged if any check it stands for
+	// falls in the range
+	qAcknowledgeFailures = `
+		UPDATE check_results
+		SET acknowledged = 1, ack_note = ?
+		WHERE target_id = ? AND ` + resultSeen + ` >= ? AND checked_at <= ?
+		  AND acknowledged = 0 AND ` + failedResult
+
+	qCountFailures = `
+		SELECT COALESCE(SUM(occurrences), 0), COALESCE(SUM(acknowledged * occurrences), 0)
+		FROM check_results
+		WHERE target_id = ? AND ` + resultSeen + ` >= ? AND ` + failedResult
+
+	qSelectAllTargets = `
+		SELECT ` + targetColumns + `
+		FROM targets
+		ORDER BY created_at, id`
+
+	qUpdateTargetURL = `
+		UPDATE targets
+		SET url = ?, host = ?, updated_at = ?
+		WHERE id = ?`
+
+	qReassignResults = `
+		UPDATE check_results
+		SET target_id = ?
+		WHERE target_id = ?`
+
+	qReassignIdempotency = `
+		UPDATE idempotency_keys
+		SET target_id = ?
+		WHERE target_id = ?`
+
+	qDeleteTarget = `
+		DELETE FROM targets
+		WHERE id = ?`
+
+	qDeleteTargetResults = `
+		DELETE FROM check_results
+		WHERE target_id = ?`
+
+	qDeleteTargetIdempotency = `
+		DELETE FROM idempotency_keys
+		WHERE target_id = ?`
+
+	// Expired keys are ignored, then removed by qDeleteExpiredIdempotency
 	qSelectIdempotency = `
-		SELECT response_code, response_body
+		SELECT response_code, response_body, request_hash
 		FROM idempotency_keys
-		WHERE key = ?`
+		WHERE key = ? AND (expires_at IS NULL OR expires_at > ?)`
 
 	qInsertIdempotency = `
-		INSERT INTO idempotency_keys (key, request_hash, target_id, response_code, response_body)
-		VALUES (?, ?, ?, ?, ?)`
+		INSERT INTO idempotency_keys (key, request_hash, target_id, response_code, response_body, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?)`
+
+	qDeleteExpiredIdempotency = `
+		DELETE FROM idempotency_keys
+		WHERE key = ? AND expires_at <= ?`
+
+	// Takes the lease if it's free, expired, or already ours (renewal)
+	qAcquireLease = `
+		INSERT INTO leases (name, holder, expires_at)
+		VALUES (?, ?, ?)
+		ON CONFLICT (name) DO UPDATE
+		SET holder = excluded.holder, expires_at = excluded.expires_at
+		WHERE leases.holder = excluded.holder OR leases.expires_at <= ?`
+
+	qReleaseLease = `
+		DELETE FROM leases
+		WHERE name = ? AND holder = ?`
 )
 
-// UpsertTargetByURL returns existing or creates new target
-func (s *SQLiteStore) UpsertTargetByURL(ctx context.Context, canonicalURL, host string) (*Target, bool, error) {
-	var t Target
-	var created string
-	err := s.db.QueryRowContext(ctx, qSelectTargetByURL, canonicalURL).
-		Scan(&t.ID, &t.URL, &t.Host, &created)
+// UpsertTargetByURL returns existing or creates new target.
+// Settings only apply on creation; an existing target keeps its own.
+func (s *SQLiteStore) UpsertTargetByURL(ctx context.Context, canonicalURL, host string, settings TargetSettings) (_ *Target, _ bool, err error) {
+	ctx, done := s.withTimeout(ctx)
+	defer done(&err)
 
+	existing, err := scanTarget(s.db.QueryRowContext(ctx, qSelectTargetByURL, canonicalURL))
 	if err == nil {
-		t.CreatedAt = parseTime(created)
-		return &t, false, nil
+		return existing, false, nil
 	}
 	if err != sql.ErrNoRows {
 		return nil, false, fmt.Errorf("query target: %w", err)
 	}
 
-	t.ID = "t_" + generateID()
+	var t Target
+	t.ID = "t_" + s.ids.NewID()
 	t.URL = canonicalURL
 	t.Host = host
 	t.CreatedAt = time.Now()
+	t.UpdatedAt = t.CreatedAt
+	t.Enabled = true
+	t.TargetSettings = settings
+
+	schedule, err := nullableJSON(t.Schedule)
+	if err != nil {
+		return nil, false, fmt.Errorf("encode schedule: %w", err)
+	}
+	maintenance, err := nullableJSON(t.Maintenance)
+	if err != nil {
+		return nil, false, fmt.Errorf("encode maintenance: %w", err)
+	}
+	var headers *string
+	if len(t.Headers) > 0 {
+		if headers, err = nullableJSON(&t.Headers); err != nil {
+			return nil, false, fmt.Errorf("encode headers: %w", err)
+		}
+	}
 
-	_, err = s.db.ExecContext(ctx, qInsertTarget,
-		t.ID, t.URL, t.Host, formatTime(t.CreatedAt))
+	err = s.retryBusy(ctx, func() error {
+		_, err := s.db.ExecContext(ctx, qInsertTarget,
+			t.ID, t.URL, t.Host, formatTime(t.CreatedAt), durationSeconds(t.Retention), schedule, headers, t.ExpectedStatus,
+			t.MatchPattern, nullableString(t.MatchMode), t.Proxy, boolInt(t.InsecureSkipVerify),
+			nullableString(t.Method), t.RequestBody, t.FailureThreshold, formatTime(t.UpdatedAt), maintenance)
+		return err
+	})
 	if err != nil {
 		return nil, false, fmt.Errorf("insert target: %w", err)
 	}
@@ -128,91 +1114,869 @@ func (s *SQLiteStore) UpsertTargetByURL(ctx context.Context, canonicalURL, host
 	return &t, true, nil
 }
 
-// GetTargets fetches targets with filtering and pagination
-func (s *SQLiteStore) GetTargets(ctx context.Context, hostFilter string, afterCreatedAt time.Time, afterID string, limit int) ([]*Target, *Cursor, error) {
-	query := qSelectTargetsBase
-	args := []any{}
+// GetTargetByID fetches one target, returning ErrTargetNotFound if it doesn't exist
+func (s *SQLiteStore) GetTargetByID(ctx context.Context, id string) (_ *Target, err error) {
+	ctx, done := s.withTimeout(ctx)
+	defer done(&err)
 
-	if hostFilter != "" {
-		query += " AND host = ?"
-		args = append(args, hostFilter)
-	}
-	if !afterCreatedAt.IsZero() {
-		query += " AND (created_at > ? OR (created_at = ? AND id > ?))"
-		ts := formatTime(afterCreatedAt)
-		args = append(args, ts, ts, afterID)
+	t, err := scanTarget(s.db.QueryRowContext(ctx, qSelectTargetByID, id))
+	if err == sql.ErrNoRows {
+		return nil, ErrTargetNotFound
 	}
-	query += " ORDER BY created_at, id LIMIT ?"
-	args = append(args, limit)
-
-	rows, err := s.db.QueryContext(ctx, query, args...)
 	if err != nil {
-		return nil, nil, fmt.Errorf("get targets: %w", err)
+		return nil, fmt.Errorf("get target: %w", err)
 	}
-	defer rows.Close()
+	return t, nil
+}
 
-	var targets []*Target
-	for rows.Next() {
-		var t Target
-		var created string
-		if err := rows.Scan(&t.ID, &t.URL, &t.Host, &created); err != nil {
-			return nil, nil, err
+// UpdateTarget writes the settings named in update and returns the target as
+// it now stands, or ErrTargetNotFound if it doesn't exist. The URL and host
+// can't be changed.
+func (s *SQLiteStore) UpdateTarget(ctx context.Context, id string, update TargetUpdate) (_ *Target, err error) {
+	ctx, done := s.withTimeout(ctx)
+	defer done(&err)
+
+	for _, field := range update.Fields {
+		if !IsTargetSetting(field) {
+			return nil, fmt.Errorf("unknown target setting %q", field)
 		}
-		t.CreatedAt = parseTime(created)
-		targets = append(targets, &t)
 	}
 
-	if len(targets) == 0 {
-		return nil, nil, nil
+	var sets []string
+	var args []any
+	for _, c := range targetSettingColumns {
+		if !slices.Contains(update.Fields, c.field) {
+			continue
+		}
+		v, err := update.Settings.columnValue(c.field)
+		if err != nil {
+			return nil, fmt.Errorf("encode %s: %w", c.field, err)
+		}
+		sets = append(sets, c.column+" = ?")
+		args = append(args, v)
 	}
-	last := targets[len(targets)-1]
-	cursor := &Cursor{CreatedAt: last.CreatedAt, ID: last.ID}
+	if update.Enabled != nil {
+		sets = append(sets, "enabled = ?")
+		args = append(args, boolInt(*update.Enabled))
+	}
+	if len(sets) == 0 {
+		return s.GetTargetByID(ctx, id)
+	}
+	sets = append(sets, "updated_at = ?")
+	args = append(args, formatTime(time.Now()))
 
-	return targets, cursor, nil
-}
+	var updated *Target
+	err = s.inTx(ctx, func(tx *SQLiteStore) error {
+		query := "UPDATE targets SET " + strings.Join(sets, ", ") + " WHERE id = ?"
+		res, err := tx.db.ExecContext(ctx, query, append(args, id)...)
+		if err != nil {
+			return fmt.Errorf("update target %s: %w", id, err)
+		}
+		if n, err := res.RowsAffected(); err != nil {
+			return fmt.Errorf("update target %s: %w", id, err)
+		} else if n == 0 {
+			return ErrTargetNotFound
+		}
+
+		updated, err = tx.GetTargetByID(ctx, id)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return updated, nil
+}
+
+// DeleteTarget removes a target along with its results and idempotency keys,
+// returning ErrTargetNotFound if it doesn't exist
+func (s *SQLiteStore) DeleteTarget(ctx context.Context, id string) (err error) {
+	ctx, done := s.withTimeout(ctx)
+	defer done(&err)
+
+	return s.inTx(ctx, func(tx *SQLiteStore) error {
+		// Foreign keys aren't enforced, so dependent rows are removed by hand
+		if _, err := tx.db.ExecContext(ctx, qDeleteTargetResults, id); err != nil {
+			return fmt.Errorf("delete results of %s: %w", id, err)
+		}
+		if _, err := tx.db.ExecContext(ctx, qDeleteTargetIdempotency, id); err != nil {
+			return fmt.Errorf("delete idempotency keys of %s: %w", id, err)
+		}
+		if _, err := tx.db.ExecContext(ctx, qDeleteTargetState, id); err != nil {
+			return fmt.Errorf("delete state of %s: %w", id, err)
+		}
+
+		res, err := tx.db.ExecContext(ctx, qDeleteTarget, id)
+		if err != nil {
+			return fmt.Errorf("delete target %s: %w", id, err)
+		}
+		if n, err := res.RowsAffected(); err != nil {
+			return fmt.Errorf("delete target %s: %w", id, err)
+		} else if n == 0 {
+			return ErrTargetNotFound
+		}
+		return nil
+	})
+}
+
+// GetTargets fetches targets with filtering and pagination
+func (s *SQLiteStore) GetTargets(ctx context.Context, hosts HostFilter, order TargetOrder, after *Cursor, limit int) ([]*Target, *Cursor, error) {
+	return s.getTargets(ctx, qSelectTargetsBase, hosts, order, after, limit)
+}
+
+// GetEnabledTargets pages through the targets that aren't paused, like GetTargets
+func (s *SQLiteStore) GetEnabledTargets(ctx context.Context, afterCreatedAt time.Time, afterID string, limit int) ([]*Target, *Cursor, error) {
+	var after *Cursor
+	if !afterCreatedAt.IsZero() {
+		after = &Cursor{CreatedAt: afterCreatedAt, ID: afterID}
+	}
+	return s.getTargets(ctx, qSelectTargetsBase+" AND enabled = 1", HostFilter{}, OrderCreatedAt, after, limit)
+}
+
+// getTargets pages through targets in order, starting after the cursor when
+// there is one. An empty order means OrderCreatedAt.
+func (s *SQLiteStore) getTargets(ctx context.Context, query string, hosts HostFilter, order TargetOrder, after *Cursor, limit int) (_ []*Target, _ *Cursor, err error) {
+	ctx, done := s.withTimeout(ctx)
+	defer done(&err)
+
+	if order == "" {
+		order = OrderCreatedAt
+	}
+	sorting, ok := targetOrders[order]
+	if !ok {
+		return nil, nil, fmt.Errorf("get targets: unknown order %q", order)
+	}
+	where, args := hosts.where()
+	query += where
+	if after != nil {
+		query += " AND " + sorting.after
+		key := sorting.key(after)
+		args = append(args, key, key, after.ID)
+	}
+	query += " ORDER BY " + sorting.orderBy + " LIMIT ?"
+	args = append(args, limit)
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, nil, fmt.Errorf("get targets: %w", err)
+	}
+	defer rows.Close()
+
+	var targets []*Target
+	for rows.Next() {
+		t, err := scanTarget(rows)
+		if err != nil {
+			return nil, nil, err
+		}
+		targets = append(targets, t)
+	}
+
+	if len(targets) == 0 {
+		return nil, nil, nil
+	}
+	last := targets[len(targets)-1]
+	cursor := &Cursor{CreatedAt: last.CreatedAt, ID: last.ID, Host: last.Host, NextCheckAt: last.NextCheckAt}
+
+	return targets, cursor, nil
+}
+
+// GetDistinctHosts lists every monitored host once, in order
+func (s *SQLiteStore) GetDistinctHosts(ctx context.Context) (_ []string, err error) {
+	ctx, done := s.withTimeout(ctx)
+	defer done(&err)
+
+	rows, err := s.db.QueryContext(ctx, qSelectDistinctHosts)
+	if err != nil {
+		return nil, fmt.Errorf("get distinct hosts: %w", err)
+	}
+	defer rows.Close()
+
+	hosts := []string{} // Encodes as [] rather than null when empty
+	for rows.Next() {
+		var host string
+		if err := rows.Scan(&host); err != nil {
+			return nil, err
+		}
+		hosts = append(hosts, host)
+	}
+	return hosts, rows.Err()
+}
+
+// CountTargets counts the targets GetTargets would page through
+func (s *SQLiteStore) CountTargets(ctx context.Context, hosts HostFilter) (_ int, err error) {
+	ctx, done := s.withTimeout(ctx)
+	defer done(&err)
+
+	where, args := hosts.where()
+	query := qCountTargets + where
+
+	var count int
+	if err := s.db.QueryRowContext(ctx, query, args...).Scan(&count); err != nil {
+		return 0, fmt.Errorf("count targets: %w", err)
+	}
+	return count, nil
+}
+
+// GetTargetsVersion counts the targets GetTargets would page through and
+// finds the newest of them
+func (s *SQLiteStore) GetTargetsVersion(ctx context.Context, hosts HostFilter) (_ *TargetsVersion, err error) {
+	ctx, done := s.withTimeout(ctx)
+	defer done(&err)
+
+	where, args := hosts.where()
+	query := qSelectTargetsVersion + where
+
+	var v TargetsVersion
+	var lastModified string
+	if err := s.db.QueryRowContext(ctx, query, args...).Scan(&v.Count, &lastModified); err != nil {
+		return nil, fmt.Errorf("get targets version: %w", err)
+	}
+	v.LastModified = parseTime(lastModified)
+	return &v, nil
+}
+
+// DeleteExpiredResults purges results past their target's retention. Targets
+// with an override are purged one policy at a time; the rest use defaultRetention,
+// where zero keeps them forever. Returns the number of rows removed.
+func (s *SQLiteStore) DeleteExpiredResults(ctx context.Context, now time.Time, defaultRetention time.Duration) (int64, error) {
+	policies, err := s.retentionPolicies(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	var total int64
+	for _, secs := range policies {
+		cutoff := now.Add(-time.Duration(secs) * time.Second)
+		n, err := s.deleteInBatches(ctx, qDeleteResultsForPolicy, formatTime(cutoff), secs)
+		total += n
+		if err != nil {
+			return total, fmt.Errorf("delete expired results: %w", err)
+		}
+	}
+
+	if defaultRetention > 0 {
+		n, err := s.DeleteResultsBefore(ctx, now.Add(-defaultRetention))
+		total += n
+		if err != nil {
+			return total, err
+		}
+	}
+
+	return total, nil
+}
+
+// DeleteResultsBefore purges results checked strictly before cutoff, except
+// those of targets with their own retention. Returns the number of rows removed.
+func (s *SQLiteStore) DeleteResultsBefore(ctx context.Context, cutoff time.Time) (int64, error) {
+	n, err := s.deleteInBatches(ctx, qDeleteResultsForDefaultPolicy, formatTime(cutoff))
+	if err != nil {
+		return n, fmt.Errorf("delete results before %s: %w", formatTime(cutoff), err)
+	}
+	return n, nil
+}
+
+// pruneBatchSize bounds how many results one DELETE removes, so pruning a
+// large backlog never holds the write lock for long.
+const pruneBatchSize = 1000
+
+// deleteInBatches runs a batched delete until it removes less than a full
+// batch. The batch size is appended to args. Each batch gets its own query
+// timeout, so a large backlog isn't cut short as a whole.
+func (s *SQLiteStore) deleteInBatches(ctx context.Context, query string, args ...any) (int64, error) {
+	args = append(args, pruneBatchSize)
+
+	var total int64
+	for {
+		n, err := s.deleteBatch(ctx, query, args)
+		if err != nil {
+			return total, err
+		}
+		total += n
+		if n < pruneBatchSize {
+			return total, nil
+		}
+	}
+}
 
-// InsertCheckResult saves a check result
-func (s *SQLiteStore) InsertCheckResult(ctx context.Context, r *CheckResult) error {
-	_, err := s.db.ExecContext(ctx, qInsertCheckResult,
-		r.TargetID, formatTime(r.CheckedAt), r.StatusCode, r.LatencyMs, r.Error)
+func (s *SQLiteStore) deleteBatch(ctx context.Context, query string, args []any) (_ int64, err error) {
+	ctx, done := s.withTimeout(ctx)
+	defer done(&err)
+
+	res, err := s.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+func (s *SQLiteStore) retentionPolicies(ctx context.Context) (_ []int64, err error) {
+	ctx, done := s.withTimeout(ctx)
+	defer done(&err)
+
+	rows, err := s.db.QueryContext(ctx, qSelectRetentionPolicies)
+	if err != nil {
+		return nil, fmt.Errorf("get retention policies: %w", err)
+	}
+	defer rows.Close()
+
+	var policies []int64
+	for rows.Next() {
+		var secs int64
+		if err := rows.Scan(&secs); err != nil {
+			return nil, err
+		}
+		policies = append(policies, secs)
+	}
+	return policies, rows.Err()
+}
+
+// GetStaleTargets returns targets that existed before the cutoff but haven't been checked since
+func (s *SQLiteStore) GetStaleTargets(ctx context.Context, checkedBefore time.Time, limit int) (_ []*Target, err error) {
+	ctx, done := s.withTimeout(ctx)
+	defer done(&err)
+
+	ts := formatTime(checkedBefore)
+	rows, err := s.db.QueryContext(ctx, qSelectStaleTargets, ts, ts, limit)
+	if err != nil {
+		return nil, fmt.Errorf("get stale targets: %w", err)
+	}
+	defer rows.Close()
+
+	var targets []*Target
+	for rows.Next() {
+		t, err := scanTarget(rows)
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, t)
+	}
+	return targets, rows.Err()
+}
+
+// ClaimDueTargets returns up to limit targets due at now and pushes their
+// next check to now+lease in the same statement, so concurrent schedulers
+// sharing the database each get a disjoint set.
+func (s *SQLiteStore) ClaimDueTargets(ctx context.Context, now time.Time, lease time.Duration, limit int) (_ []*Target, err error) {
+	ctx, done := s.withTimeout(ctx)
+	defer done(&err)
+
+	ts := formatTime(now.UTC())
+	rows, err := s.db.QueryContext(ctx, qClaimDueTargets, formatTime(now.Add(lease).UTC()), ts, limit, ts)
+	if err != nil {
+		return nil, fmt.Errorf("claim due targets: %w", err)
+	}
+	defer rows.Close()
+
+	var targets []*Target
+	for rows.Next() {
+		t, err := scanTarget(rows)
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, t)
+	}
+	return targets, rows.Err()
+}
+
+// ClaimStaleTargets is GetStaleTargets for schedulers sharing the database:
+// it claims the stale targets as ClaimDueTargets does, so each is rechecked
+// by one scheduler. A stale target another scheduler holds a claim on is
+// left to it until the claim runs out.
+func (s *SQLiteStore) ClaimStaleTargets(ctx context.Context, checkedBefore, now time.Time, lease time.Duration, limit int) (_ []*Target, err error) {
+	ctx, done := s.withTimeout(ctx)
+	defer done(&err)
+
+	cutoff, ts := formatTime(checkedBefore), formatTime(now.UTC())
+	rows, err := s.db.QueryContext(ctx, qClaimStaleTargets, formatTime(now.Add(lease).UTC()), cutoff, ts, cutoff, limit, ts)
+	if err != nil {
+		return nil, fmt.Errorf("claim stale targets: %w", err)
+	}
+	defer rows.Close()
+
+	var targets []*Target
+	for rows.Next() {
+		t, err := scanTarget(rows)
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, t)
+	}
+	return targets, rows.Err()
+}
+
+// InsertCheckResult saves a check result and sets its ID, updating the
+// target's state and reporting it in PreviousState and NewState. A zero
+// Attempts is recorded as a single attempt. With Dedup, a result matching
+// the target's latest one is counted on that row and takes its ID and
+// occurrences, keeping its own CheckedAt.
+func (s *SQLiteStore) InsertCheckResult(ctx context.Context, r *CheckResult) (err error) {
+	ctx, done := s.withTimeout(ctx)
+	defer done(&err)
+
+	if r.Attempts < 1 {
+		r.Attempts = 1
+	}
+	return s.retryBusy(ctx, func() error { return s.insertCheckResult(ctx, r) })
+}
+
+// insertCheckResult is one try at InsertCheckResult, in its own transaction
+func (s *SQLiteStore) insertCheckResult(ctx context.Context, r *CheckResult) error {
+	return s.inTx(ctx, func(tx *SQLiteStore) error { return tx.writeCheckResult(ctx, r) })
+}
+
+// InsertCheckResults saves results as InsertCheckResult would, in order,
+// but in one transaction, so a busy scheduling pass isn't a commit per
+// check. Each result is written under its own savepoint: one that fails is
+// rolled back alone and reported in a *BatchError, which leaves the rest
+// stored. Any other error means none were. The query timeout bounds the
+// batch as a whole.
+func (s *SQLiteStore) InsertCheckResults(ctx context.Context, results []*CheckResult) (err error) {
+	if len(results) == 0 {
+		return nil
+	}
+	ctx, done := s.withTimeout(ctx)
+	defer done(&err)
+
+	for _, r := range results {
+		if r.Attempts < 1 {
+			r.Attempts = 1
+		}
+	}
+	errs := make([]error, len(results))
+	err = s.retryBusy(ctx, func() error {
+		clear(errs)
+		return s.inTx(ctx, func(tx *SQLiteStore) error {
+			for i, r := range results {
+				var err error
+				if errs[i], err = tx.savepoint(ctx, func() error { return tx.writeCheckResult(ctx, r) }); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return err
+	}
+	for _, err := range errs {
+		if err != nil {
+			return &BatchError{Errs: errs}
+		}
+	}
+	return nil
+}
+
+// savepoint runs write inside the transaction so that, if it fails, only
+// its own changes are rolled back, returning its error. The second error is
+// the savepoint itself failing, which leaves the transaction unusable.
+func (s *SQLiteStore) savepoint(ctx context.Context, write func() error) (writeErr, err error) {
+	if _, err := s.db.ExecContext(ctx, "SAVEPOINT result"); err != nil {
+		return nil, fmt.Errorf("savepoint: %w", err)
+	}
+	if writeErr = write(); writeErr != nil {
+		if _, err := s.db.ExecContext(ctx, "ROLLBACK TO SAVEPOINT result"); err != nil {
+			return writeErr, fmt.Errorf("roll back to savepoint: %w", err)
+		}
+	}
+	if _, err := s.db.ExecContext(ctx, "RELEASE SAVEPOINT result"); err != nil {
+		return writeErr, fmt.Errorf("release savepoint: %w", err)
+	}
+	return writeErr, nil
+}
+
+// writeCheckResult runs the statements storing r, in the transaction s is bound to
+func (s *SQLiteStore) writeCheckResult(ctx context.Context, r *CheckResult) error {
+	checked := formatTime(r.CheckedAt)
+	err := sql.ErrNoRows
+	if r.Dedup {
+		err = s.db.QueryRowContext(ctx, qDedupCheckResult,
+			checked, r.TargetID, r.StatusCode, r.Error, r.BodyHash, boolInt(r.InMaintenance), checked).Scan(&r.ID, &r.Occurrences)
+	}
+	if err == sql.ErrNoRows {
+		// RETURNING rather than LastInsertId, which Postgres drivers don't support
+		r.Occurrences = 1
+		err = s.db.QueryRowContext(ctx, qInsertCheckResult,
+			r.TargetID, checked, r.StatusCode, r.LatencyMs, r.Error, r.NodeID, r.Metadata, r.Attempts,
+			r.FinalURL, r.RedirectCount, r.BodyHash, formatTimePtr(r.CertExpiresAt), r.CertDaysRemaining,
+			r.DNSMs, r.ConnectMs, r.TLSMs, r.TTFBMs, r.ContentType, r.ContentLength, checked,
+			boolInt(r.InMaintenance)).Scan(&r.ID)
+	}
 	if err != nil {
 		return fmt.Errorf("insert result: %w", err)
 	}
+	r.LastSeen = r.CheckedAt
+
+	r.PreviousState = ""
+	if err := s.db.QueryRowContext(ctx, qSelectState, r.TargetID).Scan(&r.PreviousState); err != nil && err != sql.ErrNoRows {
+		return fmt.Errorf("get state of %s: %w", r.TargetID, err)
+	}
+	threshold := max(s.failureThreshold, 1)
+	err = s.db.QueryRowContext(ctx, s.classified(qUpsertTargetState), threshold, r.ID, threshold, threshold).Scan(&r.NewState)
+	if err == sql.ErrNoRows {
+		r.NewState = r.PreviousState
+	} else if err != nil {
+		return fmt.Errorf("update state of %s: %w", r.TargetID, err)
+	}
+	if r.NextCheckAt != nil {
+		if _, err := s.db.ExecContext(ctx, qSetNextCheck, formatTime(r.NextCheckAt.UTC()), r.TargetID); err != nil {
+			return fmt.Errorf("set next check of %s: %w", r.TargetID, err)
+		}
+	}
 	return nil
 }
 
-// GetResults fetches results for a target
-func (s *SQLiteStore) GetResults(ctx context.Context, targetID string, since time.Time, limit int) ([]*CheckResult, error) {
-	rows, err := s.db.QueryContext(ctx, qSelectResults,
-		targetID, formatTime(since), limit)
+// GetState returns a target's current state, or ErrNoState if no result has
+// been stored for it
+func (s *SQLiteStore) GetState(ctx context.Context, targetID string) (_ *TargetState, err error) {
+	ctx, done := s.withTimeout(ctx)
+	defer done(&err)
+
+	var st TargetState
+	var since, lastChecked string
+	err = s.db.QueryRowContext(ctx, qSelectTargetState, targetID).Scan(&st.TargetID, &st.State, &since, &lastChecked, &st.Failures)
+	if err == sql.ErrNoRows {
+		return nil, ErrNoState
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get state: %w", err)
+	}
+	st.Since = parseTime(since)
+	st.LastChecked = parseTime(lastChecked)
+	return &st, nil
+}
+
+// GetResultsAfterID fetches up to limit results stored after the one with
+// the given ID, oldest first, optionally only a target's or a host's. IDs
+// come from AUTOINCREMENT and BIGSERIAL, so later results have larger ones.
+func (s *SQLiteStore) GetResultsAfterID(ctx context.Context, afterID int64, targetID, host string, limit int) (_ []*CheckResult, err error) {
+	ctx, done := s.withTimeout(ctx)
+	defer done(&err)
+
+	query := qSelectResultsAfterID
+	args := []any{afterID}
+	if targetID != "" {
+		query += " AND target_id = ?"
+		args = append(args, targetID)
+	}
+	if host != "" {
+		query += " AND target_id IN (SELECT id FROM targets WHERE host = ?)"
+		args = append(args, host)
+	}
+	query += " ORDER BY id LIMIT ?"
+	args = append(args, limit)
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
 	if err != nil {
-		return nil, fmt.Errorf("get results: %w", err)
+		return nil, fmt.Errorf("get results after %d: %w", afterID, err)
 	}
 	defer rows.Close()
 
 	var results []*CheckResult
 	for rows.Next() {
-		var r CheckResult
-		var checked string
-		if err := rows.Scan(&r.ID, &r.TargetID, &checked, &r.StatusCode, &r.LatencyMs, &r.Error); err != nil {
+		r, err := scanResult(rows)
+		if err != nil {
 			return nil, err
 		}
-		r.CheckedAt = parseTime(checked)
-		results = append(results, &r)
+		results = append(results, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("get results after %d: %w", afterID, err)
 	}
 	return results, nil
 }
 
-func generateID() string {
-	return uuid.NewString()
+// GetResults fetches results for a target, newest first, optionally only those
+// from one node. Pages continue after the given cursor; a full page returns
+// the cursor for the next one.
+func (s *SQLiteStore) GetResults(ctx context.Context, targetID string, since time.Time, nodeID string, after *ResultCursor, limit int) (_ []*CheckResult, _ *ResultCursor, err error) {
+	ctx, done := s.withTimeout(ctx)
+	defer done(&err)
+
+	query := qSelectResultsBase
+	args := []any{targetID, formatTime(since)}
+
+	if nodeID != "" {
+		query += " AND node_id = ?"
+		args = append(args, nodeID)
+	}
+	if after != nil {
+		query += " AND (checked_at < ? OR (checked_at = ? AND id < ?))"
+		ts := formatTime(after.CheckedAt)
+		args = append(args, ts, ts, after.ID)
+	}
+	query += " ORDER BY checked_at DESC, id DESC LIMIT ?"
+	args = append(args, limit)
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, nil, fmt.Errorf("get results: %w", err)
+	}
+	defer rows.Close()
+
+	var results []*CheckResult
+	for rows.Next() {
+		r, err := scanResult(rows)
+		if err != nil {
+			return nil, nil, err
+		}
+		results = append(results, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, fmt.Errorf("get results: %w", err)
+	}
+
+	if len(results) < limit {
+		return results, nil, nil
+	}
+	last := results[len(results)-1]
+	return results, &ResultCursor{CheckedAt: last.CheckedAt, ID: last.ID}, nil
+}
+
+// GetLatestResults returns the most recent result of each target, keyed by
+// target ID. Targets that have never been checked are absent from the map.
+func (s *SQLiteStore) GetLatestResults(ctx context.Context, targetIDs []string) (_ map[string]*CheckResult, err error) {
+	ctx, done := s.withTimeout(ctx)
+	defer done(&err)
+
+	latest := make(map[string]*CheckResult, len(targetIDs))
+	if len(targetIDs) == 0 {
+		return latest, nil
+	}
+
+	args := make([]any, len(targetIDs))
+	for i, id := range targetIDs {
+		args[i] = id
+	}
+	query := fmt.Sprintf(qSelectLatestResults, placeholders(len(targetIDs)))
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("get latest results: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		r, err := scanResult(rows)
+		if err != nil {
+			return nil, err
+		}
+		latest[r.TargetID] = r
+	}
+	return latest, rows.Err()
+}
+
+// placeholders returns n comma-separated bind parameters
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
+
+// rowScanner is satisfied by both *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+// scanTarget reads a row selected with targetColumns
+func scanTarget(row rowScanner) (*Target, error) {
+	var t Target
+	var created string
+	var retention *int64
+	var schedule, headers, matchMode, method, updated, maintenance, nextCheck *string
+	if err := row.Scan(&t.ID, &t.URL, &t.Host, &created, &retention, &schedule, &headers, &t.ExpectedStatus,
+		&t.MatchPattern, &matchMode, &t.Proxy, &t.InsecureSkipVerify, &method, &t.RequestBody, &t.FailureThreshold, &t.Enabled, &updated,
+		&maintenance, &nextCheck); err != nil {
+		return nil, err
+	}
+	if matchMode != nil {
+		t.MatchMode = *matchMode
+	}
+	if method != nil {
+		t.Method = *method
+	}
+	t.CreatedAt = parseTime(created)
+	t.UpdatedAt = t.CreatedAt
+	if updated != nil {
+		t.UpdatedAt = parseTime(*updated)
+	}
+	t.Retention = secondsDuration(retention)
+	if nextCheck != nil {
+		next := parseTime(*nextCheck)
+		t.NextCheckAt = &next
+	}
+
+	var err error
+	if t.Schedule, err = scanJSON[model.Schedule](schedule); err != nil {
+		return nil, fmt.Errorf("decode schedule of %s: %w", t.ID, err)
+	}
+	if t.Maintenance, err = scanJSON[model.Schedule](maintenance); err != nil {
+		return nil, fmt.Errorf("decode maintenance of %s: %w", t.ID, err)
+	}
+	h, err := scanJSON[Headers](headers)
+	if err != nil {
+		return nil, fmt.Errorf("decode headers of %s: %w", t.ID, err)
+	}
+	if h != nil {
+		t.Headers = *h
+	}
+	return &t, nil
+}
+
+// boolInt stores a flag as the 1 or 0 its INTEGER column holds
+func boolInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// nullableString stores an empty string as NULL
+func nullableString(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
+
+// nullableJSON stores an optional value as JSON text, or NULL when unset
+func nullableJSON[T any](v *T) (*string, error) {
+	if v == nil {
+		return nil, nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	text := string(data)
+	return &text, nil
+}
+
+func scanJSON[T any](text *string) (*T, error) {
+	if text == nil {
+		return nil, nil
+	}
+	var v T
+	if err := json.Unmarshal([]byte(*text), &v); err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
+
+// durationSeconds stores an optional duration as whole seconds
+func durationSeconds(d *Duration) *int64 {
+	if d == nil {
+		return nil
+	}
+	secs := int64(time.Duration(*d) / time.Second)
+	return &secs
+}
+
+func secondsDuration(secs *int64) *Duration {
+	if secs == nil {
+		return nil
+	}
+	d := Duration(time.Duration(*secs) * time.Second)
+	return &d
+}
+
+// scanResult reads a row selected with resultColumns
+func scanResult(row rowScanner) (*CheckResult, error) {
+	var r CheckResult
+	var checked string
+	var certExpires, lastSeen *string
+	if err := row.Scan(&r.ID, &r.TargetID, &checked, &r.StatusCode, &r.LatencyMs, &r.Error, &r.NodeID,
+		&r.Acknowledged, &r.AckNote, &r.Metadata, &r.Attempts, &r.FinalURL, &r.RedirectCount,
+		&r.BodyHash, &certExpires, &r.CertDaysRemaining, &r.DNSMs, &r.ConnectMs, &r.TLSMs, &r.TTFBMs,
+		&r.ContentType, &r.ContentLength, &r.Occurrences, &lastSeen, &r.InMaintenance, &r.BodyChanged); err != nil {
+		return nil, err
+	}
+	r.CheckedAt = parseTime(checked)
+	r.LastSeen = r.CheckedAt // Rows from before last_seen existed
+	if lastSeen != nil {
+		r.LastSeen = parseTime(*lastSeen)
+	}
+	if certExpires != nil {
+		t := parseTime(*certExpires)
+		r.CertExpiresAt = &t
+	}
+	return &r, nil
+}
+
+// GetLatencyPercentiles computes min/avg/p50/p90/p95/p99/max latency for a target since the given time
+func (s *SQLiteStore) GetLatencyPercentiles(ctx context.Context, targetID string, since time.Time) (_ *LatencyPercentiles, err error) {
+	ctx, done := s.withTimeout(ctx)
+	defer done(&err)
+
+	var p LatencyPercentiles
+	err = s.db.QueryRowContext(ctx, qSelectLatencyPercentiles, targetID, formatTime(since)).
+		Scan(&p.Count, &p.Min, &p.Avg, &p.P50, &p.P90, &p.P95, &p.P99, &p.Max)
+	if err != nil {
+		return nil, fmt.Errorf("get latency percentiles: %w", err)
+	}
+	return &p, nil
+}
+
+// GetSummary computes a target's uptime and latency since the given time
+func (s *SQLiteStore) GetSummary(ctx context.Context, targetID string, since time.Time) (_ *Summary, err error) {
+	ctx, done := s.withTimeout(ctx)
+	defer done(&err)
+
+	var sum Summary
+	err = s.db.QueryRowContext(ctx, s.classified(qSelectSummary), targetID, formatTime(since)).
+		Scan(&sum.TotalChecks, &sum.SuccessfulChecks, &sum.AvgLatencyMs, &sum.P95LatencyMs)
+	if err != nil {
+		return nil, fmt.Errorf("get summary: %w", err)
+	}
+
+	sum.FailedChecks = sum.TotalChecks - sum.SuccessfulChecks
+	if sum.TotalChecks > 0 {
+		uptime := math.Round(float64(sum.SuccessfulChecks)*10000/float64(sum.TotalChecks)) / 100
+		sum.UptimePercent = &uptime
+	}
+	return &sum, nil
 }
 
-// UpsertIdempotencyKey stores or returns cached response
-func (s *SQLiteStore) UpsertIdempotencyKey(ctx context.Context, key, requestHash, targetID string, responseCode int, responseBody interface{}) (*IdempotencyResponse, bool, error) {
+// AcknowledgeFailures marks a target's unacknowledged failures in [from, until] as acknowledged
+func (s *SQLiteStore) AcknowledgeFailures(ctx context.Context, targetID string, from, until time.Time, note string) (_ int64, err error) {
+	ctx, done := s.withTimeout(ctx)
+	defer done(&err)
+
+	var notePtr *string
+	if note != "" {
+		notePtr = &note
+	}
+
+	res, err := s.db.ExecContext(ctx, s.classified(qAcknowledgeFailures),
+		notePtr, targetID, formatTime(from), formatTime(until))
+	if err != nil {
+		return 0, fmt.Errorf("acknowledge failures: %w", err)
+	}
+	return res.RowsAffected()
+}
+
+// CountFailures counts a target's failed checks since the given time
+func (s *SQLiteStore) CountFailures(ctx context.Context, targetID string, since time.Time) (_ *FailureCounts, err error) {
+	ctx, done := s.withTimeout(ctx)
+	defer done(&err)
+
+	var c FailureCounts
+	err = s.db.QueryRowContext(ctx, s.classified(qCountFailures), targetID, formatTime(since)).
+		Scan(&c.Total, &c.Acknowledged)
+	if err != nil {
+		return nil, fmt.Errorf("count failures: %w", err)
+	}
+	c.Unacknowledged = c.Total - c.Acknowledged
+	return &c, nil
+}
+
+// UpsertIdempotencyKey stores or returns cached response. A stored response
+// is kept until expiresAt; an expired one is replaced.
+func (s *SQLiteStore) UpsertIdempotencyKey(ctx context.Context, key, requestHash, targetID string, responseCode int, responseBody interface{}, expiresAt time.Time) (_ *IdempotencyResponse, _ bool, err error) {
+	ctx, done := s.withTimeout(ctx)
+	defer done(&err)
+
 	var resp IdempotencyResponse
 	var rawBody string
-	err := s.db.QueryRowContext(ctx, qSelectIdempotency, key).
-		Scan(&resp.ResponseCode, &rawBody)
+	now := formatTime(time.Now().UTC())
+	err = s.db.QueryRowContext(ctx, qSelectIdempotency, key, now).
+		Scan(&resp.ResponseCode, &rawBody, &resp.RequestHash)
 	if err == nil {
 		_ = json.Unmarshal([]byte(rawBody), &resp.ResponseBody)
 		return &resp, false, nil
@@ -220,24 +1984,44 @@ func (s *SQLiteStore) UpsertIdempotencyKey(ctx context.Context, key, requestHash
 	if err != sql.ErrNoRows {
 		return nil, false, fmt.Errorf("check idempotency: %w", err)
 	}
+	err = s.retryBusy(ctx, func() error {
+		_, err := s.db.ExecContext(ctx, qDeleteExpiredIdempotency, key, now)
+		return err
+	})
+	if err != nil {
+		return nil, false, fmt.Errorf("delete expired idempotency key: %w", err)
+	}
 	bodyJSON, _ := json.Marshal(responseBody)
-	_, err = s.db.ExecContext(ctx, qInsertIdempotency,
-		key, requestHash, targetID, responseCode, string(bodyJSON))
+	// Expiry is compared as text, so always use UTC
+	err = s.retryBusy(ctx, func() error {
+		_, err := s.db.ExecContext(ctx, qInsertIdempotency,
+			key, requestHash, targetID, responseCode, string(bodyJSON), formatTime(expiresAt.UTC()))
+		return err
+	})
 	if err != nil {
 		return nil, false, fmt.Errorf("insert idempotency: %w", err)
 	}
 
-	return &IdempotencyResponse{ResponseCode: responseCode, ResponseBody: responseBody}, true, nil
+	return &IdempotencyResponse{ResponseCode: responseCode, ResponseBody: responseBody, RequestHash: requestHash}, true, nil
 }
 
-// GetIdempotencyKey returns cached response if key exists
-func (s *SQLiteStore) GetIdempotencyKey(ctx context.Context, key string) (*IdempotencyResponse, bool, error) {
+// GetIdempotencyKey returns cached response, and the hash of the request
+// that produced it, if key exists and hasn't expired. An expired key is
+// deleted on the way.
+func (s *SQLiteStore) GetIdempotencyKey(ctx context.Context, key string) (_ *IdempotencyResponse, _ bool, err error) {
+	ctx, done := s.withTimeout(ctx)
+	defer done(&err)
+
 	var resp IdempotencyResponse
 	var rawBody string
-	err := s.db.QueryRowContext(ctx, qSelectIdempotency, key).
-		Scan(&resp.ResponseCode, &rawBody)
+	now := formatTime(time.Now().UTC())
+	err = s.db.QueryRowContext(ctx, qSelectIdempotency, key, now).
+		Scan(&resp.ResponseCode, &rawBody, &resp.RequestHash)
 
 	if err == sql.ErrNoRows {
+		if _, err := s.db.ExecContext(ctx, qDeleteExpiredIdempotency, key, now); err != nil {
+			return nil, false, fmt.Errorf("delete expired idempotency key: %w", err)
+		}
 		return nil, false, nil
 	}
 	if err != nil {
@@ -250,3 +2034,176 @@ func (s *SQLiteStore) GetIdempotencyKey(ctx context.Context, key string) (*Idemp
 
 	return &resp, true, nil
 }
+
+// RecanonicalizeTargets rewrites every target with the current canonicalization rules.
+// Targets that collapse to the same URL are merged into the oldest one, which inherits
+// their results and idempotency keys. Everything happens in a single transaction,
+// with the query timeout applied to each statement rather than the whole rewrite.
+func (s *SQLiteStore) RecanonicalizeTargets(ctx context.Context, canonicalize CanonicalizeFunc) (*RecanonicalizeReport, error) {
+	var report *RecanonicalizeReport
+	err := s.inTx(ctx, func(tx *SQLiteStore) error {
+		var err error
+		report, err = tx.recanonicalize(ctx, canonicalize)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+func (s *SQLiteStore) recanonicalize(ctx context.Context, canonicalize CanonicalizeFunc) (*RecanonicalizeReport, error) {
+	targets, err := s.selectAllTargets(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// Group by new canonical URL; targets arrive oldest first so the
+	// first member of each group is the survivor
+	type group struct {
+		url, host string
+		members   []*Target
+	}
+	report := &RecanonicalizeReport{Scanned: len(targets)}
+	groups := map[string]*group{}
+	var order []string
+
+	for _, t := range targets {
+		canonicalURL, host, err := canonicalize(t.URL)
+		if err != nil {
+			// Leave it as-is, but still let identical URLs merge into it
+			report.Skipped++
+			canonicalURL, host = t.URL, t.Host
+		}
+		g, exists := groups[canonicalURL]
+		if !exists {
+			g = &group{url: canonicalURL, host: host}
+			groups[canonicalURL] = g
+			order = append(order, canonicalURL)
+		}
+		g.members = append(g.members, t)
+	}
+
+	for _, key := range order {
+		g := groups[key]
+		survivor := g.members[0]
+
+		// Remove duplicates first so the survivor can take over their URL
+		for _, dup := range g.members[1:] {
+			if err := s.mergeTarget(ctx, survivor.ID, dup.ID); err != nil {
+				return nil, err
+			}
+			report.Merged++
+		}
+
+		if survivor.URL != g.url || survivor.Host != g.host {
+			if err := s.updateTargetURL(ctx, survivor.ID, g.url, g.host); err != nil {
+				return nil, err
+			}
+			report.Updated++
+		}
+	}
+
+	return report, nil
+}
+
+func (s *SQLiteStore) selectAllTargets(ctx context.Context) (_ []*Target, err error) {
+	ctx, done := s.withTimeout(ctx)
+	defer done(&err)
+
+	rows, err := s.db.QueryContext(ctx, qSelectAllTargets)
+	if err != nil {
+		return nil, fmt.Errorf("select targets: %w", err)
+	}
+	defer rows.Close()
+
+	var targets []*Target
+	for rows.Next() {
+		t, err := scanTarget(rows)
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, t)
+	}
+	return targets, rows.Err()
+}
+
+func (s *SQLiteStore) updateTargetURL(ctx context.Context, id, url, host string) (err error) {
+	ctx, done := s.withTimeout(ctx)
+	defer done(&err)
+
+	if _, err := s.db.ExecContext(ctx, qUpdateTargetURL, url, host, formatTime(time.Now()), id); err != nil {
+		return fmt.Errorf("update target %s: %w", id, err)
+	}
+	return nil
+}
+
+// mergeTarget moves everything owned by dupID onto survivorID and deletes dupID
+func (s *SQLiteStore) mergeTarget(ctx context.Context, survivorID, dupID string) (err error) {
+	ctx, done := s.withTimeout(ctx)
+	defer done(&err)
+
+	if _, err := s.db.ExecContext(ctx, qReassignResults, survivorID, dupID); err != nil {
+		return fmt.Errorf("reassign results of %s: %w", dupID, err)
+	}
+	if _, err := s.db.ExecContext(ctx, qReassignIdempotency, survivorID, dupID); err != nil {
+		return fmt.Errorf("reassign idempotency keys of %s: %w", dupID, err)
+	}
+	// The survivor's state stands; its next check accounts for the merged history
+	if _, err := s.db.ExecContext(ctx, qDeleteTargetState, dupID); err != nil {
+		return fmt.Errorf("delete state of %s: %w", dupID, err)
+	}
+	if _, err := s.db.ExecContext(ctx, qDeleteTarget, dupID); err != nil {
+		return fmt.Errorf("delete target %s: %w", dupID, err)
+	}
+	return nil
+}
+
+// AcquireLease takes or renews a named lease for holder, returning whether holder now owns it
+func (s *SQLiteStore) AcquireLease(ctx context.Context, name, holder string, ttl time.Duration) (_ bool, err error) {
+	ctx, done := s.withTimeout(ctx)
+	defer done(&err)
+
+	// Leases are compared as text across nodes, so always use UTC
+	now := time.Now().UTC()
+	res, err := s.db.ExecContext(ctx, qAcquireLease,
+		name, holder, formatTime(now.Add(ttl)), formatTime(now))
+	if err != nil {
+		return false, fmt.Errorf("acquire lease: %w", err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("acquire lease: %w", err)
+	}
+	return n > 0, nil
+}
+
+// ReleaseLease gives up a lease if holder still owns it
+func (s *SQLiteStore) ReleaseLease(ctx context.Context, name, holder string) (err error) {
+	ctx, done := s.withTimeout(ctx)
+	defer done(&err)
+
+	if _, err := s.db.ExecContext(ctx, qReleaseLease, name, holder); err != nil {
+		return fmt.Errorf("release lease: %w", err)
+	}
+	return nil
+}
+
+// Ping checks the database is reachable. Inside WithTx the transaction's
+// connection is already held, so a trivial query stands in for the ping.
+func (s *SQLiteStore) Ping(ctx context.Context) (err error) {
+	ctx, done := s.withTimeout(ctx)
+	defer done(&err)
+
+	if s.conn != nil {
+		err = s.conn.PingContext(ctx)
+	} else {
+		var one int
+		err = s.db.QueryRowContext(ctx, "SELECT 1").Scan(&one)
+	}
+	if err != nil {
+		return fmt.Errorf("ping database: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,3 @@
+-- Record which linkwatch node produced each check result
+
+ALTER TABLE check_results ADD COLUMN node_id TEXT NULL;
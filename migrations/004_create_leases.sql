@@ -0,0 +1,7 @@
+-- Leases let a single node hold a named role (e.g. the scheduler) at a time
+
+CREATE TABLE leases (
+  name TEXT PRIMARY KEY,
+  holder TEXT NOT NULL,
+  expires_at TEXT NOT NULL
+);
@@ -0,0 +1,4 @@
+-- Let on-call engineers acknowledge failing results
+
+ALTER TABLE check_results ADD COLUMN acknowledged INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE check_results ADD COLUMN ack_note TEXT NULL;
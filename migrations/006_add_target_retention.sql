@@ -0,0 +1,3 @@
+-- Per-target override of how long check results are kept
+
+ALTER TABLE targets ADD COLUMN retention_seconds INTEGER NULL;
@@ -0,0 +1,3 @@
+-- Check-type specific result fields stored as a JSON object
+
+ALTER TABLE check_results ADD COLUMN metadata TEXT NULL;
@@ -0,0 +1,3 @@
+-- Optional recurring window (JSON) outside of which a target isn't checked
+
+ALTER TABLE targets ADD COLUMN schedule TEXT NULL;
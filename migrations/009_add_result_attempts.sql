@@ -0,0 +1,3 @@
+-- How many tries a check took, counting retries of transient failures
+
+ALTER TABLE check_results ADD COLUMN attempts INTEGER NOT NULL DEFAULT 1;
@@ -0,0 +1,4 @@
+-- Where a check ended up after following redirects, and how many hops it took
+
+ALTER TABLE check_results ADD COLUMN final_url TEXT NULL;
+ALTER TABLE check_results ADD COLUMN redirect_count INTEGER NOT NULL DEFAULT 0;
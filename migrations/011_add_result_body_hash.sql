@@ -0,0 +1,3 @@
+-- SHA-256 of the response body, for noticing content changes
+
+ALTER TABLE check_results ADD COLUMN body_hash TEXT NULL;
@@ -0,0 +1,13 @@
+-- Leaf TLS certificate expiry as first-class columns, moved out of metadata
+
+ALTER TABLE check_results ADD COLUMN cert_expires_at TEXT NULL;
+ALTER TABLE check_results ADD COLUMN cert_days_remaining INTEGER NULL;
+
+UPDATE check_results
+SET cert_expires_at = strftime('%Y-%m-%dT%H:%M:%SZ', json_extract(metadata, '$.cert_expiry')),
+    cert_days_remaining = CAST(julianday(json_extract(metadata, '$.cert_expiry')) - julianday(checked_at) AS INTEGER)
+WHERE json_extract(metadata, '$.cert_expiry') IS NOT NULL;
+
+UPDATE check_results
+SET metadata = NULLIF(json_remove(metadata, '$.cert_expiry'), '{}')
+WHERE json_extract(metadata, '$.cert_expiry') IS NOT NULL;
@@ -0,0 +1,3 @@
+-- Extra request headers (JSON object) sent with every check of a target
+
+ALTER TABLE targets ADD COLUMN headers TEXT NULL;
@@ -0,0 +1,3 @@
+-- Status code that counts as up for a target instead of any 2xx/3xx
+
+ALTER TABLE targets ADD COLUMN expected_status INTEGER NULL;
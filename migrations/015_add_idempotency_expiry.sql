@@ -0,0 +1,7 @@
+-- Idempotency keys expire so a key reused much later starts afresh.
+-- Existing keys get the default TTL of a day from creation.
+
+ALTER TABLE idempotency_keys ADD COLUMN expires_at TEXT NULL;
+
+UPDATE idempotency_keys
+SET expires_at = strftime('%Y-%m-%dT%H:%M:%SZ', created_at, '+1 day');
@@ -0,0 +1,5 @@
+-- Optional body assertion: a regex the response must contain, or must not
+-- (match_mode 'absent'), for the check to count as up
+
+ALTER TABLE targets ADD COLUMN match_pattern TEXT NULL;
+ALTER TABLE targets ADD COLUMN match_mode TEXT NULL;
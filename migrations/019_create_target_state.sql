@@ -0,0 +1,10 @@
+-- Each target's current up/down state, updated with every stored result so
+-- alerting doesn't have to derive it from the history. since is when the
+-- current state was first observed. Rows appear as targets are next checked.
+
+CREATE TABLE target_state (
+  target_id TEXT PRIMARY KEY,
+  state TEXT NOT NULL,
+  since TEXT NOT NULL,
+  last_checked TEXT NOT NULL
+);
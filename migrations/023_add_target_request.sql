@@ -0,0 +1,5 @@
+-- The method a target is checked with, NULL for CHECK_METHOD, and the body
+-- sent with it, e.g. a POST to an API health endpoint.
+
+ALTER TABLE targets ADD COLUMN method TEXT NULL;
+ALTER TABLE targets ADD COLUMN request_body TEXT NULL;
@@ -0,0 +1,5 @@
+-- A target only goes down after failure_threshold consecutive failed checks,
+-- NULL for FAILURE_THRESHOLD. target_state counts the current streak.
+
+ALTER TABLE targets ADD COLUMN failure_threshold INTEGER NULL;
+ALTER TABLE target_state ADD COLUMN failures INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,5 @@
+-- When a target's URL or settings last changed; existing targets start
+-- from their creation time.
+
+ALTER TABLE targets ADD COLUMN updated_at TEXT NULL;
+UPDATE targets SET updated_at = created_at;
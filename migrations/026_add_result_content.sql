@@ -0,0 +1,5 @@
+-- What the server returned: its Content-Type header and the body size, from
+-- Content-Length or the bytes read. Results from before this migration have NULL.
+
+ALTER TABLE check_results ADD COLUMN content_type TEXT NULL;
+ALTER TABLE check_results ADD COLUMN content_length INTEGER NULL;
@@ -0,0 +1,6 @@
+-- With DEDUP_RESULTS, a check identical to the target's previous result bumps
+-- that row instead of adding one: occurrences counts the checks it stands for
+-- and last_seen is the latest of them. Older rows are single checks.
+
+ALTER TABLE check_results ADD COLUMN occurrences INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE check_results ADD COLUMN last_seen TEXT NULL;
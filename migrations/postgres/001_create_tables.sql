@@ -0,0 +1,54 @@
+-- PostgreSQL schema for LinkWatch, equivalent to SQLite migrations 002-014.
+-- Timestamps stay RFC3339 text so both backends share queries and parsing.
+-- Schema changes need a migration here as well as in the parent directory.
+
+CREATE TABLE targets (
+  id TEXT PRIMARY KEY,
+  url TEXT NOT NULL UNIQUE,
+  host TEXT NOT NULL,
+  created_at TEXT NOT NULL DEFAULT to_char(now() AT TIME ZONE 'UTC', 'YYYY-MM-DD"T"HH24:MI:SS"Z"'),
+  retention_seconds BIGINT NULL,
+  schedule TEXT NULL,
+  headers TEXT NULL,
+  expected_status INTEGER NULL
+);
+
+CREATE INDEX targets_host_created_idx
+  ON targets (host, created_at, id);
+
+CREATE TABLE check_results (
+  id BIGSERIAL PRIMARY KEY,
+  target_id TEXT NOT NULL REFERENCES targets(id) ON DELETE CASCADE,
+  checked_at TEXT NOT NULL,
+  status_code INTEGER NULL,
+  latency_ms INTEGER NOT NULL,
+  error TEXT NULL,
+  node_id TEXT NULL,
+  acknowledged INTEGER NOT NULL DEFAULT 0,
+  ack_note TEXT NULL,
+  metadata TEXT NULL,
+  attempts INTEGER NOT NULL DEFAULT 1,
+  final_url TEXT NULL,
+  redirect_count INTEGER NOT NULL DEFAULT 0,
+  body_hash TEXT NULL,
+  cert_expires_at TEXT NULL,
+  cert_days_remaining INTEGER NULL
+);
+
+CREATE INDEX results_target_checked_desc
+  ON check_results (target_id, checked_at DESC);
+
+CREATE TABLE idempotency_keys (
+  key TEXT PRIMARY KEY,
+  request_hash TEXT NOT NULL,
+  target_id TEXT NOT NULL REFERENCES targets(id),
+  response_code INTEGER NOT NULL,
+  response_body TEXT NOT NULL, -- store JSON as plain text
+  created_at TEXT NOT NULL DEFAULT to_char(now() AT TIME ZONE 'UTC', 'YYYY-MM-DD"T"HH24:MI:SS"Z"')
+);
+
+CREATE TABLE leases (
+  name TEXT PRIMARY KEY,
+  holder TEXT NOT NULL,
+  expires_at TEXT NOT NULL
+);
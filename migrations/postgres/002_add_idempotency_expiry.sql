@@ -0,0 +1,7 @@
+-- Idempotency keys expire so a key reused much later starts afresh.
+-- Existing keys get the default TTL of a day from creation.
+
+ALTER TABLE idempotency_keys ADD COLUMN expires_at TEXT NULL;
+
+UPDATE idempotency_keys
+SET expires_at = to_char((created_at::timestamptz + interval '1 day') AT TIME ZONE 'UTC', 'YYYY-MM-DD"T"HH24:MI:SS"Z"');
@@ -0,0 +1,7 @@
+-- When a target is next due, claimed atomically by whichever scheduler
+-- gets there first so several instances never check it twice.
+-- NULL means due now.
+
+ALTER TABLE targets ADD COLUMN next_check_at TEXT NULL;
+
+CREATE INDEX IF NOT EXISTS idx_targets_next_check_at ON targets(next_check_at);
@@ -0,0 +1,7 @@
+-- Per-phase latency breakdown of a check. Phases skipped on a reused
+-- connection are 0; results from before this migration have NULL.
+
+ALTER TABLE check_results ADD COLUMN dns_ms INTEGER NULL;
+ALTER TABLE check_results ADD COLUMN connect_ms INTEGER NULL;
+ALTER TABLE check_results ADD COLUMN tls_ms INTEGER NULL;
+ALTER TABLE check_results ADD COLUMN ttfb_ms INTEGER NULL;
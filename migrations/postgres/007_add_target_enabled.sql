@@ -0,0 +1,4 @@
+-- Paused targets keep their settings and history but aren't checked.
+-- 1 is enabled, 0 paused.
+
+ALTER TABLE targets ADD COLUMN enabled INTEGER NOT NULL DEFAULT 1;
@@ -0,0 +1,4 @@
+-- A proxy URL to check the target through instead of HTTP_PROXY_URL,
+-- e.g. a regional proxy for a geo-specific endpoint.
+
+ALTER TABLE targets ADD COLUMN proxy_url TEXT NULL;
@@ -0,0 +1,4 @@
+-- Targets with self-signed certificates can opt out of verification.
+-- 1 skips it, 0 verifies.
+
+ALTER TABLE targets ADD COLUMN insecure_skip_verify INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,5 @@
+-- A target's maintenance window: checks inside it still run, but their
+-- results are tagged in_maintenance and state changes don't notify.
+
+ALTER TABLE targets ADD COLUMN maintenance TEXT NULL;
+ALTER TABLE check_results ADD COLUMN in_maintenance INTEGER NOT NULL DEFAULT 0;